@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,13 +21,46 @@ import (
 func main() {
 	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		cfg := config.GetConfigServer()
+		if err := migrate(cfg, flag.Args(), l); err != nil {
+			l.Fatal().Msgf("Migration error: %s", err)
+		}
+		return
+	}
+
 	cfg := config.GetConfigServer()
 
-	s, dbErr := storage.NewStorage(cfg.DBDsn)
+	policy, policyErr := storage.ParseConflictPolicy(cfg.TypeConflict)
+	if policyErr != nil {
+		l.Fatal().Msg(policyErr.Error())
+	}
+
+	s, dbErr := storage.NewStorage(cfg.DBDsn, policy, cfg.MemShards, storage.DBOptions{
+		MaxOpenConns:     cfg.DBMaxOpenConns,
+		MaxIdleConns:     cfg.DBMaxIdleConns,
+		ConnMaxLifetime:  cfg.DBConnMaxLifetime,
+		ConnMaxIdleTime:  cfg.DBConnMaxIdleTime,
+		QueryTimeout:     cfg.DBQueryTimeout,
+		Retries:          cfg.DBRetries,
+		BreakerThreshold: cfg.DBBreakerThreshold,
+		BreakerCooldown:  cfg.DBBreakerCooldown,
+	})
 	if dbErr != nil {
 		l.Fatal().Msg(dbErr.Error())
 	}
 
+	if cfg.NeedCache() {
+		if s, dbErr = storage.NewCachedStorage(s, cfg.CacheSize, cfg.CacheTTL); dbErr != nil {
+			l.Fatal().Msg(dbErr.Error())
+		}
+	}
+
+	if cfg.NeedWriteBuffer() {
+		s = storage.NewBufferedStorage(s, cfg.WriteBufferSize, cfg.WriteBufferInterval)
+	}
+
 	app := application.NewApp(s, cfg, l)
 
 	go func() {
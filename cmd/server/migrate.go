@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"github.com/1g0rbm/sysmonitor/internal/config"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+func migrate(cfg *config.ServerConfig, args []string, l zerolog.Logger) (err error) {
+	if cfg.DBDsn == "" || storage.IsBoltDSN(cfg.DBDsn) {
+		return fmt.Errorf("migrations require a postgres dsn")
+	}
+
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+	}
+
+	steps := 1
+	if len(args) > 1 {
+		if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+			return fmt.Errorf("invalid number of steps '%s'", args[1])
+		}
+	}
+
+	db, err := sql.Open("pgx", cfg.DBDsn)
+	if err != nil {
+		return err
+	}
+
+	defer func(db *sql.DB) {
+		if closeErr := db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}(db)
+
+	mg, err := storage.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	var ms []storage.Migration
+	switch direction {
+	case "up":
+		ms, err = mg.Up(context.Background())
+	case "down":
+		ms, err = mg.Down(context.Background(), steps)
+	default:
+		return fmt.Errorf("unknown migrate command '%s', expected 'up' or 'down'", direction)
+	}
+
+	for _, m := range ms {
+		l.Info().Msgf("Migration %s %04d_%s", direction, m.Version, m.Name)
+	}
+	if err == nil && len(ms) == 0 {
+		l.Info().Msg("No migrations to run")
+	}
+
+	return err
+}
@@ -0,0 +1,165 @@
+package agents
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	IDHeader             = "X-Agent-ID"
+	ReportIntervalHeader = "X-Report-Interval"
+)
+
+const (
+	defaultDeadAfter = 3
+	staleAfter       = 2
+	maxEvents        = 100
+)
+
+type Status string
+
+const (
+	StatusHealthy Status = "healthy"
+	StatusStale   Status = "stale"
+	StatusDead    Status = "dead"
+)
+
+type Agent struct {
+	ID             string        `json:"id"`
+	Address        string        `json:"address"`
+	LastSeen       time.Time     `json:"last_seen"`
+	ReportInterval time.Duration `json:"report_interval"`
+	Reports        int64         `json:"reports"`
+	Status         Status        `json:"status"`
+}
+
+type Event struct {
+	Agent    string    `json:"agent"`
+	Status   Status    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Time     time.Time `json:"time"`
+}
+
+type Registry struct {
+	agents          map[string]*Agent
+	events          []Event
+	defaultInterval time.Duration
+	deadAfter       int
+	now             func() time.Time
+	mu              sync.RWMutex
+}
+
+func NewRegistry(defaultInterval time.Duration, deadAfter int) *Registry {
+	if deadAfter <= 0 {
+		deadAfter = defaultDeadAfter
+	}
+
+	return &Registry{
+		agents:          make(map[string]*Agent),
+		defaultInterval: defaultInterval,
+		deadAfter:       deadAfter,
+		now:             time.Now,
+	}
+}
+
+func (r *Registry) Seen(id string, address string, interval time.Duration) {
+	now := r.now()
+	if interval <= 0 {
+		interval = r.defaultInterval
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.agents[id]
+	if !ok {
+		a = &Agent{ID: id, Status: StatusHealthy}
+		r.agents[id] = a
+	}
+
+	if a.Status == StatusDead {
+		r.addEvent(Event{Agent: id, Status: StatusHealthy, LastSeen: now, Time: now})
+	}
+
+	a.Address = address
+	a.LastSeen = now
+	a.ReportInterval = interval
+	a.Reports++
+	a.Status = StatusHealthy
+}
+
+func (r *Registry) Check() []Event {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []Event
+	for _, a := range r.agents {
+		status := r.status(a, now)
+		if status == a.Status {
+			continue
+		}
+
+		if status == StatusDead {
+			e := Event{Agent: a.ID, Status: StatusDead, LastSeen: a.LastSeen, Time: now}
+			r.addEvent(e)
+			events = append(events, e)
+		}
+		a.Status = status
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Agent < events[j].Agent
+	})
+
+	return events
+}
+
+func (r *Registry) List() []Agent {
+	now := r.now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]Agent, 0, len(r.agents))
+	for _, a := range r.agents {
+		agent := *a
+		agent.Status = r.status(a, now)
+		result = append(result, agent)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result
+}
+
+func (r *Registry) Events() []Event {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append(make([]Event, 0, len(r.events)), r.events...)
+}
+
+func (r *Registry) status(a *Agent, now time.Time) Status {
+	silence := now.Sub(a.LastSeen)
+
+	switch {
+	case silence >= time.Duration(r.deadAfter)*a.ReportInterval:
+		return StatusDead
+	case silence >= staleAfter*a.ReportInterval:
+		return StatusStale
+	default:
+		return StatusHealthy
+	}
+}
+
+func (r *Registry) addEvent(e Event) {
+	r.events = append(r.events, e)
+	if len(r.events) > maxEvents {
+		r.events = r.events[len(r.events)-maxEvents:]
+	}
+}
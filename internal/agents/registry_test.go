@@ -0,0 +1,83 @@
+package agents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryStatus(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		silence time.Duration
+		want    Status
+	}{
+		{
+			name:    "Agent reported within interval is healthy",
+			silence: 5 * time.Second,
+			want:    StatusHealthy,
+		},
+		{
+			name:    "Agent missed two reports is stale",
+			silence: 20 * time.Second,
+			want:    StatusStale,
+		},
+		{
+			name:    "Agent silent for dead-after intervals is dead",
+			silence: 30 * time.Second,
+			want:    StatusDead,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			r := NewRegistry(10*time.Second, 3)
+			r.now = func() time.Time { return now }
+
+			r.Seen("host-1", "10.0.0.1", 0)
+			now = start.Add(tt.silence)
+
+			agents := r.List()
+			require.Len(t, agents, 1)
+			assert.Equal(t, tt.want, agents[0].Status)
+			assert.Equal(t, 10*time.Second, agents[0].ReportInterval)
+			assert.Equal(t, start, agents[0].LastSeen)
+		})
+	}
+}
+
+func TestRegistryEvents(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	r := NewRegistry(10*time.Second, 3)
+	r.now = func() time.Time { return now }
+
+	r.Seen("host-1", "10.0.0.1", time.Second)
+	r.Seen("host-2", "10.0.0.2", time.Minute)
+
+	now = start.Add(2 * time.Second)
+	assert.Empty(t, r.Check())
+
+	now = start.Add(3 * time.Second)
+	events := r.Check()
+	require.Len(t, events, 1)
+	assert.Equal(t, Event{Agent: "host-1", Status: StatusDead, LastSeen: start, Time: now}, events[0])
+
+	now = start.Add(4 * time.Second)
+	assert.Empty(t, r.Check(), "dead event should be raised once")
+
+	r.Seen("host-1", "10.0.0.1", time.Second)
+	all := r.Events()
+	require.Len(t, all, 2)
+	assert.Equal(t, StatusHealthy, all[1].Status)
+
+	agents := r.List()
+	require.Len(t, agents, 2)
+	assert.Equal(t, StatusHealthy, agents[0].Status)
+	assert.Equal(t, int64(2), agents[0].Reports)
+}
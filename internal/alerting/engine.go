@@ -0,0 +1,236 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+)
+
+const defaultResolvedRetention = 15 * time.Minute
+
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+type Alert struct {
+	Rule        string            `json:"rule"`
+	State       State             `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       float64           `json:"value"`
+	ActiveAt    time.Time         `json:"active_at"`
+	FiredAt     *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time        `json:"resolved_at,omitempty"`
+}
+
+type Engine struct {
+	rules             []Rule
+	alerts            map[string]*Alert
+	resolvedRetention time.Duration
+	now               func() time.Time
+	mu                sync.RWMutex
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		alerts:            make(map[string]*Alert),
+		resolvedRetention: defaultResolvedRetention,
+		now:               time.Now,
+	}
+}
+
+func ParseState(s string) (State, error) {
+	switch st := State(s); st {
+	case StatePending, StateFiring, StateResolved:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown alert state %q", s)
+	}
+}
+
+func (e *Engine) SetRules(rules []Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rules = rules
+
+	known := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		known[r.Name] = struct{}{}
+	}
+	for k, a := range e.alerts {
+		if _, ok := known[a.Rule]; !ok {
+			delete(e.alerts, k)
+		}
+	}
+}
+
+func (e *Engine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return append([]Rule(nil), e.rules...)
+}
+
+func (e *Engine) Eval(src expr.Source) error {
+	now := e.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var errs []string
+	for _, r := range e.rules {
+		if err := e.evalRule(r, src, now); err != nil {
+			errs = append(errs, fmt.Sprintf("alert %q: %s", r.Name, err))
+		}
+	}
+
+	for k, a := range e.alerts {
+		if a.State == StateResolved && now.Sub(*a.ResolvedAt) >= e.resolvedRetention {
+			delete(e.alerts, k)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (e *Engine) evalRule(r Rule, src expr.Source, now time.Time) error {
+	v, err := r.expr.Eval(src, now)
+	if err != nil {
+		return err
+	}
+
+	var samples expr.Vector
+	switch v := v.(type) {
+	case expr.Vector:
+		samples = v
+	case expr.Scalar:
+		if v != 0 {
+			samples = expr.Vector{{Labels: expr.Labels{}, Value: float64(v)}}
+		}
+	default:
+		return fmt.Errorf("unexpected result type %s", v.Type())
+	}
+
+	active := make(map[string]struct{}, len(samples))
+	for _, s := range samples {
+		labels := r.alertLabels(s.Labels)
+		k := alertKey(labels)
+		active[k] = struct{}{}
+
+		a, ok := e.alerts[k]
+		if !ok || a.State == StateResolved {
+			a = &Alert{Rule: r.Name, State: StatePending, Labels: labels, ActiveAt: now}
+			e.alerts[k] = a
+		}
+		a.Value = s.Value
+		a.Annotations = r.expandAnnotations(labels, s.Value)
+
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= r.For {
+			a.State = StateFiring
+			a.FiredAt = &now
+		}
+	}
+
+	for k, a := range e.alerts {
+		if a.Rule != r.Name {
+			continue
+		}
+		if _, ok := active[k]; ok {
+			continue
+		}
+
+		switch a.State {
+		case StatePending:
+			delete(e.alerts, k)
+		case StateFiring:
+			a.State = StateResolved
+			a.ResolvedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) Alerts(states ...State) []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		if len(states) > 0 && !hasState(states, a.State) {
+			continue
+		}
+		result = append(result, *a)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Rule != result[j].Rule {
+			return result[i].Rule < result[j].Rule
+		}
+		return alertKey(result[i].Labels) < alertKey(result[j].Labels)
+	})
+
+	return result
+}
+
+func (e *Engine) Save(path string) error {
+	return fs.WriteState(path, e.Alerts())
+}
+
+func (e *Engine) Restore(path string) error {
+	var alerts []Alert
+	if err := fs.ReadState(path, &alerts); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	known := make(map[string]struct{}, len(e.rules))
+	for _, r := range e.rules {
+		known[r.Name] = struct{}{}
+	}
+
+	for i := range alerts {
+		a := alerts[i]
+		if _, ok := known[a.Rule]; !ok {
+			continue
+		}
+		e.alerts[alertKey(a.Labels)] = &a
+	}
+
+	return nil
+}
+
+func hasState(states []State, s State) bool {
+	for _, st := range states {
+		if st == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func alertKey(labels map[string]string) string {
+	return expr.Labels(labels).String()
+}
@@ -0,0 +1,158 @@
+package alerting
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+)
+
+type testSource map[string]float64
+
+func (s testSource) Select(matchers []*expr.Matcher) (expr.Vector, error) {
+	var result expr.Vector
+	for name, v := range s {
+		l := expr.Labels{expr.MetricNameLabel: name, expr.TypeLabel: "gauge"}
+		if expr.MatchAll(matchers, l) {
+			result = append(result, expr.Sample{Labels: l, Value: v})
+		}
+	}
+
+	return result, nil
+}
+
+func (s testSource) SelectRange(_ []*expr.Matcher, _ time.Time, _ time.Time) (expr.Matrix, error) {
+	return nil, nil
+}
+
+const testRules = `
+rules:
+  - alert: HighCPU
+    expr: CPUutilization1 > 90
+    for: 5m
+    labels:
+      severity: critical
+    annotations:
+      summary: "CPU is {{ .Value }}%"
+  - alert: LowMemory
+    expr: FreeMemory / TotalMemory < 0.1
+`
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "Valid rules",
+			rules: testRules,
+			want:  2,
+		},
+		{
+			name:    "Invalid expression",
+			rules:   "rules:\n  - alert: Broken\n    expr: CPUutilization1 >\n",
+			wantErr: true,
+		},
+		{
+			name:    "Missing alert name",
+			rules:   "rules:\n  - expr: CPUutilization1 > 90\n",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate alert name",
+			rules:   "rules:\n  - alert: A\n    expr: X > 1\n  - alert: A\n    expr: Y > 1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules([]byte(tt.rules))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, rules, tt.want)
+		})
+	}
+}
+
+func TestEngineEval(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	e := NewEngine()
+	e.now = func() time.Time { return now }
+	e.SetRules(rules)
+
+	src := testSource{"CPUutilization1": 95, "FreeMemory": 5, "TotalMemory": 100}
+
+	require.NoError(t, e.Eval(src))
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "HighCPU", alerts[0].Rule)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, map[string]string{AlertNameLabel: "HighCPU", "type": "gauge", "severity": "critical"}, alerts[0].Labels)
+	assert.Equal(t, "CPU is 95%", alerts[0].Annotations["summary"])
+	assert.Equal(t, "LowMemory", alerts[1].Rule)
+	assert.Equal(t, StateFiring, alerts[1].State)
+
+	now = start.Add(5 * time.Minute)
+	require.NoError(t, e.Eval(src))
+	assert.Len(t, e.Alerts(StateFiring), 2)
+
+	src["CPUutilization1"] = 10
+	now = start.Add(6 * time.Minute)
+	require.NoError(t, e.Eval(src))
+	resolved := e.Alerts(StateResolved)
+	require.Len(t, resolved, 1)
+	assert.Equal(t, "HighCPU", resolved[0].Rule)
+	assert.Equal(t, now, *resolved[0].ResolvedAt)
+
+	now = now.Add(defaultResolvedRetention)
+	require.NoError(t, e.Eval(src))
+	assert.Empty(t, e.Alerts(StateResolved))
+
+	src["CPUutilization1"] = 99
+	require.NoError(t, e.Eval(src))
+	src["CPUutilization1"] = 1
+	require.NoError(t, e.Eval(src))
+	assert.Empty(t, e.Alerts(StatePending), "pending alert should be dropped once condition clears")
+}
+
+func TestEngineSaveRestore(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "alerts.json")
+
+	e := NewEngine()
+	e.now = func() time.Time { return start }
+	e.SetRules(rules)
+	require.NoError(t, e.Eval(testSource{"CPUutilization1": 95}))
+	require.NoError(t, e.Save(path))
+
+	restored := NewEngine()
+	restored.now = func() time.Time { return start.Add(5 * time.Minute) }
+	restored.SetRules(rules)
+	require.NoError(t, restored.Restore(path))
+	assert.Equal(t, e.Alerts(), restored.Alerts())
+
+	require.NoError(t, restored.Eval(testSource{"CPUutilization1": 95}))
+	firing := restored.Alerts(StateFiring)
+	require.Len(t, firing, 1)
+	assert.Equal(t, start, firing[0].ActiveAt)
+
+	assert.NoError(t, NewEngine().Restore(filepath.Join(t.TempDir(), "missing.json")))
+}
@@ -0,0 +1,125 @@
+package alerting
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+)
+
+const AlertNameLabel = "alertname"
+
+type Rule struct {
+	Name        string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         time.Duration     `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	expr        *expr.Expr
+	annotations map[string]*template.Template
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+type templateData struct {
+	Labels map[string]string
+	Value  float64
+}
+
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRules(b)
+}
+
+func ParseRules(b []byte) ([]Rule, error) {
+	var f rulesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(f.Rules))
+	for i := range f.Rules {
+		r := &f.Rules[i]
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("rule %d: duplicate alert name %q", i, r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+
+	return f.Rules, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert name is required")
+	}
+	if r.For < 0 {
+		return fmt.Errorf("alert %q: for must not be negative", r.Name)
+	}
+
+	e, err := expr.Parse(r.Expr)
+	if err != nil {
+		return fmt.Errorf("alert %q: %w", r.Name, err)
+	}
+	r.expr = e
+
+	r.annotations = make(map[string]*template.Template, len(r.Annotations))
+	for k, v := range r.Annotations {
+		t, err := template.New(k).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return fmt.Errorf("alert %q: annotation %q: %w", r.Name, k, err)
+		}
+		r.annotations[k] = t
+	}
+
+	return nil
+}
+
+func (r Rule) alertLabels(l expr.Labels) map[string]string {
+	result := make(map[string]string, len(l)+len(r.Labels)+1)
+	for k, v := range l {
+		if k != expr.MetricNameLabel {
+			result[k] = v
+		}
+	}
+	for k, v := range r.Labels {
+		result[k] = v
+	}
+	result[AlertNameLabel] = r.Name
+
+	return result
+}
+
+func (r Rule) expandAnnotations(labels map[string]string, value float64) map[string]string {
+	if len(r.annotations) == 0 {
+		return nil
+	}
+
+	data := templateData{Labels: labels, Value: value}
+	result := make(map[string]string, len(r.annotations))
+	for k, t := range r.annotations {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			result[k] = r.Annotations[k]
+			continue
+		}
+		result[k] = buf.String()
+	}
+
+	return result
+}
@@ -0,0 +1,152 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const (
+	ScoreMetric   = "anomaly_score"
+	MetricLabel   = "metric"
+	defaultAlpha  = 0.1
+	defaultWarmup = 10
+	maxAnomalies  = 1000
+)
+
+type Anomaly struct {
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+	Value  float64   `json:"value"`
+	Mean   float64   `json:"mean"`
+	StdDev float64   `json:"stddev"`
+	Score  float64   `json:"score"`
+}
+
+type Score struct {
+	Name  string
+	Value float64
+}
+
+type stats struct {
+	mean     float64
+	variance float64
+	count    int
+	score    float64
+}
+
+type Detector struct {
+	series    map[string]*stats
+	anomalies []Anomaly
+	threshold float64
+	alpha     float64
+	warmup    int
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+func NewDetector(threshold float64) *Detector {
+	return &Detector{
+		series:    make(map[string]*stats),
+		threshold: threshold,
+		alpha:     defaultAlpha,
+		warmup:    defaultWarmup,
+		now:       time.Now,
+	}
+}
+
+func (d *Detector) Observe(ms ...metric.IMetric) {
+	t := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, m := range ms {
+		g, ok := m.(metric.GaugeMetric)
+		if !ok {
+			continue
+		}
+
+		v := float64(g.Value())
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		s, ok := d.series[g.Name()]
+		if !ok {
+			d.series[g.Name()] = &stats{mean: v, count: 1}
+			continue
+		}
+
+		std := math.Sqrt(s.variance)
+		s.score = 0
+		if std > 0 {
+			s.score = (v - s.mean) / std
+		}
+
+		if s.count >= d.warmup && math.Abs(s.score) >= d.threshold {
+			d.anomalies = append(d.anomalies, Anomaly{
+				Name:   g.Name(),
+				Time:   t,
+				Value:  v,
+				Mean:   s.mean,
+				StdDev: std,
+				Score:  s.score,
+			})
+			if len(d.anomalies) > maxAnomalies {
+				d.anomalies = d.anomalies[len(d.anomalies)-maxAnomalies:]
+			}
+		}
+
+		diff := v - s.mean
+		s.mean += d.alpha * diff
+		s.variance = (1 - d.alpha) * (s.variance + d.alpha*diff*diff)
+		s.count++
+	}
+}
+
+func (d *Detector) Anomalies(name string, since time.Time) []Anomaly {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i := sort.Search(len(d.anomalies), func(i int) bool {
+		return !d.anomalies[i].Time.Before(since)
+	})
+
+	result := make([]Anomaly, 0, len(d.anomalies)-i)
+	for _, a := range d.anomalies[i:] {
+		if name == "" || a.Name == name {
+			result = append(result, a)
+		}
+	}
+
+	return result
+}
+
+func (d *Detector) Scores() []Score {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := make([]Score, 0, len(d.series))
+	for name, s := range d.series {
+		if s.count > d.warmup {
+			result = append(result, Score{Name: name, Value: s.score})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+func (d *Detector) Delete(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.series, name)
+}
@@ -0,0 +1,86 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestDetectorObserve(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []float64
+	}{
+		{
+			name:   "Stable series has no anomalies",
+			values: []float64{10, 11, 10, 9, 10, 11, 10, 9, 10, 11, 10, 9, 10},
+		},
+		{
+			name:   "Spike after warmup is flagged",
+			values: []float64{10, 11, 10, 9, 10, 11, 10, 9, 10, 11, 10, 1000},
+			want:   []float64{1000},
+		},
+		{
+			name:   "Spike during warmup is ignored",
+			values: []float64{10, 11, 1000, 9, 10},
+		},
+		{
+			name:   "Constant series is never flagged",
+			values: []float64{5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+			tick := 0
+
+			d := NewDetector(4)
+			d.now = func() time.Time { return start.Add(time.Duration(tick) * time.Second) }
+
+			for _, v := range tt.values {
+				d.Observe(metric.NewGaugeMetric("HeapObjects", metric.Gauge(v)), metric.NewCounterMetric("PollCount", 1))
+				tick++
+			}
+
+			var got []float64
+			for _, a := range d.Anomalies("", time.Time{}) {
+				assert.Equal(t, "HeapObjects", a.Name)
+				assert.GreaterOrEqual(t, a.Score, 4.0)
+				got = append(got, a.Value)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDetectorScoresAndFilters(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	tick := 0
+
+	d := NewDetector(3)
+	d.now = func() time.Time { return start.Add(time.Duration(tick) * time.Second) }
+
+	for i := 0; i < 20; i++ {
+		d.Observe(metric.NewGaugeMetric("Alloc", metric.Gauge(100+i%2)), metric.NewGaugeMetric("Free", metric.Gauge(50+i%3)))
+		tick++
+	}
+	d.Observe(metric.NewGaugeMetric("Alloc", 1000), metric.NewGaugeMetric("Free", 1))
+
+	scores := d.Scores()
+	require.Len(t, scores, 2)
+	assert.Equal(t, "Alloc", scores[0].Name)
+	assert.Greater(t, scores[0].Value, 3.0)
+	assert.Less(t, scores[1].Value, -3.0)
+
+	assert.Len(t, d.Anomalies("", start), 2)
+	assert.Len(t, d.Anomalies("Alloc", start), 1)
+	assert.Empty(t, d.Anomalies("", start.Add(time.Hour)))
+
+	d.Delete("Alloc")
+	assert.Len(t, d.Scores(), 1)
+}
@@ -0,0 +1,61 @@
+package application
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/agents"
+)
+
+func (app App) getAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(app.agents.List())
+	if err != nil {
+		app.logger.Error().Msgf("Agents marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) getAgentEventsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(app.agents.Events())
+	if err != nil {
+		app.logger.Error().Msgf("Agent events marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) agentSeen(r *http.Request) {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		address = host
+	}
+
+	id := r.Header.Get(agents.IDHeader)
+	if id == "" {
+		id = address
+	}
+
+	var interval time.Duration
+	if v := r.Header.Get(agents.ReportIntervalHeader); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			app.logger.Error().Msgf("Invalid report interval header %q from agent %s", v, id)
+		}
+		interval = d
+	}
+
+	app.agents.Seen(id, address, interval)
+}
+
+func (app App) checkAgents() {
+	for _, e := range app.agents.Check() {
+		app.logger.Warn().Msgf("Agent %s is %s: no reports since %s", e.Agent, e.Status, e.LastSeen.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,31 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+func (app App) getAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	var since time.Time
+	if s := params.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			app.logger.Error().Msgf("Invalid since param: %s", s)
+			sendJSONResponse(w, http.StatusBadRequest, []byte("invalid since param"), app.logger)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	b, err := json.Marshal(app.anomalies.Anomalies(params.Get("name"), since))
+	if err != nil {
+		app.logger.Error().Msgf("Anomalies marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
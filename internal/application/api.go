@@ -0,0 +1,227 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+type deleteResult struct {
+	Deleted []string `json:"deleted"`
+}
+
+type metricsPage struct {
+	Metrics    []metric.Metrics `json:"metrics"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func (app App) listMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	after, cErr := storage.DecodeCursor(params.Get("cursor"))
+	if cErr != nil {
+		app.logger.Error().Msgf("Cursor decode error: %s", cErr)
+		sendJSONResponse(w, http.StatusBadRequest, []byte(cErr.Error()), app.logger)
+		return
+	}
+
+	limit := metricOnPage
+	if l := params.Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v <= 0 || v > maxMetricOnPage {
+			app.logger.Error().Msgf("Invalid limit param: %s", l)
+			sendJSONResponse(w, http.StatusBadRequest, []byte("invalid limit param"), app.logger)
+			return
+		}
+		limit = v
+	}
+
+	mType := params.Get("type")
+	if mType != "" && mType != metric.GaugeType && mType != metric.CounterType {
+		app.logger.Error().Msgf("Invalid type param: %s", mType)
+		sendJSONResponse(w, http.StatusBadRequest, []byte("invalid type param"), app.logger)
+		return
+	}
+
+	ms, err := storage.FindContext(r.Context(), app.storage, storage.Query{
+		After:  after,
+		Limit:  limit + 1,
+		Type:   mType,
+		Prefix: params.Get("prefix"),
+		Match:  params.Get("match"),
+	})
+	if errors.Is(err, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Error while getting metrics list: %s", err)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
+	if err != nil {
+		app.logger.Error().Msgf("Error while getting metrics list: %s", err)
+		sendJSONResponse(w, http.StatusBadRequest, []byte(err.Error()), app.logger)
+		return
+	}
+
+	page := metricsPage{Metrics: make([]metric.Metrics, 0, len(ms))}
+	if len(ms) > limit {
+		ms = ms[:limit]
+		page.NextCursor = storage.CursorOf(ms[len(ms)-1]).Encode()
+	}
+
+	for _, m := range ms {
+		rm, rmErr := metric.NewMetricsFromIMetric(m)
+		if rmErr != nil {
+			app.logger.Error().Msgf("Metric convert error %s", rmErr)
+			sendJSONResponse(w, http.StatusInternalServerError, []byte("internal error"), app.logger)
+			return
+		}
+		page.Metrics = append(page.Metrics, rm)
+	}
+
+	b, mErr := json.Marshal(page)
+	if mErr != nil {
+		app.logger.Error().Msgf("Metrics marshaling error: %s", mErr)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) deleteMetricHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	if err := storage.DeleteContext(r.Context(), app.storage, mName); err != nil {
+		if errors.Is(err, storage.ErrMetricNotFound) {
+			app.logger.Error().Msgf("Metric delete error: %s", err)
+			sendJSONResponse(w, http.StatusNotFound, []byte(err.Error()), app.logger)
+			return
+		}
+		if errors.Is(err, storage.ErrUnavailable) {
+			app.logger.Error().Msgf("Metric delete error: %s", err)
+			sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+			return
+		}
+		app.logger.Error().Msgf("Metric delete error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("delete error"), app.logger)
+		return
+	}
+
+	app.afterDelete(mName)
+	app.sendDeleteResult(w, []string{mName})
+}
+
+func (app App) deleteMatchingMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("match")
+	if pattern == "" {
+		app.logger.Error().Msg("Empty match param for metrics delete")
+		sendJSONResponse(w, http.StatusBadRequest, []byte("match param is required"), app.logger)
+		return
+	}
+
+	deleted, err := storage.DeleteMatchingContext(r.Context(), app.storage, pattern)
+	if errors.Is(err, storage.ErrInvalidGlob) {
+		app.logger.Error().Msgf("Metrics delete error: %s", err)
+		sendJSONResponse(w, http.StatusBadRequest, []byte(err.Error()), app.logger)
+		return
+	}
+	if errors.Is(err, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Metrics delete error: %s", err)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
+	if err != nil {
+		app.logger.Error().Msgf("Metrics delete error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("delete error"), app.logger)
+		return
+	}
+
+	app.afterDelete(deleted...)
+	app.sendDeleteResult(w, deleted)
+}
+
+func (app App) sendDeleteResult(w http.ResponseWriter, deleted []string) {
+	b, err := json.Marshal(deleteResult{Deleted: deleted})
+	if err != nil {
+		app.logger.Error().Msgf("Delete result marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) getMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	mds, err := storage.FindMetadataContext(r.Context(), app.storage, []string{mName})
+	if errors.Is(err, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Metadata find error: %s", err)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
+	if err != nil {
+		app.logger.Error().Msgf("Metadata find error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal error"), app.logger)
+		return
+	}
+
+	app.sendMetadata(w, mds[mName])
+}
+
+func (app App) setMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	var md metric.Metadata
+	if err := json.NewDecoder(r.Body).Decode(&md); err != nil {
+		app.logger.Error().Msgf("Metadata decode error: %s", err)
+		sendJSONResponse(w, http.StatusBadRequest, []byte(err.Error()), app.logger)
+		return
+	}
+
+	if err := app.updateMetadata(r.Context(), map[string]metric.Metadata{mName: md}); err != nil {
+		if errors.Is(err, storage.ErrUnavailable) {
+			app.logger.Error().Msgf("Metadata update error: %s", err)
+			sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+			return
+		}
+		app.logger.Error().Msgf("Metadata update error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("update error"), app.logger)
+		return
+	}
+
+	app.getMetadataHandler(w, r)
+}
+
+func (app App) deleteMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	if err := storage.DeleteMetadataContext(r.Context(), app.storage, mName); err != nil {
+		if errors.Is(err, storage.ErrUnavailable) {
+			app.logger.Error().Msgf("Metadata delete error: %s", err)
+			sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+			return
+		}
+		app.logger.Error().Msgf("Metadata delete error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("delete error"), app.logger)
+		return
+	}
+
+	app.sendMetadata(w, metric.Metadata{})
+}
+
+func (app App) sendMetadata(w http.ResponseWriter, md metric.Metadata) {
+	b, err := json.Marshal(md)
+	if err != nil {
+		app.logger.Error().Msgf("Metadata marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
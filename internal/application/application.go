@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"time"
 
@@ -12,32 +13,72 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
 
+	"github.com/1g0rbm/sysmonitor/internal/agents"
+	"github.com/1g0rbm/sysmonitor/internal/alerting"
+	"github.com/1g0rbm/sysmonitor/internal/anomaly"
 	"github.com/1g0rbm/sysmonitor/internal/config"
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/history"
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 	localmiddleware "github.com/1g0rbm/sysmonitor/internal/middleware"
+	"github.com/1g0rbm/sysmonitor/internal/notify"
+	"github.com/1g0rbm/sysmonitor/internal/recording"
+	"github.com/1g0rbm/sysmonitor/internal/replication"
 	"github.com/1g0rbm/sysmonitor/internal/storage"
+	"github.com/1g0rbm/sysmonitor/internal/stream"
 )
 
 const (
-	metricOnPage = 100
-	offset       = 0
+	metricOnPage        = 100
+	maxMetricOnPage     = 1000
+	maxExpireInterval   = 30 * time.Second
+	minExpireInterval   = time.Second
+	expireIntervalRatio = 10
+	compactInterval     = time.Minute
 )
 
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
 type App struct {
-	storage storage.Storage
-	router  *chi.Mux
-	config  *config.ServerConfig
-	server  *http.Server
-	logger  zerolog.Logger
+	storage        storage.Storage
+	hub            *stream.Hub
+	history        *history.History
+	alerts         *alerting.Engine
+	recorder       *recording.Recorder
+	notifier       *notify.Notifier
+	agents         *agents.Registry
+	anomalies      *anomaly.Detector
+	replicationLog *replication.Log
+	replica        *replication.Follower
+	templates      *template.Template
+	router         *chi.Mux
+	config         *config.ServerConfig
+	server         *http.Server
+	logger         zerolog.Logger
 }
 
 func NewApp(s storage.Storage, cfg *config.ServerConfig, l zerolog.Logger) (app *App) {
 	r := chi.NewRouter()
+	retention := history.Retention{
+		Raw:    cfg.RawRetention,
+		Minute: cfg.MinuteRetention,
+		Hour:   cfg.HourRetention,
+	}
 
 	app = &App{
-		storage: s,
-		config:  cfg,
-		router:  r,
+		storage:   s,
+		hub:       stream.NewHub(cfg.StreamBuffer),
+		history:   history.New(cfg.HistorySize, retention),
+		alerts:    alerting.NewEngine(),
+		recorder:  recording.NewRecorder(),
+		notifier:  notify.NewNotifier(l),
+		agents:    agents.NewRegistry(cfg.ReportInterval, cfg.DeadAfter),
+		anomalies: anomaly.NewDetector(cfg.AnomalyZScore),
+		templates: template.Must(parseTemplates()),
+		config:    cfg,
+		router:    r,
 		server: &http.Server{
 			Addr:    cfg.Address,
 			Handler: r,
@@ -45,36 +86,126 @@ func NewApp(s storage.Storage, cfg *config.ServerConfig, l zerolog.Logger) (app
 		logger: l,
 	}
 
+	// Streams never go idle, so they are ended as soon as the server stops
+	// accepting connections instead of holding up the drain.
+	app.server.RegisterOnShutdown(func() {
+		app.replicationLog.Close()
+		app.hub.Close()
+	})
+
+	// Streams are fed by the storage, so they also get changes applied from
+	// a replication primary.
+	var feed storage.Feed = hubFeed{app.hub}
+	if mem, itIsMem := storage.Unwrap(s).(*storage.MemStorage); itIsMem {
+		if cfg.NeedReplicationLog() {
+			app.replicationLog = replication.NewLog(cfg.ReplicationLogSize)
+			feed = storage.MultiFeed(app.replicationLog, feed)
+		}
+		mem.SetFeed(feed)
+		if cfg.NeedReplica() {
+			app.replica = replication.NewFollower(cfg.ReplicaOf, cfg.AdminToken, replicaTarget{app: app, mem: mem}, l)
+		}
+	} else {
+		app.storage = storage.NewFeedStorage(s, feed)
+	}
+
+	static, err := staticFiles()
+	if err != nil {
+		panic(err)
+	}
+
 	app.router.Use(middleware.RequestID)
 	app.router.Use(middleware.RealIP)
 	app.router.Use(middleware.Logger)
 	app.router.Use(middleware.Recoverer)
-	app.router.Use(localmiddleware.Gzip)
 
-	app.router.Get("/", app.getAllMetricsHandler)
-	app.router.Post("/update/{Type}/{Name}/{Value}", app.updateMetricHandler)
-	app.router.Get("/value/{Type}/{Name}", app.getMetricHandler)
+	app.router.Get("/api/v1/stream", app.streamHandler)
+	app.router.With(localmiddleware.AdminAuth(cfg.AdminToken)).Get(replication.StreamPath, app.replicationStreamHandler)
+
+	app.router.Group(func(r chi.Router) {
+		r.Use(localmiddleware.Gzip)
+
+		r.Get("/", app.getAllMetricsHandler)
+		r.Get("/metric/{Name}", app.getMetricPageHandler)
+		r.Handle("/assets/*", http.StripPrefix("/assets/", http.FileServer(http.FS(static))))
+		r.Get("/api/v1/history/{Name}", app.getMetricHistoryHandler)
+		r.Get("/api/v1/metrics", app.listMetricsHandler)
+		r.Get("/api/v1/metrics/{Name}/metadata", app.getMetadataHandler)
+		r.Get("/api/v1/query", app.queryHandler)
+		r.Get("/api/v1/alerts", app.getAlertsHandler)
+		r.Get("/api/v1/anomalies", app.getAnomaliesHandler)
+		r.Get("/api/v1/agents", app.getAgentsHandler)
+		r.Get("/api/v1/agents/events", app.getAgentEventsHandler)
+		r.Get("/metrics", app.exportMetricsHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(localmiddleware.AdminAuth(cfg.AdminToken))
 
-	app.router.Post("/update/", app.updateJSONMetricHandler)
-	app.router.Post("/value/", app.getJSONMetricHandler)
+			r.Get("/api/v1/replication", app.replicationStatusHandler)
+			r.Post("/api/v1/replication/promote", app.promoteHandler)
 
-	app.router.Post("/updates/", app.updateJSONMetricsHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(app.readOnly)
 
-	app.router.Get("/ping", app.dbHealthCheckHandler)
+				r.Delete("/api/v1/metrics", app.deleteMatchingMetricsHandler)
+				r.Delete("/api/v1/metrics/{Name}", app.deleteMetricHandler)
+				r.Put("/api/v1/metrics/{Name}/metadata", app.setMetadataHandler)
+				r.Delete("/api/v1/metrics/{Name}/metadata", app.deleteMetadataHandler)
+			})
+		})
+		r.With(app.readOnly).Post("/update/{Type}/{Name}/{Value}", app.updateMetricHandler)
+		r.Get("/value/{Type}/{Name}", app.getMetricHandler)
+
+		r.With(app.readOnly).Post("/update/", app.updateJSONMetricHandler)
+		r.Post("/value/", app.getJSONMetricHandler)
+
+		r.With(app.readOnly).Post("/updates/", app.updateJSONMetricsHandler)
+
+		r.Get("/ping", app.dbHealthCheckHandler)
+	})
 
 	return app
 }
 
 func (app App) Run() (err error) {
+	if mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage); itIsMem {
+		format, err := fs.ParseFormat(app.config.StoreFormat)
+		if err != nil {
+			return err
+		}
+		mem.SetSnapshotOptions(fs.SnapshotOptions{Keep: app.config.StoreKeep, Format: format})
+	}
+
+	if app.config.NeedWAL() {
+		if err := app.openWAL(); err != nil {
+			return err
+		}
+	}
+
+	if app.config.NeedReplica() || app.config.NeedReplicationLog() {
+		if _, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage); !itIsMem {
+			return fmt.Errorf("try to replicate non memstorage storage")
+		}
+		// The buffer counts on being the only writer, and a replica applies
+		// changes to the memory storage past it.
+		if _, buffered := storage.BufferStatsOf(app.storage); buffered {
+			return fmt.Errorf("try to replicate buffered storage")
+		}
+	}
+
 	if app.config.NeedRestore() {
-		mem, itIsMem := app.storage.(*storage.MemStorage)
+		mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage)
 		if !itIsMem {
 			return fmt.Errorf("try to restor non memstorage storage")
 		}
-		if err := mem.Restore(app.config.StoreFile); err != nil {
+		restored, err := mem.Restore(app.config.StoreFile)
+		if err != nil {
 			return err
 		}
-		app.logger.Info().Msg("Metrics restored from file")
+		if restored != app.config.StoreFile {
+			app.logger.Warn().Msgf("Snapshot %s is corrupt, restored from %s", app.config.StoreFile, restored)
+		}
+		app.logger.Info().Msgf("Metrics restored from %s", restored)
 	}
 
 	if app.config.NeedPeriodicalStore() {
@@ -90,7 +221,7 @@ func (app App) Run() (err error) {
 			for {
 				select {
 				case <-dumpTicker.C:
-					mem, itIsMem := app.storage.(*storage.MemStorage)
+					mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage)
 					if !itIsMem {
 						err = fmt.Errorf("try store datra for non memstorage")
 					}
@@ -102,28 +233,164 @@ func (app App) Run() (err error) {
 		}(ctx)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func(ctx context.Context) {
+		compactTicker := time.NewTicker(compactInterval)
+		defer compactTicker.Stop()
+
+		for {
+			select {
+			case <-compactTicker.C:
+				app.history.Compact()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(ctx)
+
+	if app.config.NeedEvaluateRules() {
+		if err := app.loadRules(); err != nil {
+			return err
+		}
+		if err := app.loadReceivers(); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func(ctx context.Context) {
+			rulesTicker := time.NewTicker(app.config.RulesInterval)
+			defer rulesTicker.Stop()
+
+			app.logger.Info().Msgf("Recording and alerting rules will be evaluated every %s", app.config.RulesInterval)
+
+			for {
+				select {
+				case <-rulesTicker.C:
+					app.evalRules()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	if app.config.NeedCheckAgents() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func(ctx context.Context) {
+			agentsTicker := time.NewTicker(app.config.ReportInterval)
+			defer agentsTicker.Stop()
+
+			app.logger.Info().Msgf("Agents silent for %d report intervals will be marked as dead", app.config.DeadAfter)
+
+			for {
+				select {
+				case <-agentsTicker.C:
+					app.checkAgents()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	if app.config.NeedExpireMetrics() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func(ctx context.Context) {
+			expireTicker := time.NewTicker(expireInterval(app.config.MetricTTL))
+			defer expireTicker.Stop()
+
+			app.logger.Info().Msgf("Metrics not updated within %s will be deleted", app.config.MetricTTL)
+
+			for {
+				select {
+				case <-expireTicker.C:
+					app.expireMetrics()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	if app.replica != nil {
+		app.replica.Start()
+		app.logger.Info().Msgf("Replicating metrics from %s", app.config.ReplicaOf)
+	}
+
 	app.logger.Info().Msgf("Application started on host %s\n", app.config.Address)
 	err = app.server.ListenAndServe()
 
 	return
 }
 
+// Shutdown drains the server before persisting, so every update it has
+// acknowledged gets into the WAL and the final snapshot.
 func (app App) Shutdown(ctx context.Context) error {
-	db, itIsDB := app.storage.(storage.DBStorage)
+	serverErr := app.server.Shutdown(ctx)
+
+	app.replica.Stop()
+	app.notifier.Close()
+
+	if app.config.NeedPersistAlerts() {
+		if err := app.alerts.Save(app.config.AlertStateFile); err != nil {
+			return err
+		}
+	}
+
+	db, itIsDB := app.storage.(io.Closer)
 	if itIsDB {
 		if err := db.Close(); err != nil {
 			return err
 		}
 	}
 
-	mem, itIsMem := app.storage.(*storage.MemStorage)
+	mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage)
 	if itIsMem {
 		if backUpErr := mem.BackupData(app.config.StoreFile); backUpErr != nil {
 			return backUpErr
 		}
+		if walErr := mem.CloseWAL(); walErr != nil {
+			return walErr
+		}
+	}
+
+	return serverErr
+}
+
+func (app App) openWAL() error {
+	mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage)
+	if !itIsMem {
+		return fmt.Errorf("try to open wal for non memstorage storage")
+	}
+
+	policy, err := fs.ParseSyncPolicy(app.config.WALSync)
+	if err != nil {
+		return err
+	}
+
+	wal, err := fs.OpenWAL(app.config.WALFile, policy, app.config.WALSyncInterval)
+	if err != nil {
+		return err
+	}
+
+	if !app.config.NeedRestore() {
+		if err := wal.Truncate(); err != nil {
+			return err
+		}
 	}
 
-	return app.server.Shutdown(ctx)
+	mem.SetWAL(wal)
+	app.logger.Info().Msgf("Write-ahead log opened at %s", app.config.WALFile)
+
+	return nil
 }
 
 func (app App) dbHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -134,7 +401,7 @@ func (app App) dbHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, ok := app.storage.(storage.DBStorage)
+	db, ok := app.storage.(pinger)
 	if !ok {
 		app.logger.Error().Msg("Can not get db instance from storage interface")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -151,26 +418,6 @@ func (app App) dbHealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (app App) getAllMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	t, tErr := template.New("metrics").Parse(AllMetricsTemplate)
-	if tErr != nil {
-		app.logger.Error().Msgf("Template creating error: %s", tErr)
-		http.Error(w, tErr.Error(), http.StatusInternalServerError)
-	}
-
-	m, err := app.storage.Find(metricOnPage, offset)
-	if err != nil {
-		app.logger.Error().Msgf("Error while getting metrics list: %s", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-
-	if err := t.Execute(w, m); err != nil {
-		app.logger.Error().Msgf("Template render error: %s", tErr)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-}
-
 func (app App) updateJSONMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	var b metric.MetricsBatch
 
@@ -181,6 +428,7 @@ func (app App) updateJSONMetricsHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	var s []metric.IMetric
+	mds := make(map[string]metric.Metadata)
 	for _, m := range b.Metrics {
 		if m.Delta == nil && m.Value == nil {
 			app.logger.Error().Msg("Invalid metric. Delta and Value can't be nil at the same time.")
@@ -210,14 +458,37 @@ func (app App) updateJSONMetricsHandler(w http.ResponseWriter, r *http.Request)
 		}
 
 		s = append(s, im)
+		if !m.Metadata.IsZero() {
+			mds[m.ID] = mds[m.ID].Merge(m.Metadata)
+		}
 	}
 
-	if updErr := app.storage.BatchUpdate(s); updErr != nil {
+	updated, updErr := storage.BatchUpdateContext(r.Context(), app.storage, s)
+	if errors.Is(updErr, storage.ErrTypeConflict) {
+		app.logger.Error().Msgf("Update error %s", updErr)
+		sendJSONResponse(w, http.StatusConflict, []byte(updErr.Error()), app.logger)
+		return
+	}
+	if errors.Is(updErr, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Update error %s", updErr)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
+	if updErr != nil {
 		app.logger.Error().Msgf("Update error %s", updErr)
 		sendJSONResponse(w, http.StatusInternalServerError, []byte("update error"), app.logger)
 		return
 	}
 
+	if mdErr := app.updateMetadata(r.Context(), mds); mdErr != nil {
+		app.logger.Error().Msgf("Metadata update error %s", mdErr)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("update error"), app.logger)
+		return
+	}
+
+	app.afterUpdate(updated...)
+	app.agentSeen(r)
+
 	sendJSONResponse(w, http.StatusOK, []byte("{}"), app.logger)
 }
 
@@ -257,13 +528,31 @@ func (app App) updateJSONMetricHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updM, updErr := app.storage.Update(im)
+	updM, updErr := storage.UpdateContext(r.Context(), app.storage, im)
+	if errors.Is(updErr, storage.ErrTypeConflict) {
+		app.logger.Error().Msgf("Metric update error: %s", updErr)
+		sendJSONResponse(w, http.StatusConflict, []byte(updErr.Error()), app.logger)
+		return
+	}
+	if errors.Is(updErr, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Metric update error: %s", updErr)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
 	if updErr != nil {
 		app.logger.Error().Msgf("Metric update error: %s", updErr)
 		sendJSONResponse(w, http.StatusInternalServerError, []byte("update error"), app.logger)
 		return
 	}
 
+	if mdErr := app.updateMetadata(r.Context(), map[string]metric.Metadata{m.ID: m.Metadata}); mdErr != nil {
+		app.logger.Error().Msgf("Metadata update error %s", mdErr)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("update error"), app.logger)
+		return
+	}
+
+	app.afterUpdate(updM)
+
 	rm, rmErr := metric.NewMetricsFromIMetric(updM)
 	if rmErr != nil {
 		app.logger.Error().Msgf("Metric convert error %s", rmErr)
@@ -298,12 +587,30 @@ func (app App) getJSONMetricHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m, err := app.storage.Get(rm.ID)
-	if err != nil && errors.Is(storage.ErrMetricNotFound, err) {
+	var (
+		m   metric.IMetric
+		err error
+	)
+	if rm.MType != "" {
+		m, err = storage.GetByTypeContext(r.Context(), app.storage, rm.ID, rm.MType)
+	} else {
+		m, err = storage.GetContext(r.Context(), app.storage, rm.ID)
+	}
+	if errors.Is(err, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Metric find error %s", err)
+		sendJSONResponse(w, http.StatusServiceUnavailable, []byte(storage.ErrUnavailable.Error()), app.logger)
+		return
+	}
+	if err != nil && errors.Is(err, storage.ErrMetricNotFound) {
 		app.logger.Error().Msgf("Metric find error %s", err)
 		sendJSONResponse(w, http.StatusNotFound, []byte(err.Error()), app.logger)
 		return
 	}
+	if err != nil {
+		app.logger.Error().Msgf("Metric find error %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal error"), app.logger)
+		return
+	}
 
 	resM, rmErr := metric.NewMetricsFromIMetric(m)
 	if rmErr != nil {
@@ -312,6 +619,14 @@ func (app App) getJSONMetricHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mds, mdErr := storage.FindMetadataContext(r.Context(), app.storage, []string{m.Name()})
+	if mdErr != nil {
+		app.logger.Error().Msgf("Metadata find error %s", mdErr)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal error"), app.logger)
+		return
+	}
+	resM.Metadata = mds[m.Name()]
+
 	if app.config.NeedCheckSign() {
 		_ = resM.Sign(app.config.Key)
 	}
@@ -359,12 +674,25 @@ func (app App) updateMetricHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, updErr := app.storage.Update(m)
+	updM, updErr := storage.UpdateContext(r.Context(), app.storage, m)
+	if errors.Is(updErr, storage.ErrTypeConflict) {
+		app.logger.Error().Msgf("Update metric error: %s", updErr)
+		http.Error(w, updErr.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(updErr, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Update metric error: %s", updErr)
+		http.Error(w, storage.ErrUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if updErr != nil {
 		app.logger.Error().Msgf("Update metric error: %s", updErr)
 		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
+	app.afterUpdate(updM)
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -372,15 +700,21 @@ func (app App) getMetricHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 
 	mName := chi.URLParam(r, "Name")
-	if mName == "" {
-		app.logger.Error().Msgf("Invalid path param. Name: %s", mName)
+	mType := chi.URLParam(r, "Type")
+	if mName == "" || mType == "" {
+		app.logger.Error().Msgf("Invalid path params. Name: %s, Type: %s", mName, mType)
 		http.Error(w, "invalid path params", http.StatusBadRequest)
 		return
 	}
 
-	m, vErr := app.storage.Get(mName)
+	m, vErr := storage.GetByTypeContext(r.Context(), app.storage, mName, mType)
+	if errors.Is(vErr, storage.ErrUnavailable) {
+		app.logger.Error().Msgf("Metric find error: %s", vErr)
+		http.Error(w, storage.ErrUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if vErr != nil {
-		app.logger.Error().Msgf("Metric not found by name: %s", mName)
+		app.logger.Error().Msgf("Metric not found by name: %s and type: %s", mName, mType)
 		http.Error(w, "metric not found", http.StatusNotFound)
 		return
 	}
@@ -393,10 +727,68 @@ func (app App) getMetricHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (app App) updateMetadata(ctx context.Context, mds map[string]metric.Metadata) error {
+	for name, md := range mds {
+		if md.IsZero() {
+			delete(mds, name)
+		}
+	}
+
+	if len(mds) == 0 {
+		return nil
+	}
+
+	return storage.SetMetadataContext(ctx, app.storage, mds)
+}
+
+func (app App) expireMetrics() {
+	// Replicas get deletions from the primary.
+	if app.replica.Active() {
+		return
+	}
+
+	deleted, err := app.storage.DeleteStale(time.Now().Add(-app.config.MetricTTL))
+	if err != nil {
+		app.logger.Error().Msgf("Stale metrics delete error: %s", err)
+		return
+	}
+
+	app.afterDelete(deleted...)
+	if len(deleted) > 0 {
+		app.logger.Info().Msgf("Deleted %d stale metrics", len(deleted))
+	}
+}
+
+func (app App) afterDelete(names ...string) {
+	for _, name := range names {
+		app.history.Delete(name)
+		app.anomalies.Delete(name)
+	}
+}
+
+func (app App) afterUpdate(ms ...metric.IMetric) {
+	app.history.Record(ms...)
+	if app.config.NeedDetectAnomalies() {
+		app.anomalies.Observe(ms...)
+	}
+}
+
 func (app App) getRouter() chi.Router {
 	return app.router
 }
 
+func expireInterval(ttl time.Duration) time.Duration {
+	interval := ttl / expireIntervalRatio
+	if interval > maxExpireInterval {
+		return maxExpireInterval
+	}
+	if interval < minExpireInterval {
+		return minExpireInterval
+	}
+
+	return interval
+}
+
 func sendJSONResponse(w http.ResponseWriter, status int, body []byte, logger zerolog.Logger) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
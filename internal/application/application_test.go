@@ -1,13 +1,23 @@
 package application
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -245,7 +255,7 @@ func Test_getOneJsonHandler(t *testing.T) {
 			want: want{
 				contentType: "application/json",
 				statusCode:  http.StatusNotFound,
-				content:     "metric not found by name 'UnknownCounter'",
+				content:     "metric not found: name 'UnknownCounter'",
 			},
 		},
 	}
@@ -441,6 +451,242 @@ func Test_updateHandler(t *testing.T) {
 	}
 }
 
+func Test_typeConflict(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     storage.ConflictPolicy
+		statusCode int
+		want       []string
+	}{
+		{
+			name:       "reject conflicting type test",
+			policy:     storage.ConflictReject,
+			statusCode: http.StatusConflict,
+			want:       []string{"gauge"},
+		},
+		{
+			name:       "namespace conflicting type test",
+			policy:     storage.ConflictNamespace,
+			statusCode: http.StatusOK,
+			want:       []string{"counter", "gauge"},
+		},
+		{
+			name:       "last writer wins conflicting type test",
+			policy:     storage.ConflictLastWriterWins,
+			statusCode: http.StatusOK,
+			want:       []string{"counter"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			s, err := storage.NewStorage("", tt.policy, 1, storage.DBOptions{})
+			require.NoError(t, err)
+
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(s, config.GetConfigServer(), l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc/1")
+
+			resp, _ := testRequest(t, ts, http.MethodPost, "/update/counter/Alloc/5")
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+
+			resp, err = http.Post(ts.URL+"/update/", "application/json", strings.NewReader(`{"id":"Alloc","type":"counter","delta":5}`))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+
+			ms, err := s.Find(storage.Query{})
+			require.NoError(t, err)
+
+			var types []string
+			for _, m := range ms {
+				types = append(types, m.Type())
+			}
+			assert.Equal(t, tt.want, types)
+		})
+	}
+}
+
+type unavailableStorage struct {
+	storage.Storage
+}
+
+func (unavailableStorage) Get(string) (metric.IMetric, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) GetByType(string, string) (metric.IMetric, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) Find(storage.Query) ([]metric.IMetric, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) Update(metric.IMetric) (metric.IMetric, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) BatchUpdate([]metric.IMetric) ([]metric.IMetric, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) Delete(string) error {
+	return storage.ErrUnavailable
+}
+
+func (unavailableStorage) DeleteMatching(string) ([]string, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) FindMetadata([]string) (map[string]metric.Metadata, error) {
+	return nil, storage.ErrUnavailable
+}
+
+func (unavailableStorage) SetMetadata(map[string]metric.Metadata) error {
+	return storage.ErrUnavailable
+}
+
+func (unavailableStorage) DeleteMetadata(string) error {
+	return storage.ErrUnavailable
+}
+
+func Test_storageUnavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+	}{
+		{
+			name:   "update metric test",
+			method: http.MethodPost,
+			url:    "/update/gauge/Alloc/1",
+		},
+		{
+			name:   "update json metric test",
+			method: http.MethodPost,
+			url:    "/update/",
+			body:   `{"id":"Alloc","type":"gauge","value":1}`,
+		},
+		{
+			name:   "update json metrics test",
+			method: http.MethodPost,
+			url:    "/updates/",
+			body:   `[{"id":"Alloc","type":"gauge","value":1}]`,
+		},
+		{
+			name:   "get metric test",
+			method: http.MethodGet,
+			url:    "/value/gauge/Alloc",
+		},
+		{
+			name:   "get json metric test",
+			method: http.MethodPost,
+			url:    "/value/",
+			body:   `{"id":"Alloc","type":"gauge"}`,
+		},
+		{
+			name:   "list metrics test",
+			method: http.MethodGet,
+			url:    "/api/v1/metrics",
+		},
+		{
+			name:   "delete metric test",
+			method: http.MethodDelete,
+			url:    "/api/v1/metrics/Alloc",
+		},
+		{
+			name:   "delete matching metrics test",
+			method: http.MethodDelete,
+			url:    "/api/v1/metrics?match=Al*",
+		},
+		{
+			name:   "get metadata test",
+			method: http.MethodGet,
+			url:    "/api/v1/metrics/Alloc/metadata",
+		},
+		{
+			name:   "set metadata test",
+			method: http.MethodPut,
+			url:    "/api/v1/metrics/Alloc/metadata",
+			body:   `{"unit":"bytes"}`,
+		},
+		{
+			name:   "delete metadata test",
+			method: http.MethodDelete,
+			url:    "/api/v1/metrics/Alloc/metadata",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			cfg := config.GetConfigServer()
+			cfg.AdminToken = "secret"
+
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(unavailableStorage{storage.NewMemStorage()}, cfg, l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			req, err := http.NewRequest(tt.method, ts.URL+tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		})
+	}
+}
+
+type brokenStorage struct {
+	storage.Storage
+}
+
+func (brokenStorage) Get(string) (metric.IMetric, error) {
+	return nil, errors.New("storage is broken")
+}
+
+func (brokenStorage) GetByType(string, string) (metric.IMetric, error) {
+	return nil, errors.New("storage is broken")
+}
+
+func Test_getJSONMetricStorageError(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(brokenStorage{storage.NewMemStorage()}, config.GetConfigServer(), l)
+
+	ts := httptest.NewServer(app.getRouter())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/value/", "application/json", strings.NewReader(`{"id":"Alloc","type":"gauge"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
 func Test_getOneHandler(t *testing.T) {
 	type want struct {
 		contentType string
@@ -503,6 +749,16 @@ func Test_getOneHandler(t *testing.T) {
 				content:     "metric not found\n",
 			},
 		},
+		{
+			name:   "get metric with another type test",
+			path:   "/value/gauge/PollCounter",
+			method: http.MethodGet,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  http.StatusNotFound,
+				content:     "metric not found\n",
+			},
+		},
 		{
 			name:   "invalid path format test",
 			path:   "/get/invalid/path/format/PollCounter/5",
@@ -543,7 +799,7 @@ func Test_getAllHandler(t *testing.T) {
 	type want struct {
 		contentType string
 		statusCode  int
-		content     string
+		contains    []string
 	}
 	tests := []struct {
 		name   string
@@ -552,31 +808,84 @@ func Test_getAllHandler(t *testing.T) {
 		want   want
 	}{
 		{
-			name:   "success get all metrics test",
+			name:   "success get dashboard test",
 			path:   "/",
 			method: http.MethodGet,
 			want: want{
 				contentType: "text/html; charset=utf-8",
 				statusCode:  http.StatusOK,
-				content: `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>Find metrics</title>
-</head>
-<body>
-<h1>List of metrics</h1>
-<ul>
-    
-        <li>HeapReleased:2621440</li>
-    
-        <li>PollCounter:5</li>
-    
-</ul>
-</body>
-</html>
-`,
+				contains: []string{
+					`<h2>Gauges <small>(1)</small></h2>`,
+					`<h2>Counters <small>(1)</small></h2>`,
+					`<tr data-name="HeapReleased" data-value="2621440">`,
+					`<td><a href="/metric/PollCounter">PollCounter</a></td>`,
+					`<polyline points="0.00,24.00 100.00,0.00"/>`,
+					`<script src="/assets/dashboard.js"></script>`,
+				},
+			},
+		},
+		{
+			name:   "success get metric page test",
+			path:   "/metric/PollCounter",
+			method: http.MethodGet,
+			want: want{
+				contentType: "text/html; charset=utf-8",
+				statusCode:  http.StatusOK,
+				contains: []string{
+					`<title>PollCounter</title>`,
+					`<dd class="value">10</dd>`,
+					`<td class="value">5</td>`,
+				},
+			},
+		},
+		{
+			name:   "get unknown metric page test",
+			path:   "/metric/Unknown",
+			method: http.MethodGet,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  http.StatusNotFound,
+				contains:    []string{"metric not found"},
+			},
+		},
+		{
+			name:   "success get embedded asset test",
+			path:   "/assets/dashboard.css",
+			method: http.MethodGet,
+			want: want{
+				contentType: "text/css; charset=utf-8",
+				statusCode:  http.StatusOK,
+				contains:    []string{"svg.sparkline"},
+			},
+		},
+		{
+			name:   "success get metric history test",
+			path:   "/api/v1/history/PollCounter",
+			method: http.MethodGet,
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusOK,
+				contains:    []string{`"value":5}`, `"value":10}`},
+			},
+		},
+		{
+			name:   "success get metric rollups test",
+			path:   "/api/v1/history/PollCounter?tier=1m",
+			method: http.MethodGet,
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusOK,
+				contains:    []string{`"min":5,`, `"max":10,`},
+			},
+		},
+		{
+			name:   "invalid history tier test",
+			path:   "/api/v1/history/PollCounter?tier=1d",
+			method: http.MethodGet,
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusBadRequest,
+				contains:    []string{"invalid tier param"},
 			},
 		},
 	}
@@ -590,13 +899,16 @@ func Test_getAllHandler(t *testing.T) {
 
 			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/HeapReleased/2621440.000000")
 			testRequestAndCloseBody(t, ts, "POST", "/update/counter/PollCounter/5")
+			testRequestAndCloseBody(t, ts, "POST", "/update/counter/PollCounter/5")
 
 			resp, body := testRequest(t, ts, tt.method, tt.path)
 			defer resp.Body.Close()
 
 			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
 			assert.Equal(t, tt.want.contentType, resp.Header.Get("Content-Type"))
-			assert.Equal(t, tt.want.content, body)
+			for _, c := range tt.want.contains {
+				assert.Contains(t, body, c)
+			}
 
 			flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
 			flag.CommandLine.Init("", flag.ContinueOnError)
@@ -604,46 +916,986 @@ func Test_getAllHandler(t *testing.T) {
 	}
 }
 
-func testJSONRequest(
-	t *testing.T,
-	ts *httptest.Server,
-	method,
-	path string,
-	m metric.Metrics,
-) (*http.Response, string) {
-	b, _ := m.Encode()
-	buf := bytes.NewBuffer(b)
+func Test_listMetricsHandler(t *testing.T) {
+	type want struct {
+		statusCode int
+		ids        []string
+		hasNext    bool
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  want
+	}{
+		{
+			name:  "first page test",
+			query: "?limit=2",
+			want:  want{statusCode: http.StatusOK, ids: []string{"Alloc", "HeapAlloc"}, hasNext: true},
+		},
+		{
+			name:  "filter by type and glob test",
+			query: "?type=gauge&match=Heap*",
+			want:  want{statusCode: http.StatusOK, ids: []string{"HeapAlloc", "HeapIdle"}},
+		},
+		{
+			name:  "invalid cursor test",
+			query: "?cursor=bm90LWpzb24",
+			want:  want{statusCode: http.StatusBadRequest},
+		},
+		{
+			name:  "invalid limit test",
+			query: "?limit=0",
+			want:  want{statusCode: http.StatusBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
 
-	req, err := http.NewRequest(method, ts.URL+path, buf)
-	require.NoError(t, err)
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(storage.NewMemStorage(), config.GetConfigServer(), l)
 
-	resp, err := http.DefaultClient.Do(req)
-	require.NoError(t, err)
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	require.NoError(t, err)
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/HeapIdle/1")
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/HeapAlloc/2")
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc/3")
+			testRequestAndCloseBody(t, ts, "POST", "/update/counter/PollCount/4")
 
-	defer resp.Body.Close()
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/metrics"+tt.query)
+			defer resp.Body.Close()
 
-	return resp, string(respBody)
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+			if tt.want.statusCode != http.StatusOK {
+				return
+			}
+
+			var page metricsPage
+			require.NoError(t, json.Unmarshal([]byte(body), &page))
+
+			var ids []string
+			for _, m := range page.Metrics {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, tt.want.ids, ids)
+			assert.Equal(t, tt.want.hasNext, page.NextCursor != "")
+
+			if page.NextCursor != "" {
+				next, nextBody := testRequest(t, ts, http.MethodGet, "/api/v1/metrics?limit=2&cursor="+page.NextCursor)
+				defer next.Body.Close()
+
+				var nextPage metricsPage
+				require.NoError(t, json.Unmarshal([]byte(nextBody), &nextPage))
+				require.Len(t, nextPage.Metrics, 2)
+				assert.Equal(t, "HeapIdle", nextPage.Metrics[0].ID)
+				assert.Equal(t, "PollCount", nextPage.Metrics[1].ID)
+				assert.Empty(t, nextPage.NextCursor)
+			}
+		})
+	}
 }
 
-func testRequest(t *testing.T, ts *httptest.Server, method, path string) (*http.Response, string) {
-	req, err := http.NewRequest(method, ts.URL+path, nil)
-	require.NoError(t, err)
+func Test_deleteMetricHandler(t *testing.T) {
+	type want struct {
+		statusCode int
+		content    string
+		left       string
+	}
+	tests := []struct {
+		name       string
+		adminToken string
+		authHeader string
+		path       string
+		want       want
+	}{
+		{
+			name:       "success delete metric test",
+			adminToken: "secret",
+			authHeader: "Bearer secret",
+			path:       "/api/v1/metrics/HeapAlloc",
+			want:       want{statusCode: http.StatusOK, content: `{"deleted":["HeapAlloc"]}`, left: "Alloc,HeapIdle"},
+		},
+		{
+			name:       "success delete matching metrics test",
+			adminToken: "secret",
+			authHeader: "Bearer secret",
+			path:       "/api/v1/metrics?match=Heap*",
+			want:       want{statusCode: http.StatusOK, content: `{"deleted":["HeapAlloc","HeapIdle"]}`, left: "Alloc"},
+		},
+		{
+			name:       "delete malformed glob test",
+			adminToken: "secret",
+			authHeader: "Bearer secret",
+			path:       "/api/v1/metrics?match=%5B%5D",
+			want:       want{statusCode: http.StatusBadRequest, content: "invalid glob '[]'", left: "Alloc,HeapAlloc,HeapIdle"},
+		},
+		{
+			name:       "delete unknown metric test",
+			adminToken: "secret",
+			authHeader: "Bearer secret",
+			path:       "/api/v1/metrics/Unknown",
+			want:       want{statusCode: http.StatusNotFound, content: "metric not found: name 'Unknown'", left: "Alloc,HeapAlloc,HeapIdle"},
+		},
+		{
+			name:       "wrong token test",
+			adminToken: "secret",
+			authHeader: "Bearer wrong",
+			path:       "/api/v1/metrics/HeapAlloc",
+			want:       want{statusCode: http.StatusUnauthorized, content: "unauthorized\n", left: "Alloc,HeapAlloc,HeapIdle"},
+		},
+		{
+			name:       "admin api disabled test",
+			adminToken: "",
+			authHeader: "Bearer ",
+			path:       "/api/v1/metrics/HeapAlloc",
+			want:       want{statusCode: http.StatusForbidden, content: "admin api is disabled\n", left: "Alloc,HeapAlloc,HeapIdle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
 
-	resp, err := http.DefaultClient.Do(req)
-	require.NoError(t, err)
+			cfg := config.GetConfigServer()
+			cfg.AdminToken = tt.adminToken
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			s := storage.NewMemStorage()
+			app := NewApp(s, cfg, l)
 
-	respBody, err := io.ReadAll(resp.Body)
-	require.NoError(t, err)
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
 
-	defer resp.Body.Close()
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/HeapIdle/1")
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/HeapAlloc/2")
+			testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc/3")
 
-	return resp, string(respBody)
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", tt.authHeader)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.want.content, string(body))
+
+			ms, err := s.Find(storage.Query{})
+			require.NoError(t, err)
+
+			var left []string
+			for _, m := range ms {
+				left = append(left, m.Name())
+			}
+			assert.Equal(t, tt.want.left, strings.Join(left, ","))
+		})
+	}
 }
 
-func testRequestAndCloseBody(t *testing.T, ts *httptest.Server, method, path string) {
-	resp, _ := testRequest(t, ts, method, path)
-	resp.Body.Close()
+func Test_cacheStatsExposition(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	s, err := storage.NewCachedStorage(storage.NewMemStorage(), 10, time.Minute)
+	require.NoError(t, err)
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(s, config.GetConfigServer(), l)
+
+	ts := httptest.NewServer(app.getRouter())
+	defer ts.Close()
+
+	testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc/1")
+	testRequestAndCloseBody(t, ts, "GET", "/value/gauge/Alloc")
+	testRequestAndCloseBody(t, ts, "GET", "/value/gauge/Alloc")
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "# TYPE sysmonitor_storage_cache_hits_total counter\nsysmonitor_storage_cache_hits_total 1\n")
+	assert.Contains(t, body, "# TYPE sysmonitor_storage_cache_misses_total counter\nsysmonitor_storage_cache_misses_total 1\n")
+}
+
+func Test_bufferStatsExposition(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	s := storage.NewBufferedStorage(storage.NewMemStorage(), 100, time.Hour)
+	defer s.Close()
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(s, config.GetConfigServer(), l)
+
+	ts := httptest.NewServer(app.getRouter())
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "# TYPE sysmonitor_storage_buffer_failed_flushes_total counter\nsysmonitor_storage_buffer_failed_flushes_total 0\n")
+}
+
+func Test_conflictingTypesExposition(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	s, err := storage.NewStorage("", storage.ConflictNamespace, 1, storage.DBOptions{})
+	require.NoError(t, err)
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(s, config.GetConfigServer(), l)
+
+	ts := httptest.NewServer(app.getRouter())
+	defer ts.Close()
+
+	testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Requests/1.5")
+	testRequestAndCloseBody(t, ts, "POST", "/update/counter/Requests/3")
+	testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc/2")
+	testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc.Bytes/4")
+	testRequestAndCloseBody(t, ts, "POST", "/update/gauge/Alloc_Bytes/5")
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "# TYPE Requests_counter counter\nRequests_counter 3\n")
+	assert.Contains(t, body, "# TYPE Requests_gauge gauge\nRequests_gauge 1.5\n")
+	assert.Contains(t, body, "# TYPE Alloc gauge\nAlloc 2\n")
+	assert.Contains(t, body, "# TYPE Alloc_Bytes gauge\nAlloc_Bytes 4\n")
+	assert.Equal(t, 1, strings.Count(body, "# TYPE Alloc_Bytes "))
+}
+
+func Test_shutdown(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	cfg := config.GetConfigServer()
+	cfg.StoreFile = filepath.Join(t.TempDir(), "metrics.json")
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(storage.NewMemStorage(), cfg, l)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.server.Serve(ln)
+	}()
+	url := "http://" + ln.Addr().String()
+
+	stream, err := http.Get(url + "/api/v1/stream")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+
+	resp, err := http.Post(url+"/update/gauge/Alloc/1.5", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, app.Shutdown(ctx), "open streams must not hold up the drain")
+
+	_, err = http.Post(url+"/update/gauge/Alloc/2", "text/plain", nil)
+	assert.Error(t, err, "no updates are accepted after shutdown")
+
+	restored := storage.NewMemStorage().(*storage.MemStorage)
+	_, err = restored.Restore(cfg.StoreFile)
+	require.NoError(t, err)
+	m, err := restored.Get("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", m.ValueAsString())
+}
+
+func Test_replication(t *testing.T) {
+	newConfig := func() *config.ServerConfig {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+
+		cfg := config.GetConfigServer()
+		cfg.AdminToken = "secret"
+		cfg.ReplicationLogSize = 100
+
+		return cfg
+	}
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+	adminRequest := func(ts *httptest.Server, method, path string) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(b)
+	}
+	value := func(ts *httptest.Server, path string) string {
+		resp, body := testRequest(t, ts, http.MethodGet, path)
+		if resp.StatusCode != http.StatusOK {
+			return ""
+		}
+		return body
+	}
+
+	primary := NewApp(storage.NewMemStorage(), newConfig(), l)
+	pts := httptest.NewServer(primary.getRouter())
+	defer pts.Close()
+
+	testRequestAndCloseBody(t, pts, http.MethodPost, "/update/counter/PollCount/5")
+	testRequestAndCloseBody(t, pts, http.MethodPost, "/update/gauge/Alloc/1")
+
+	cfg := newConfig()
+	cfg.ReplicaOf = pts.URL
+	replica := NewApp(storage.NewMemStorage(), cfg, l)
+	rts := httptest.NewServer(replica.getRouter())
+	defer rts.Close()
+
+	replica.replica.Start()
+	defer replica.replica.Stop()
+
+	assert.Eventually(t, func() bool {
+		return value(rts, "/value/counter/PollCount") == "5"
+	}, time.Second, 10*time.Millisecond, "replica gets a snapshot")
+
+	testRequestAndCloseBody(t, pts, http.MethodPost, "/update/counter/PollCount/2")
+	resp, _ := adminRequest(pts, http.MethodDelete, "/api/v1/metrics/Alloc")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Eventually(t, func() bool {
+		return value(rts, "/value/counter/PollCount") == "7" && value(rts, "/value/gauge/Alloc") == ""
+	}, time.Second, 10*time.Millisecond, "replica follows updates and deletes")
+
+	resp, _ = testRequest(t, rts, http.MethodPost, "/update/gauge/Alloc/2")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "replica is read-only")
+	resp, _ = adminRequest(rts, http.MethodDelete, "/api/v1/metrics/PollCount")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "replica is read-only")
+
+	resp, body := adminRequest(rts, http.MethodGet, "/api/v1/replication")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"role":"replica"`)
+
+	resp, _ = adminRequest(pts, http.MethodPost, "/api/v1/replication/promote")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "primary can't be promoted")
+
+	resp, body = adminRequest(rts, http.MethodPost, "/api/v1/replication/promote")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"role":"primary"`)
+
+	testRequestAndCloseBody(t, pts, http.MethodPost, "/update/counter/PollCount/100")
+	resp, _ = testRequest(t, rts, http.MethodPost, "/update/counter/PollCount/1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "promoted replica takes writes")
+	assert.Equal(t, "8", value(rts, "/value/counter/PollCount"))
+
+	buffered := storage.NewBufferedStorage(storage.NewMemStorage(), 10, time.Hour)
+	defer buffered.Close()
+	assert.EqualError(t, NewApp(buffered, newConfig(), l).Run(), "try to replicate buffered storage")
+}
+
+func Test_metadataHandlers(t *testing.T) {
+	type want struct {
+		statusCode int
+		contains   []string
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   want
+	}{
+		{
+			name:   "value response contains metadata test",
+			method: http.MethodPost,
+			path:   "/value/",
+			body:   `{"id":"Alloc","type":"gauge"}`,
+			want: want{
+				statusCode: http.StatusOK,
+				contains:   []string{`{"id":"Alloc","type":"gauge","value":2.5,"unit":"bytes","help":"Heap bytes","owner":"runtime"}`},
+			},
+		},
+		{
+			name:   "exposition contains metadata test",
+			method: http.MethodGet,
+			path:   "/metrics",
+			want: want{
+				statusCode: http.StatusOK,
+				contains: []string{
+					"# HELP Alloc Heap bytes\n# TYPE Alloc gauge\n# UNIT Alloc bytes\n# OWNER Alloc runtime\nAlloc 2.5\n",
+					"# TYPE PollCount counter\n# UNIT PollCount count\nPollCount 3\n",
+				},
+			},
+		},
+		{
+			name:   "get metadata test",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics/PollCount/metadata",
+			want: want{
+				statusCode: http.StatusOK,
+				contains:   []string{`{"unit":"count"}`},
+			},
+		},
+		{
+			name:   "dashboard contains metadata test",
+			method: http.MethodGet,
+			path:   "/",
+			want: want{
+				statusCode: http.StatusOK,
+				contains:   []string{`<td><a href="/metric/Alloc" title="Heap bytes">Alloc</a></td>`, `<td>bytes</td>`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			cfg := config.GetConfigServer()
+			cfg.AdminToken = "secret"
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(storage.NewMemStorage(), cfg, l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			batch := `[{"id":"Alloc","type":"gauge","value":2.5,"unit":"bytes"},{"id":"PollCount","type":"counter","delta":3,"unit":"count"}]`
+			resp, err := http.Post(ts.URL+"/updates/", "application/json", strings.NewReader(batch))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/metrics/Alloc/metadata", strings.NewReader(`{"help":"Heap bytes","owner":"runtime"}`))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err = http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+
+			req, err = http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err = http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, resp.StatusCode)
+			for _, c := range tt.want.contains {
+				assert.Contains(t, string(body), c)
+			}
+		})
+	}
+}
+
+func Test_streamHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		dsn     string
+		updates []string
+		want    string
+	}{
+		{
+			name:    "stream receives accumulated counter value test",
+			path:    "/api/v1/stream?names=PollCounter",
+			updates: []string{"/update/gauge/Alloc/1.5", "/update/counter/PollCounter/5"},
+			want:    `data: {"id":"PollCounter","type":"counter","delta":10}`,
+		},
+		{
+			name:    "stream without filter receives all metrics test",
+			path:    "/api/v1/stream",
+			updates: []string{"/update/gauge/Alloc/1.5"},
+			want:    `data: {"id":"Alloc","type":"gauge","value":1.5}`,
+		},
+		{
+			name:    "stream of bolt storage receives accumulated counter value test",
+			path:    "/api/v1/stream?names=PollCounter",
+			dsn:     "bolt://",
+			updates: []string{"/update/counter/PollCounter/5"},
+			want:    `data: {"id":"PollCounter","type":"counter","delta":10}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := tt.dsn
+			if dsn != "" {
+				dsn += filepath.Join(t.TempDir(), "sysmon.db")
+			}
+			s, err := storage.NewStorage(dsn, storage.ConflictReject, 1, storage.DBOptions{})
+			require.NoError(t, err)
+			if c, ok := s.(io.Closer); ok {
+				defer c.Close()
+			}
+			m, _ := metric.NewMetric("PollCounter", metric.CounterType, "5")
+			_, _ = s.Update(m)
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(s, config.GetConfigServer(), l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+			defer app.hub.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", "text/event-stream")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+			for _, u := range tt.updates {
+				testRequestAndCloseBody(t, ts, http.MethodPost, u)
+			}
+
+			r := bufio.NewReader(resp.Body)
+			event, err := r.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "event: metric\n", event)
+
+			data, err := r.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, strings.TrimSpace(data))
+
+			flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+			flag.CommandLine.Init("", flag.ContinueOnError)
+		})
+	}
+}
+
+func testJSONRequest(
+	t *testing.T,
+	ts *httptest.Server,
+	method,
+	path string,
+	m metric.Metrics,
+) (*http.Response, string) {
+	b, _ := m.Encode()
+	buf := bytes.NewBuffer(b)
+
+	req, err := http.NewRequest(method, ts.URL+path, buf)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	return resp, string(respBody)
+}
+
+func testRequest(t *testing.T, ts *httptest.Server, method, path string) (*http.Response, string) {
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	return resp, string(respBody)
+}
+
+func testRequestAndCloseBody(t *testing.T, ts *httptest.Server, method, path string) {
+	resp, _ := testRequest(t, ts, method, path)
+	resp.Body.Close()
+}
+
+func Test_getAlertsHandler(t *testing.T) {
+	rules := `
+rules:
+  - alert: HighCPU
+    expr: CPUutilization1 > 90
+    for: 5m
+  - alert: LowMemory
+    expr: FreeMemory / TotalMemory < 0.1
+    labels:
+      severity: critical
+`
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		want       []string
+	}{
+		{
+			name:       "active alerts test",
+			path:       "/api/v1/alerts",
+			statusCode: http.StatusOK,
+			want:       []string{`"rule":"HighCPU","state":"pending"`, `"rule":"LowMemory","state":"firing"`, `"severity":"critical"`},
+		},
+		{
+			name:       "alerts filtered by state test",
+			path:       "/api/v1/alerts?state=firing",
+			statusCode: http.StatusOK,
+			want:       []string{`[{"rule":"LowMemory","state":"firing"`},
+		},
+		{
+			name:       "invalid state test",
+			path:       "/api/v1/alerts?state=unknown",
+			statusCode: http.StatusBadRequest,
+			want:       []string{`unknown alert state "unknown"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			dir := t.TempDir()
+			cfg := config.GetConfigServer()
+			cfg.RulesFile = dir + "/rules.yml"
+			cfg.AlertStateFile = dir + "/alerts.json"
+			require.NoError(t, os.WriteFile(cfg.RulesFile, []byte(rules), 0664))
+
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(storage.NewMemStorage(), cfg, l)
+			require.NoError(t, app.loadRules())
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			batch := `[{"id":"CPUutilization1","type":"gauge","value":95},{"id":"FreeMemory","type":"gauge","value":5},{"id":"TotalMemory","type":"gauge","value":100}]`
+			resp, err := http.Post(ts.URL+"/updates/", "application/json", strings.NewReader(batch))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+
+			app.evalRules()
+			require.FileExists(t, cfg.AlertStateFile)
+
+			restored := NewApp(storage.NewMemStorage(), cfg, l)
+			require.NoError(t, restored.loadRules())
+			want, err := json.Marshal(app.alerts.Alerts())
+			require.NoError(t, err)
+			got, err := json.Marshal(restored.alerts.Alerts())
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), string(got))
+
+			resp, body := testRequest(t, ts, http.MethodGet, tt.path)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			for _, w := range tt.want {
+				assert.Contains(t, body, w)
+			}
+		})
+	}
+}
+
+func Test_alertNotifications(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	received := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	cfg := config.GetConfigServer()
+	cfg.RulesFile = dir + "/rules.yml"
+	cfg.NotifyConfig = dir + "/notify.yml"
+	cfg.AlertStateFile = ""
+	require.NoError(t, os.WriteFile(cfg.RulesFile, []byte("rules:\n  - alert: HighCPU\n    expr: CPUutilization1 > 90\n"), 0664))
+	require.NoError(t, os.WriteFile(cfg.NotifyConfig, []byte("receivers:\n  - name: oncall\n    url: "+receiver.URL+"\n"), 0664))
+
+	s := storage.NewMemStorage()
+	m, _ := metric.NewMetric("CPUutilization1", metric.GaugeType, "95")
+	_, _ = s.Update(m)
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(s, cfg, l)
+	require.NoError(t, app.loadRules())
+	require.NoError(t, app.loadReceivers())
+
+	app.evalRules()
+	app.notifier.Wait()
+
+	body := <-received
+	assert.Contains(t, string(body), `"receiver":"oncall","status":"firing"`)
+	assert.Contains(t, string(body), `"rule":"HighCPU","state":"firing"`)
+}
+
+func Test_agentsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []string
+	}{
+		{
+			name:    "agent identified by header test",
+			headers: map[string]string{"X-Agent-ID": "host-1", "X-Report-Interval": "5s"},
+			want:    []string{`"id":"host-1","address":"127.0.0.1"`, `"report_interval":5000000000,"reports":1,"status":"healthy"`},
+		},
+		{
+			name: "agent identified by address test",
+			want: []string{`"id":"127.0.0.1","address":"127.0.0.1"`, `"report_interval":10000000000,"reports":1,"status":"healthy"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(storage.NewMemStorage(), config.GetConfigServer(), l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(`[{"id":"Alloc","type":"gauge","value":1}]`))
+			require.NoError(t, err)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/agents")
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			for _, w := range tt.want {
+				assert.Contains(t, body, w)
+			}
+
+			resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/agents/events")
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "[]", body)
+		})
+	}
+}
+
+func Test_recordingRules(t *testing.T) {
+	defer func() {
+		flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+		flag.CommandLine.Init("", flag.ContinueOnError)
+	}()
+
+	rules := `
+records:
+  - record: MemoryUsedRatio
+    expr: (TotalMemory - FreeMemory) / TotalMemory
+rules:
+  - alert: HighMemoryUsage
+    expr: MemoryUsedRatio > 0.5
+`
+	dir := t.TempDir()
+	cfg := config.GetConfigServer()
+	cfg.RulesFile = dir + "/rules.yml"
+	cfg.AlertStateFile = ""
+	require.NoError(t, os.WriteFile(cfg.RulesFile, []byte(rules), 0664))
+
+	s := storage.NewMemStorage()
+	for name, v := range map[string]string{"TotalMemory": "200", "FreeMemory": "50"} {
+		m, _ := metric.NewMetric(name, metric.GaugeType, v)
+		_, _ = s.Update(m)
+	}
+
+	l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	app := NewApp(s, cfg, l)
+	require.NoError(t, app.loadRules())
+
+	ts := httptest.NewServer(app.getRouter())
+	defer ts.Close()
+
+	app.evalRules()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/value/gauge/MemoryUsedRatio")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0.75", body)
+
+	assert.Len(t, app.history.Range("MemoryUsedRatio", time.Time{}), 1)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/v1/alerts?state=firing")
+	defer resp.Body.Close()
+	assert.Contains(t, body, `"rule":"HighMemoryUsage","state":"firing"`)
+}
+
+func Test_queryHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "scalar expression test",
+			expr:       "1 + 2 * 3",
+			statusCode: http.StatusOK,
+			want:       `{"result_type":"scalar","result":7}`,
+		},
+		{
+			name:       "selector by name test",
+			expr:       "Alloc",
+			statusCode: http.StatusOK,
+			want:       `{"result_type":"vector","result":[{"labels":{"__name__":"Alloc","type":"gauge"},"value":100}]}`,
+		},
+		{
+			name:       "arithmetic between series test",
+			expr:       "Free / Alloc",
+			statusCode: http.StatusOK,
+			want:       `{"result_type":"vector","result":[{"labels":{"type":"gauge"},"value":0.25}]}`,
+		},
+		{
+			name:       "aggregation by label test",
+			expr:       `sum by (type) ({type=~".+"})`,
+			statusCode: http.StatusOK,
+			want:       `{"result_type":"vector","result":[{"labels":{"type":"gauge"},"value":125},{"labels":{"type":"counter"},"value":5}]}`,
+		},
+		{
+			name:       "non finite value test",
+			expr:       "Alloc / 0",
+			statusCode: http.StatusOK,
+			want:       `{"result_type":"vector","result":[{"labels":{"type":"gauge"},"value":"+Inf"}]}`,
+		},
+		{
+			name:       "parse error test",
+			expr:       "Alloc +",
+			statusCode: http.StatusBadRequest,
+			want:       `unexpected "" at position 7`,
+		},
+		{
+			name:       "evaluation error test",
+			expr:       `Alloc + {type="gauge"}`,
+			statusCode: http.StatusUnprocessableEntity,
+			want:       "many-to-many matching not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			s := storage.NewMemStorage()
+			for _, m := range []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 100),
+				metric.NewGaugeMetric("Free", 25),
+				metric.NewCounterMetric("PollCount", 5),
+			} {
+				_, _ = s.Update(m)
+			}
+
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(s, config.GetConfigServer(), l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/v1/query?expr="+url.QueryEscape(tt.expr))
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Contains(t, body, tt.want)
+		})
+	}
+}
+
+func Test_anomaliesHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		want       string
+	}{
+		{
+			name:       "flagged points test",
+			path:       "/api/v1/anomalies?name=HeapObjects&since=1h",
+			statusCode: http.StatusOK,
+			want:       `"name":"HeapObjects"`,
+		},
+		{
+			name:       "score exposed to expressions test",
+			path:       "/api/v1/query?expr=" + url.QueryEscape(`anomaly_score{metric="HeapObjects"} > 3`),
+			statusCode: http.StatusOK,
+			want:       `"labels":{"__name__":"anomaly_score","metric":"HeapObjects"}`,
+		},
+		{
+			name:       "invalid since test",
+			path:       "/api/v1/anomalies?since=yesterday",
+			statusCode: http.StatusBadRequest,
+			want:       "invalid since param",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				flag.CommandLine = flag.NewFlagSet("", flag.ExitOnError)
+				flag.CommandLine.Init("", flag.ContinueOnError)
+			}()
+
+			cfg := config.GetConfigServer()
+			cfg.AnomalyZScore = 3
+			l := zerolog.New(os.Stdout).With().Timestamp().Logger()
+			app := NewApp(storage.NewMemStorage(), cfg, l)
+
+			ts := httptest.NewServer(app.getRouter())
+			defer ts.Close()
+
+			for i := 0; i < 20; i++ {
+				testRequestAndCloseBody(t, ts, http.MethodPost, "/update/gauge/HeapObjects/"+strconv.Itoa(1000+i%3))
+			}
+			testRequestAndCloseBody(t, ts, http.MethodPost, "/update/gauge/HeapObjects/50000")
+
+			resp, body := testRequest(t, ts, http.MethodGet, tt.path)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Contains(t, body, tt.want)
+		})
+	}
 }
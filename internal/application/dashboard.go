@@ -0,0 +1,230 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/1g0rbm/sysmonitor/internal/history"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+const (
+	dashboardRefreshSeconds = 10
+	sparklineWidth          = 100
+	sparklineHeight         = 24
+	dashboardWindow         = time.Hour
+)
+
+type metricView struct {
+	Name    string
+	Type    string
+	Value   string
+	Points  string
+	Samples []history.Sample
+	metric.Metadata
+}
+
+type dashboardView struct {
+	Gauges         []metricView
+	Counters       []metricView
+	RefreshSeconds int
+}
+
+type metricPageView struct {
+	metricView
+	RefreshSeconds int
+}
+
+func (app App) getAllMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ms, err := app.findAll(storage.Query{})
+	if err != nil {
+		app.logger.Error().Msgf("Error while getting metrics list: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mds, err := app.metadataOf(ms)
+	if err != nil {
+		app.logger.Error().Msgf("Metadata find error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	view := dashboardView{RefreshSeconds: dashboardRefreshSeconds}
+	for _, m := range ms {
+		mv := app.newMetricView(m)
+		mv.Metadata = mds[m.Name()]
+		switch m.Type() {
+		case metric.GaugeType:
+			view.Gauges = append(view.Gauges, mv)
+		case metric.CounterType:
+			view.Counters = append(view.Counters, mv)
+		}
+	}
+	sortMetricViews(view.Gauges)
+	sortMetricViews(view.Counters)
+
+	app.renderHTML(w, "index.html", view)
+}
+
+func (app App) getMetricPageHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	m, err := app.storage.Get(mName)
+	if err != nil {
+		app.logger.Error().Msgf("Metric not found by name: %s", mName)
+		http.Error(w, "metric not found", http.StatusNotFound)
+		return
+	}
+
+	mds, err := app.storage.FindMetadata([]string{m.Name()})
+	if err != nil {
+		app.logger.Error().Msgf("Metadata find error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mv := app.newMetricView(m)
+	mv.Metadata = mds[m.Name()]
+	mv.Samples = app.history.Range(m.Name(), time.Now().Add(-dashboardWindow))
+	for i, j := 0, len(mv.Samples)-1; i < j; i, j = i+1, j-1 {
+		mv.Samples[i], mv.Samples[j] = mv.Samples[j], mv.Samples[i]
+	}
+
+	app.renderHTML(w, "metric.html", metricPageView{
+		metricView:     mv,
+		RefreshSeconds: dashboardRefreshSeconds,
+	})
+}
+
+func (app App) getMetricHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	mName := chi.URLParam(r, "Name")
+
+	var from time.Time
+	if since := r.URL.Query().Get("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil {
+			app.logger.Error().Msgf("Invalid since param: %s", err)
+			sendJSONResponse(w, http.StatusBadRequest, []byte("invalid since param"), app.logger)
+			return
+		}
+		from = time.Now().Add(-d)
+	}
+
+	var v interface{}
+	switch tier := history.Tier(r.URL.Query().Get("tier")); tier {
+	case history.TierMinute, history.TierHour:
+		rollups := app.history.Rollups(mName, tier, from)
+		if rollups == nil {
+			rollups = []history.Rollup{}
+		}
+		w.Header().Set("X-History-Tier", string(tier))
+		v = rollups
+	case "":
+		samples := app.history.Range(mName, from)
+		if samples == nil {
+			samples = []history.Sample{}
+		}
+		w.Header().Set("X-History-Tier", string(app.history.TierFor(mName, from)))
+		v = samples
+	default:
+		app.logger.Error().Msgf("Invalid tier param: %s", tier)
+		sendJSONResponse(w, http.StatusBadRequest, []byte("invalid tier param"), app.logger)
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		app.logger.Error().Msgf("History marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) findAll(q storage.Query) ([]metric.IMetric, error) {
+	q.Limit = metricOnPage
+
+	var result []metric.IMetric
+	for {
+		ms, err := app.storage.Find(q)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ms...)
+		if len(ms) < q.Limit {
+			return result, nil
+		}
+
+		q.After = storage.CursorOf(ms[len(ms)-1])
+	}
+}
+
+func (app App) newMetricView(m metric.IMetric) metricView {
+	return metricView{
+		Name:   m.Name(),
+		Type:   m.Type(),
+		Value:  m.ValueAsString(),
+		Points: sparkline(app.history.Range(m.Name(), time.Now().Add(-dashboardWindow)), sparklineWidth, sparklineHeight),
+	}
+}
+
+func (app App) renderHTML(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.templates.ExecuteTemplate(w, name, data); err != nil {
+		app.logger.Error().Msgf("Template render error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func sortMetricViews(mvs []metricView) {
+	sort.Slice(mvs, func(i, j int) bool {
+		return mvs[i].Name < mvs[j].Name
+	})
+}
+
+func sparkline(samples []history.Sample, width float64, height float64) string {
+	if len(samples) == 0 {
+		return ""
+	}
+
+	lo, hi := samples[0].Value, samples[0].Value
+	for _, s := range samples {
+		if s.Value < lo {
+			lo = s.Value
+		}
+		if s.Value > hi {
+			hi = s.Value
+		}
+	}
+
+	step := width
+	if len(samples) > 1 {
+		step = width / float64(len(samples)-1)
+	}
+
+	points := make([]string, 0, len(samples))
+	for i, s := range samples {
+		y := height / 2
+		if hi > lo {
+			y = height - (s.Value-lo)/(hi-lo)*height
+		}
+		points = append(points, fmt.Sprintf("%s,%s", formatPoint(float64(i)*step), formatPoint(y)))
+	}
+
+	return strings.Join(points, " ")
+}
+
+func formatPoint(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
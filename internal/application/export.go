@@ -0,0 +1,123 @@
+package application
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+func (app App) exportMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ms, err := app.findAll(storage.Query{})
+	if err != nil {
+		app.logger.Error().Msgf("Error while getting metrics list: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mds, err := app.metadataOf(ms)
+	if err != nil {
+		app.logger.Error().Msgf("Metadata find error: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	written := make(map[string]bool, len(ms))
+	for i, name := range exportNames(ms) {
+		if written[name] {
+			app.logger.Error().Msgf("Metric %s is not exported: name %s is already taken", ms[i].Name(), name)
+			continue
+		}
+		written[name] = true
+		writeExposition(bw, name, ms[i], mds[ms[i].Name()])
+	}
+
+	if stats, ok := storage.CacheStatsOf(app.storage); ok {
+		hits := metric.NewCounterMetric("sysmonitor_storage_cache_hits_total", metric.Counter(stats.Hits))
+		misses := metric.NewCounterMetric("sysmonitor_storage_cache_misses_total", metric.Counter(stats.Misses))
+		writeExposition(bw, hits.Name(), hits, metric.Metadata{Help: "Storage reads served from the cache."})
+		writeExposition(bw, misses.Name(), misses, metric.Metadata{Help: "Storage reads that went to the storage."})
+	}
+
+	if stats, ok := storage.BufferStatsOf(app.storage); ok {
+		failed := metric.NewCounterMetric("sysmonitor_storage_buffer_failed_flushes_total", metric.Counter(stats.FailedFlushes))
+		writeExposition(bw, failed.Name(), failed, metric.Metadata{Help: "Interval flushes of the write buffer that failed."})
+	}
+
+	if err := bw.Flush(); err != nil {
+		app.logger.Error().Msgf("Create response error: %s", err)
+	}
+}
+
+func (app App) metadataOf(ms []metric.IMetric) (map[string]metric.Metadata, error) {
+	names := make([]string, 0, len(ms))
+	for _, m := range ms {
+		names = append(names, m.Name())
+	}
+
+	return app.storage.FindMetadata(names)
+}
+
+// exportNames gives each metric its own family name. A name that is both a
+// gauge and a counter under the namespace policy gets a type suffix, since a
+// family has a single type.
+func exportNames(ms []metric.IMetric) []string {
+	types := make(map[string]string, len(ms))
+	mixed := make(map[string]bool)
+	for _, m := range ms {
+		name := exportName(m.Name())
+		if t, ok := types[name]; ok && t != m.Type() {
+			mixed[name] = true
+		}
+		types[name] = m.Type()
+	}
+
+	names := make([]string, 0, len(ms))
+	for _, m := range ms {
+		name := exportName(m.Name())
+		if mixed[name] {
+			name += "_" + m.Type()
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func writeExposition(w *bufio.Writer, name string, m metric.IMetric, md metric.Metadata) {
+	if md.Help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(md.Help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, m.Type())
+	if md.Unit != "" {
+		fmt.Fprintf(w, "# UNIT %s %s\n", name, md.Unit)
+	}
+	if md.Owner != "" {
+		fmt.Fprintf(w, "# OWNER %s %s\n", name, md.Owner)
+	}
+	fmt.Fprintf(w, "%s %s\n", name, m.ValueAsString())
+}
+
+func exportName(name string) string {
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
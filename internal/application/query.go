@@ -0,0 +1,96 @@
+package application
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+)
+
+type queryValue float64
+
+type querySample struct {
+	Labels expr.Labels `json:"labels"`
+	Value  queryValue  `json:"value"`
+}
+
+type queryResult struct {
+	ResultType expr.ValueType `json:"result_type"`
+	Result     interface{}    `json:"result"`
+}
+
+func (app App) queryHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	e, err := expr.Parse(params.Get("expr"))
+	if err != nil {
+		app.logger.Error().Msgf("Query parse error: %s", err)
+		sendJSONResponse(w, http.StatusBadRequest, []byte(err.Error()), app.logger)
+		return
+	}
+
+	ts := time.Now()
+	if t := params.Get("time"); t != "" {
+		ts, err = parseQueryTime(t)
+		if err != nil {
+			app.logger.Error().Msgf("Invalid time param: %s", t)
+			sendJSONResponse(w, http.StatusBadRequest, []byte("invalid time param"), app.logger)
+			return
+		}
+	}
+
+	v, err := e.Eval(app.metricSource(), ts)
+	if err != nil {
+		app.logger.Error().Msgf("Query evaluation error: %s", err)
+		sendJSONResponse(w, http.StatusUnprocessableEntity, []byte(err.Error()), app.logger)
+		return
+	}
+
+	res := queryResult{ResultType: v.Type()}
+	switch v := v.(type) {
+	case expr.Scalar:
+		res.Result = queryValue(v)
+	case expr.Vector:
+		samples := make([]querySample, 0, len(v))
+		for _, s := range v {
+			samples = append(samples, querySample{Labels: s.Labels, Value: queryValue(s.Value)})
+		}
+		res.Result = samples
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		app.logger.Error().Msgf("Query result marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	whole, frac := math.Modf(sec)
+
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
+
+func (v queryValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte(strconv.Quote(strconv.FormatFloat(f, 'g', -1, 64))), nil
+	}
+
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
@@ -0,0 +1,147 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/replication"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+const (
+	rolePrimary = "primary"
+	roleReplica = "replica"
+)
+
+type replicationStatus struct {
+	Role string `json:"role"`
+	replication.Status
+}
+
+// replicaTarget applies changes streamed from the primary to the local
+// storage and keeps history, anomalies and streams in step with them.
+type replicaTarget struct {
+	app *App
+	mem *storage.MemStorage
+}
+
+func (t replicaTarget) Apply(records ...fs.WALRecord) error {
+	if err := t.mem.Apply(records...); err != nil {
+		return err
+	}
+	t.app.afterReplay(records)
+
+	return nil
+}
+
+func (t replicaTarget) Reset(records ...fs.WALRecord) error {
+	if err := t.mem.Reset(records...); err != nil {
+		return err
+	}
+	t.app.afterReplay(records)
+
+	return nil
+}
+
+func (app App) afterReplay(records []fs.WALRecord) {
+	var (
+		updated []metric.IMetric
+		deleted []string
+	)
+	for _, r := range records {
+		switch r.Op {
+		case fs.WALUpdate:
+			if im, err := r.Metric.ToIMetric(); err == nil {
+				updated = append(updated, im)
+			}
+		case fs.WALDelete:
+			deleted = append(deleted, r.Metric.ID)
+		}
+	}
+
+	app.afterDelete(deleted...)
+	if len(updated) > 0 {
+		app.afterUpdate(updated...)
+	}
+}
+
+func (app App) readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.replica.Active() {
+			http.Error(w, "server is a read-only replica", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (app App) replicationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if app.replicationLog == nil {
+		http.Error(w, "replication log is disabled", http.StatusNotFound)
+		return
+	}
+
+	mem, itIsMem := storage.Unwrap(app.storage).(*storage.MemStorage)
+	if !itIsMem {
+		http.Error(w, "replication log is disabled", http.StatusNotFound)
+		return
+	}
+
+	from := replication.Position{Log: r.URL.Query().Get("log")}
+	if after := r.URL.Query().Get("after"); after != "" {
+		seq, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			app.logger.Error().Msgf("Invalid after param: %s", after)
+			http.Error(w, "invalid after param", http.StatusBadRequest)
+			return
+		}
+		from.Seq = seq
+	}
+
+	if err := replication.Serve(r.Context(), w, app.replicationLog, from, mem.Snapshot); err != nil {
+		app.logger.Error().Msgf("Replication stream error: %s", err)
+		if errors.Is(err, replication.ErrStreamingUnsupported) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (app App) replicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	app.sendReplicationStatus(w)
+}
+
+func (app App) promoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.replica.Active() || !app.replica.Promote() {
+		sendJSONResponse(w, http.StatusConflict, []byte("server is not a replica"), app.logger)
+		return
+	}
+	app.logger.Info().Msg("Replica promoted to primary")
+
+	app.sendReplicationStatus(w)
+}
+
+func (app App) sendReplicationStatus(w http.ResponseWriter) {
+	status := replicationStatus{Role: rolePrimary}
+	switch {
+	case app.replica.Active():
+		status.Role = roleReplica
+		status.Status = app.replica.Status()
+	case app.replicationLog != nil:
+		status.Log = app.replicationLog.ID()
+		status.Seq = app.replicationLog.Last()
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		app.logger.Error().Msgf("Replication status marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
@@ -0,0 +1,108 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/1g0rbm/sysmonitor/internal/alerting"
+	"github.com/1g0rbm/sysmonitor/internal/notify"
+	"github.com/1g0rbm/sysmonitor/internal/recording"
+)
+
+func (app App) getAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	states := []alerting.State{alerting.StatePending, alerting.StateFiring}
+	if s := r.URL.Query().Get("state"); s != "" {
+		st, err := alerting.ParseState(s)
+		if err != nil {
+			app.logger.Error().Msgf("Invalid state param: %s", s)
+			sendJSONResponse(w, http.StatusBadRequest, []byte(err.Error()), app.logger)
+			return
+		}
+		states = []alerting.State{st}
+	}
+
+	b, err := json.Marshal(app.alerts.Alerts(states...))
+	if err != nil {
+		app.logger.Error().Msgf("Alerts marshaling error: %s", err)
+		sendJSONResponse(w, http.StatusInternalServerError, []byte("internal server error"), app.logger)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, b, app.logger)
+}
+
+func (app App) loadRules() error {
+	rules, err := alerting.LoadRules(app.config.RulesFile)
+	if err != nil {
+		return err
+	}
+	app.alerts.SetRules(rules)
+	app.logger.Info().Msgf("%d alerting rules loaded from %s", len(rules), app.config.RulesFile)
+
+	records, err := recording.LoadRules(app.config.RulesFile)
+	if err != nil {
+		return err
+	}
+	app.recorder.SetRules(records)
+	app.logger.Info().Msgf("%d recording rules loaded from %s", len(records), app.config.RulesFile)
+
+	if app.config.Restore && app.config.NeedPersistAlerts() {
+		if err := app.alerts.Restore(app.config.AlertStateFile); err != nil {
+			return err
+		}
+		app.logger.Info().Msg("Alerts state restored from file")
+	}
+
+	return nil
+}
+
+func (app App) loadReceivers() error {
+	if !app.config.NeedNotify() {
+		return nil
+	}
+
+	receivers, err := notify.LoadReceivers(app.config.NotifyConfig)
+	if err != nil {
+		return err
+	}
+	app.notifier.SetReceivers(receivers)
+	app.logger.Info().Msgf("%d notification receivers loaded from %s", len(receivers), app.config.NotifyConfig)
+
+	return nil
+}
+
+func (app App) evalRules() {
+	src := app.metricSource()
+
+	app.recordMetrics(src)
+
+	if err := app.alerts.Eval(src); err != nil {
+		app.logger.Error().Msgf("Alerting rules evaluation error: %s", err)
+	}
+
+	app.notifier.Notify(app.alerts.Alerts(alerting.StateFiring, alerting.StateResolved))
+
+	if app.config.NeedPersistAlerts() {
+		if err := app.alerts.Save(app.config.AlertStateFile); err != nil {
+			app.logger.Error().Msgf("Alerts state save error: %s", err)
+		}
+	}
+}
+
+func (app App) recordMetrics(src metricSource) {
+	ms, err := app.recorder.Eval(src)
+	if err != nil {
+		app.logger.Error().Msgf("Recording rules evaluation error: %s", err)
+	}
+	if len(ms) == 0 || app.replica.Active() {
+		return
+	}
+
+	updated, err := app.storage.BatchUpdate(ms)
+	if err != nil {
+		app.logger.Error().Msgf("Recorded metrics update error: %s", err)
+		return
+	}
+
+	app.afterUpdate(updated...)
+}
@@ -0,0 +1,112 @@
+package application
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/anomaly"
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+	"github.com/1g0rbm/sysmonitor/internal/history"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/storage"
+)
+
+type metricSource struct {
+	storage   storage.Storage
+	history   *history.History
+	anomalies *anomaly.Detector
+}
+
+func (app App) metricSource() metricSource {
+	return metricSource{storage: app.storage, history: app.history, anomalies: app.anomalies}
+}
+
+func (s metricSource) Select(matchers []*expr.Matcher) (expr.Vector, error) {
+	ms, err := s.find(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(expr.Vector, 0, len(ms))
+	for _, m := range ms {
+		v, err := strconv.ParseFloat(m.ValueAsString(), 64)
+		if err != nil {
+			continue
+		}
+		result = append(result, expr.Sample{Labels: metricLabels(m), Value: v})
+	}
+
+	for _, score := range s.anomalies.Scores() {
+		l := expr.Labels{expr.MetricNameLabel: anomaly.ScoreMetric, anomaly.MetricLabel: score.Name}
+		if expr.MatchAll(matchers, l) {
+			result = append(result, expr.Sample{Labels: l, Value: score.Value})
+		}
+	}
+
+	return result, nil
+}
+
+func (s metricSource) SelectRange(matchers []*expr.Matcher, from time.Time, to time.Time) (expr.Matrix, error) {
+	ms, err := s.find(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(expr.Matrix, 0, len(ms))
+	for _, m := range ms {
+		series := expr.Series{Labels: metricLabels(m)}
+		for _, sample := range s.history.Range(m.Name(), from) {
+			if sample.Time.After(to) {
+				break
+			}
+			series.Points = append(series.Points, expr.Point{Time: sample.Time, Value: sample.Value})
+		}
+		result = append(result, series)
+	}
+
+	return result, nil
+}
+
+func (s metricSource) find(matchers []*expr.Matcher) ([]metric.IMetric, error) {
+	var candidates []metric.IMetric
+	if name, ok := nameMatcher(matchers); ok {
+		m, err := s.storage.Get(name)
+		if errors.Is(err, storage.ErrMetricNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = []metric.IMetric{m}
+	} else {
+		ms, err := s.storage.Find(storage.Query{})
+		if err != nil {
+			return nil, err
+		}
+		candidates = ms
+	}
+
+	result := candidates[:0]
+	for _, m := range candidates {
+		if expr.MatchAll(matchers, metricLabels(m)) {
+			result = append(result, m)
+		}
+	}
+
+	return result, nil
+}
+
+func nameMatcher(matchers []*expr.Matcher) (string, bool) {
+	for _, m := range matchers {
+		if m.Name == expr.MetricNameLabel && m.Type == expr.MatchEqual {
+			return m.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func metricLabels(m metric.IMetric) expr.Labels {
+	return expr.Labels{expr.MetricNameLabel: m.Name(), expr.TypeLabel: m.Type()}
+}
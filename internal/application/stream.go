@@ -0,0 +1,59 @@
+package application
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+	"github.com/1g0rbm/sysmonitor/internal/stream"
+)
+
+// hubFeed publishes metrics updated in storage to stream subscribers.
+type hubFeed struct {
+	hub *stream.Hub
+}
+
+func (f hubFeed) Append(records ...fs.WALRecord) error {
+	if !f.hub.HasSubscribers() {
+		return nil
+	}
+
+	updated := make([]metric.IMetric, 0, len(records))
+	for _, r := range records {
+		if r.Op != fs.WALUpdate {
+			continue
+		}
+		if im, err := r.Metric.ToIMetric(); err == nil {
+			updated = append(updated, im)
+		}
+	}
+	f.hub.Publish(updated...)
+
+	return nil
+}
+
+func (app App) streamHandler(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if q := r.URL.Query().Get("names"); q != "" {
+		names = strings.Split(q, ",")
+	}
+
+	sub := app.hub.Subscribe(names)
+	defer app.hub.Unsubscribe(sub)
+
+	if stream.IsWebSocketRequest(r) {
+		if err := stream.ServeWebSocket(r.Context(), w, r, sub); err != nil {
+			app.logger.Error().Msgf("Websocket stream error: %s", err)
+		}
+		return
+	}
+
+	if err := stream.ServeSSE(r.Context(), w, sub); err != nil {
+		app.logger.Error().Msgf("SSE stream error: %s", err)
+		if errors.Is(err, stream.ErrStreamingUnsupported) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -1,19 +1,21 @@
 package application
 
-const AllMetricsTemplate = `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>Find metrics</title>
-</head>
-<body>
-<h1>List of metrics</h1>
-<ul>
-    {{range .}}
-        <li>{{.Name}}:{{.ValueAsString}}</li>
-    {{end}}
-</ul>
-</body>
-</html>
-`
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+)
+
+//go:embed web/templates/*.html
+var templatesFS embed.FS
+
+//go:embed web/static
+var staticFS embed.FS
+
+func parseTemplates() (*template.Template, error) {
+	return template.ParseFS(templatesFS, "web/templates/*.html")
+}
+
+func staticFiles() (fs.FS, error) {
+	return fs.Sub(staticFS, "web/static")
+}
@@ -0,0 +1,94 @@
+(function () {
+    "use strict";
+
+    var state = {filter: "", sorts: {}};
+
+    function tables() {
+        return Array.prototype.slice.call(document.querySelectorAll("table.metrics"));
+    }
+
+    function compare(a, b, key) {
+        if (key === "value") {
+            return parseFloat(a.dataset.value) - parseFloat(b.dataset.value);
+        }
+        return a.dataset.name.localeCompare(b.dataset.name);
+    }
+
+    function applySort(table, index) {
+        var sort = state.sorts[index];
+        if (!sort) {
+            return;
+        }
+        var body = table.tBodies[0];
+        var rows = Array.prototype.slice.call(body.querySelectorAll("tr[data-name]"));
+        rows.sort(function (a, b) {
+            var r = compare(a, b, sort.key);
+            return sort.dir === "desc" ? -r : r;
+        });
+        rows.forEach(function (row) {
+            body.appendChild(row);
+        });
+        table.querySelectorAll("th[data-sort]").forEach(function (th) {
+            th.dataset.dir = th.dataset.sort === sort.key ? sort.dir : "";
+        });
+    }
+
+    function applyFilter(table) {
+        var needle = state.filter.toLowerCase();
+        table.querySelectorAll("tr[data-name]").forEach(function (row) {
+            row.hidden = needle !== "" && row.dataset.name.toLowerCase().indexOf(needle) === -1;
+        });
+    }
+
+    function apply() {
+        tables().forEach(function (table, index) {
+            applySort(table, index);
+            applyFilter(table);
+            table.querySelectorAll("th[data-sort]").forEach(function (th) {
+                th.onclick = function () {
+                    var current = state.sorts[index];
+                    var dir = current && current.key === th.dataset.sort && current.dir === "asc" ? "desc" : "asc";
+                    state.sorts[index] = {key: th.dataset.sort, dir: dir};
+                    applySort(table, index);
+                };
+            });
+        });
+    }
+
+    function refresh() {
+        var toggle = document.getElementById("autorefresh");
+        if (toggle && !toggle.checked) {
+            return;
+        }
+        fetch(window.location.href, {headers: {"Accept": "text/html"}})
+            .then(function (resp) {
+                return resp.ok ? resp.text() : Promise.reject(resp.status);
+            })
+            .then(function (html) {
+                var doc = new DOMParser().parseFromString(html, "text/html");
+                var next = doc.getElementById("dashboard");
+                if (next) {
+                    document.getElementById("dashboard").innerHTML = next.innerHTML;
+                    apply();
+                }
+            })
+            .catch(function () {
+            });
+    }
+
+    var filter = document.getElementById("filter");
+    if (filter) {
+        filter.addEventListener("input", function () {
+            state.filter = filter.value;
+            tables().forEach(applyFilter);
+        });
+    }
+
+    var main = document.getElementById("dashboard");
+    var seconds = main ? parseInt(main.dataset.refresh, 10) : 0;
+    if (seconds > 0) {
+        window.setInterval(refresh, seconds * 1000);
+    }
+
+    apply();
+})();
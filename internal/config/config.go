@@ -8,36 +8,140 @@ import (
 )
 
 const (
-	defaultAddress        = "127.0.0.1:8080"
-	defaultReportInterval = 10 * time.Second
-	defaultPollInterval   = 2 * time.Second
-	defaultStoreInterval  = 300 * time.Second
-	defaultStoreFile      = "/tmp/devops-metrics-db.json"
-	defaultRestore        = true
-	defaultKey            = ""
-	defaultDBDsn          = ""
-	defaultRateLimit      = 4
+	defaultAddress             = "127.0.0.1:8080"
+	defaultReportInterval      = 10 * time.Second
+	defaultPollInterval        = 2 * time.Second
+	defaultStoreInterval       = 300 * time.Second
+	defaultStoreFile           = "/tmp/devops-metrics-db.json"
+	defaultStoreKeep           = 3
+	defaultStoreFormat         = ""
+	defaultRestore             = true
+	defaultKey                 = ""
+	defaultDBDsn               = ""
+	defaultRateLimit           = 4
+	defaultStreamBuffer        = 64
+	defaultHistorySize         = 8640
+	defaultAdminToken          = ""
+	defaultMetricTTL           = 0
+	defaultRulesFile           = ""
+	defaultRulesInterval       = 15 * time.Second
+	defaultAlertStateFile      = "/tmp/devops-alerts-state.json"
+	defaultNotifyConfig        = ""
+	defaultDeadAfter           = 3
+	defaultAnomalyZScore       = 0
+	defaultRawRetention        = 24 * time.Hour
+	defaultMinuteRetention     = 30 * 24 * time.Hour
+	defaultHourRetention       = 365 * 24 * time.Hour
+	defaultWALFile             = ""
+	defaultTypeConflict        = ""
+	defaultWALSync             = "interval"
+	defaultWALSyncInterval     = time.Second
+	defaultWriteBufferSize     = 0
+	defaultWriteBufferInterval = time.Second
+	defaultCacheSize           = 0
+	defaultCacheTTL            = 5 * time.Second
+	defaultMemShards           = 16
+	defaultDBMaxOpenConns      = 10
+	defaultDBMaxIdleConns      = 5
+	defaultDBConnMaxLifetime   = 30 * time.Minute
+	defaultDBConnMaxIdleTime   = 5 * time.Minute
+	defaultDBQueryTimeout      = 5 * time.Second
+	defaultDBRetries           = 2
+	defaultDBBreakerThreshold  = 5
+	defaultDBBreakerCooldown   = 10 * time.Second
+	defaultReplicaOf           = ""
+	defaultReplicationLogSize  = 0
 )
 
 var (
-	address        string
-	reportInterval time.Duration
-	pollInterval   time.Duration
-	storeInterval  time.Duration
-	storeFile      string
-	restore        bool
-	key            string
-	DBDsn          string
-	rateLimit      int
+	address             string
+	reportInterval      time.Duration
+	pollInterval        time.Duration
+	storeInterval       time.Duration
+	storeFile           string
+	storeKeep           int
+	storeFormat         string
+	restore             bool
+	key                 string
+	DBDsn               string
+	rateLimit           int
+	streamBuffer        int
+	historySize         int
+	adminToken          string
+	metricTTL           time.Duration
+	rulesFile           string
+	rulesInterval       time.Duration
+	alertStateFile      string
+	notifyConfig        string
+	deadAfter           int
+	agentID             string
+	anomalyZScore       float64
+	rawRetention        time.Duration
+	minuteRetention     time.Duration
+	hourRetention       time.Duration
+	walFile             string
+	typeConflict        string
+	walSync             string
+	walSyncInterval     time.Duration
+	writeBufferSize     int
+	writeBufferInterval time.Duration
+	cacheSize           int
+	cacheTTL            time.Duration
+	memShards           int
+	dbMaxOpenConns      int
+	dbMaxIdleConns      int
+	dbConnMaxLifetime   time.Duration
+	dbConnMaxIdleTime   time.Duration
+	dbQueryTimeout      time.Duration
+	dbRetries           int
+	dbBreakerThreshold  int
+	dbBreakerCooldown   time.Duration
+	replicaOf           string
+	replicationLogSize  int
 )
 
 type ServerConfig struct {
-	Address       string
-	StoreInterval time.Duration
-	StoreFile     string
-	Restore       bool
-	Key           string
-	DBDsn         string
+	Address             string
+	StoreInterval       time.Duration
+	StoreFile           string
+	StoreKeep           int
+	StoreFormat         string
+	Restore             bool
+	Key                 string
+	DBDsn               string
+	StreamBuffer        int
+	HistorySize         int
+	AdminToken          string
+	MetricTTL           time.Duration
+	RulesFile           string
+	RulesInterval       time.Duration
+	AlertStateFile      string
+	NotifyConfig        string
+	ReportInterval      time.Duration
+	DeadAfter           int
+	AnomalyZScore       float64
+	RawRetention        time.Duration
+	MinuteRetention     time.Duration
+	HourRetention       time.Duration
+	WALFile             string
+	TypeConflict        string
+	WALSync             string
+	WALSyncInterval     time.Duration
+	WriteBufferSize     int
+	WriteBufferInterval time.Duration
+	CacheSize           int
+	CacheTTL            time.Duration
+	MemShards           int
+	DBMaxOpenConns      int
+	DBMaxIdleConns      int
+	DBConnMaxLifetime   time.Duration
+	DBConnMaxIdleTime   time.Duration
+	DBQueryTimeout      time.Duration
+	DBRetries           int
+	DBBreakerThreshold  int
+	DBBreakerCooldown   time.Duration
+	ReplicaOf           string
+	ReplicationLogSize  int
 }
 
 type AgentConfig struct {
@@ -46,25 +150,96 @@ type AgentConfig struct {
 	PollInterval   time.Duration
 	Key            string
 	RateLimit      int
+	ID             string
 }
 
 func GetConfigServer() *ServerConfig {
 	flag.StringVar(&address, "a", defaultAddress, "-a=<VALUE>")
 	flag.DurationVar(&storeInterval, "i", defaultStoreInterval, "-i=<VALUE>")
 	flag.StringVar(&storeFile, "f", defaultStoreFile, "-f=<VALUE")
+	flag.IntVar(&storeKeep, "store-keep", defaultStoreKeep, "-store-keep=<VALUE>")
+	flag.StringVar(&storeFormat, "store-format", defaultStoreFormat, "-store-format=<json|binary|binary+gzip>")
 	flag.BoolVar(&restore, "r", defaultRestore, "-r=<VALUE>")
 	flag.StringVar(&key, "k", defaultKey, "-k=<KEY>")
 	flag.StringVar(&DBDsn, "d", defaultDBDsn, "-d=<DATABASE_DSN>")
+	flag.IntVar(&streamBuffer, "stream-buffer", defaultStreamBuffer, "-stream-buffer=<VALUE>")
+	flag.IntVar(&historySize, "history-size", defaultHistorySize, "-history-size=<VALUE>")
+	flag.StringVar(&adminToken, "admin-token", defaultAdminToken, "-admin-token=<TOKEN>")
+	flag.DurationVar(&metricTTL, "metric-ttl", defaultMetricTTL, "-metric-ttl=<VALUE>")
+	flag.StringVar(&rulesFile, "rules", defaultRulesFile, "-rules=<PATH>")
+	flag.DurationVar(&rulesInterval, "rules-interval", defaultRulesInterval, "-rules-interval=<VALUE>")
+	flag.StringVar(&alertStateFile, "alert-state-file", defaultAlertStateFile, "-alert-state-file=<PATH>")
+	flag.StringVar(&notifyConfig, "notify-config", defaultNotifyConfig, "-notify-config=<PATH>")
+	flag.DurationVar(&reportInterval, "report-interval", defaultReportInterval, "-report-interval=<VALUE>")
+	flag.IntVar(&deadAfter, "dead-after", defaultDeadAfter, "-dead-after=<VALUE>")
+	flag.Float64Var(&anomalyZScore, "anomaly-zscore", defaultAnomalyZScore, "-anomaly-zscore=<VALUE>")
+	flag.DurationVar(&rawRetention, "raw-retention", defaultRawRetention, "-raw-retention=<VALUE>")
+	flag.DurationVar(&minuteRetention, "minute-retention", defaultMinuteRetention, "-minute-retention=<VALUE>")
+	flag.DurationVar(&hourRetention, "hour-retention", defaultHourRetention, "-hour-retention=<VALUE>")
+	flag.StringVar(&typeConflict, "type-conflict", defaultTypeConflict, "-type-conflict=<reject|namespace|last-writer-wins>")
+	flag.StringVar(&walFile, "wal", defaultWALFile, "-wal=<PATH>")
+	flag.StringVar(&walSync, "wal-sync", defaultWALSync, "-wal-sync=<always|interval|never>")
+	flag.DurationVar(&walSyncInterval, "wal-sync-interval", defaultWALSyncInterval, "-wal-sync-interval=<VALUE>")
+	flag.IntVar(&writeBufferSize, "write-buffer-size", defaultWriteBufferSize, "-write-buffer-size=<VALUE>")
+	flag.DurationVar(&writeBufferInterval, "write-buffer-interval", defaultWriteBufferInterval, "-write-buffer-interval=<VALUE>")
+	flag.IntVar(&cacheSize, "cache-size", defaultCacheSize, "-cache-size=<VALUE>")
+	flag.DurationVar(&cacheTTL, "cache-ttl", defaultCacheTTL, "-cache-ttl=<VALUE>")
+	flag.IntVar(&memShards, "mem-shards", defaultMemShards, "-mem-shards=<VALUE>")
+	flag.IntVar(&dbMaxOpenConns, "db-max-open-conns", defaultDBMaxOpenConns, "-db-max-open-conns=<VALUE>")
+	flag.IntVar(&dbMaxIdleConns, "db-max-idle-conns", defaultDBMaxIdleConns, "-db-max-idle-conns=<VALUE>")
+	flag.DurationVar(&dbConnMaxLifetime, "db-conn-max-lifetime", defaultDBConnMaxLifetime, "-db-conn-max-lifetime=<VALUE>")
+	flag.DurationVar(&dbConnMaxIdleTime, "db-conn-max-idle-time", defaultDBConnMaxIdleTime, "-db-conn-max-idle-time=<VALUE>")
+	flag.DurationVar(&dbQueryTimeout, "db-query-timeout", defaultDBQueryTimeout, "-db-query-timeout=<VALUE>")
+	flag.IntVar(&dbRetries, "db-retries", defaultDBRetries, "-db-retries=<VALUE>")
+	flag.IntVar(&dbBreakerThreshold, "db-breaker-threshold", defaultDBBreakerThreshold, "-db-breaker-threshold=<VALUE>")
+	flag.DurationVar(&dbBreakerCooldown, "db-breaker-cooldown", defaultDBBreakerCooldown, "-db-breaker-cooldown=<VALUE>")
+	flag.StringVar(&replicaOf, "replica-of", defaultReplicaOf, "-replica-of=<URL>")
+	flag.IntVar(&replicationLogSize, "replication-log-size", defaultReplicationLogSize, "-replication-log-size=<VALUE>")
 
 	flag.Parse()
 
 	return &ServerConfig{
-		Address:       getEnvString("ADDRESS", address),
-		StoreInterval: getEnvDuration("STORE_INTERVAL", storeInterval),
-		StoreFile:     getEnvString("STORE_FILE", storeFile),
-		Restore:       getEnvBool("RESTORE", restore),
-		Key:           getEnvString("KEY", key),
-		DBDsn:         getEnvString("DATABASE_DSN", DBDsn),
+		Address:             getEnvString("ADDRESS", address),
+		StoreInterval:       getEnvDuration("STORE_INTERVAL", storeInterval),
+		StoreFile:           getEnvString("STORE_FILE", storeFile),
+		StoreKeep:           getEnvInt("STORE_KEEP", storeKeep),
+		StoreFormat:         getEnvString("STORE_FORMAT", storeFormat),
+		Restore:             getEnvBool("RESTORE", restore),
+		Key:                 getEnvString("KEY", key),
+		DBDsn:               getEnvString("DATABASE_DSN", DBDsn),
+		StreamBuffer:        getEnvInt("STREAM_BUFFER", streamBuffer),
+		HistorySize:         getEnvInt("HISTORY_SIZE", historySize),
+		AdminToken:          getEnvString("ADMIN_TOKEN", adminToken),
+		MetricTTL:           getEnvDuration("METRIC_TTL", metricTTL),
+		RulesFile:           getEnvString("RULES_FILE", rulesFile),
+		RulesInterval:       getEnvDuration("RULES_INTERVAL", rulesInterval),
+		AlertStateFile:      getEnvString("ALERT_STATE_FILE", alertStateFile),
+		NotifyConfig:        getEnvString("NOTIFY_CONFIG", notifyConfig),
+		ReportInterval:      getEnvDuration("REPORT_INTERVAL", reportInterval),
+		DeadAfter:           getEnvInt("DEAD_AFTER", deadAfter),
+		AnomalyZScore:       getEnvFloat("ANOMALY_ZSCORE", anomalyZScore),
+		RawRetention:        getEnvDuration("RAW_RETENTION", rawRetention),
+		MinuteRetention:     getEnvDuration("MINUTE_RETENTION", minuteRetention),
+		HourRetention:       getEnvDuration("HOUR_RETENTION", hourRetention),
+		WALFile:             getEnvString("WAL_FILE", walFile),
+		TypeConflict:        getEnvString("TYPE_CONFLICT", typeConflict),
+		WALSync:             getEnvString("WAL_SYNC", walSync),
+		WALSyncInterval:     getEnvDuration("WAL_SYNC_INTERVAL", walSyncInterval),
+		WriteBufferSize:     getEnvInt("WRITE_BUFFER_SIZE", writeBufferSize),
+		WriteBufferInterval: getEnvDuration("WRITE_BUFFER_INTERVAL", writeBufferInterval),
+		CacheSize:           getEnvInt("CACHE_SIZE", cacheSize),
+		CacheTTL:            getEnvDuration("CACHE_TTL", cacheTTL),
+		MemShards:           getEnvInt("MEM_SHARDS", memShards),
+		DBMaxOpenConns:      getEnvInt("DB_MAX_OPEN_CONNS", dbMaxOpenConns),
+		DBMaxIdleConns:      getEnvInt("DB_MAX_IDLE_CONNS", dbMaxIdleConns),
+		DBConnMaxLifetime:   getEnvDuration("DB_CONN_MAX_LIFETIME", dbConnMaxLifetime),
+		DBConnMaxIdleTime:   getEnvDuration("DB_CONN_MAX_IDLE_TIME", dbConnMaxIdleTime),
+		DBQueryTimeout:      getEnvDuration("DB_QUERY_TIMEOUT", dbQueryTimeout),
+		DBRetries:           getEnvInt("DB_RETRIES", dbRetries),
+		DBBreakerThreshold:  getEnvInt("DB_BREAKER_THRESHOLD", dbBreakerThreshold),
+		DBBreakerCooldown:   getEnvDuration("DB_BREAKER_COOLDOWN", dbBreakerCooldown),
+		ReplicaOf:           getEnvString("REPLICA_OF", replicaOf),
+		ReplicationLogSize:  getEnvInt("REPLICATION_LOG_SIZE", replicationLogSize),
 	}
 }
 
@@ -76,6 +251,50 @@ func (sc ServerConfig) NeedPeriodicalStore() bool {
 	return sc.DBDsn == "" && (sc.StoreInterval > 0 && sc.StoreFile != "")
 }
 
+func (sc ServerConfig) NeedWAL() bool {
+	return sc.DBDsn == "" && sc.WALFile != ""
+}
+
+func (sc ServerConfig) NeedWriteBuffer() bool {
+	return sc.WriteBufferSize > 0 && sc.WriteBufferInterval > 0
+}
+
+func (sc ServerConfig) NeedCache() bool {
+	return sc.CacheSize > 0 && sc.CacheTTL > 0
+}
+
+func (sc ServerConfig) NeedReplica() bool {
+	return sc.DBDsn == "" && sc.ReplicaOf != ""
+}
+
+func (sc ServerConfig) NeedReplicationLog() bool {
+	return sc.DBDsn == "" && sc.ReplicationLogSize > 0
+}
+
+func (sc ServerConfig) NeedExpireMetrics() bool {
+	return sc.MetricTTL > 0
+}
+
+func (sc ServerConfig) NeedEvaluateRules() bool {
+	return sc.RulesFile != "" && sc.RulesInterval > 0
+}
+
+func (sc ServerConfig) NeedPersistAlerts() bool {
+	return sc.NeedEvaluateRules() && sc.AlertStateFile != ""
+}
+
+func (sc ServerConfig) NeedNotify() bool {
+	return sc.NeedEvaluateRules() && sc.NotifyConfig != ""
+}
+
+func (sc ServerConfig) NeedCheckAgents() bool {
+	return sc.ReportInterval > 0
+}
+
+func (sc ServerConfig) NeedDetectAnomalies() bool {
+	return sc.AnomalyZScore > 0
+}
+
 func (sc ServerConfig) NeedCheckSign() bool {
 	return sc.Key != ""
 }
@@ -86,6 +305,7 @@ func GetConfigAgent() *AgentConfig {
 	flag.DurationVar(&pollInterval, "p", defaultPollInterval, "-p=<VALUE>")
 	flag.StringVar(&key, "k", defaultKey, "-k=<KEY>")
 	flag.IntVar(&rateLimit, "l", defaultRateLimit, "-l=<VALUE>")
+	flag.StringVar(&agentID, "id", defaultAgentID(), "-id=<VALUE>")
 
 	flag.Parse()
 
@@ -95,7 +315,17 @@ func GetConfigAgent() *AgentConfig {
 		PollInterval:   getEnvDuration("POLL_INTERVAL", pollInterval),
 		Key:            getEnvString("KEY", key),
 		RateLimit:      getEnvInt("RATE_LIMIT", rateLimit),
+		ID:             getEnvString("AGENT_ID", agentID),
+	}
+}
+
+func defaultAgentID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
 	}
+
+	return hostname
 }
 
 func (ac AgentConfig) NeedSign() bool {
@@ -151,3 +381,17 @@ func getEnvInt(name string, defaultValue int) int {
 
 	return int(i)
 }
+
+func getEnvFloat(name string, defaultValue float64) float64 {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return f
+}
@@ -0,0 +1,70 @@
+package expr
+
+import (
+	"math"
+)
+
+type aggregation func(values []float64) float64
+
+var aggregations = map[string]aggregation{
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		result := math.Inf(1)
+		for _, v := range values {
+			result = math.Min(result, v)
+		}
+		return result
+	},
+	"max": func(values []float64) float64 {
+		result := math.Inf(-1)
+		for _, v := range values {
+			result = math.Max(result, v)
+		}
+		return result
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+func aggregate(op string, grouping []string, v Vector) Vector {
+	var keys []string
+	labels := make(map[string]Labels)
+	values := make(map[string][]float64)
+
+	for _, s := range v {
+		l := make(Labels, len(grouping))
+		for _, name := range grouping {
+			if value, ok := s.Labels[name]; ok {
+				l[name] = value
+			}
+		}
+
+		key := l.String()
+		if _, ok := labels[key]; !ok {
+			keys = append(keys, key)
+			labels[key] = l
+		}
+		values[key] = append(values[key], s.Value)
+	}
+
+	result := make(Vector, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, Sample{Labels: labels[key], Value: aggregations[op](values[key])})
+	}
+
+	return result
+}
@@ -0,0 +1,136 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Node interface {
+	String() string
+}
+
+type NumberLiteral struct {
+	Value float64
+}
+
+type VectorSelector struct {
+	Name     string
+	Matchers []*Matcher
+}
+
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+type Call struct {
+	Func string
+	Args []Node
+}
+
+type UnaryExpr struct {
+	Op   string
+	Expr Node
+}
+
+type BinaryExpr struct {
+	Op  string
+	LHS Node
+	RHS Node
+}
+
+type ParenExpr struct {
+	Expr Node
+}
+
+type AggregateExpr struct {
+	Op       string
+	Grouping []string
+	Expr     Node
+}
+
+func (n *NumberLiteral) String() string {
+	return strconv.FormatFloat(n.Value, 'g', -1, 64)
+}
+
+func (n *VectorSelector) String() string {
+	ms := make([]string, 0, len(n.Matchers))
+	for _, m := range n.Matchers {
+		if m.Name == MetricNameLabel && m.Type == MatchEqual && m.Value == n.Name {
+			continue
+		}
+		ms = append(ms, m.String())
+	}
+
+	if len(ms) == 0 {
+		return n.Name
+	}
+
+	return n.Name + "{" + strings.Join(ms, ",") + "}"
+}
+
+func (n *MatrixSelector) String() string {
+	return fmt.Sprintf("%s[%s]", n.Vector, formatDuration(n.Range))
+}
+
+func (n *Call) String() string {
+	args := make([]string, 0, len(n.Args))
+	for _, a := range n.Args {
+		args = append(args, a.String())
+	}
+
+	return fmt.Sprintf("%s(%s)", n.Func, strings.Join(args, ", "))
+}
+
+func (n *UnaryExpr) String() string {
+	return n.Op + n.Expr.String()
+}
+
+func (n *BinaryExpr) String() string {
+	return fmt.Sprintf("%s %s %s", n.LHS, n.Op, n.RHS)
+}
+
+func (n *AggregateExpr) String() string {
+	if len(n.Grouping) == 0 {
+		return fmt.Sprintf("%s(%s)", n.Op, n.Expr)
+	}
+
+	return fmt.Sprintf("%s by (%s) (%s)", n.Op, strings.Join(n.Grouping, ", "), n.Expr)
+}
+
+func (n *ParenExpr) String() string {
+	return "(" + n.Expr.String() + ")"
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
@@ -0,0 +1,306 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type evaluator struct {
+	src Source
+	ts  time.Time
+}
+
+func (e *Expr) Eval(src Source, ts time.Time) (Value, error) {
+	ev := evaluator{src: src, ts: ts}
+
+	return ev.eval(e.root)
+}
+
+func (ev evaluator) eval(n Node) (Value, error) {
+	switch n := n.(type) {
+	case *NumberLiteral:
+		return Scalar(n.Value), nil
+	case *ParenExpr:
+		return ev.eval(n.Expr)
+	case *VectorSelector:
+		return ev.src.Select(n.Matchers)
+	case *MatrixSelector:
+		return ev.src.SelectRange(n.Vector.Matchers, ev.ts.Add(-n.Range), ev.ts)
+	case *UnaryExpr:
+		v, err := ev.eval(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return negate(v), nil
+	case *Call:
+		args := make([]Value, 0, len(n.Args))
+		for _, a := range n.Args {
+			v, err := ev.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		return functions[n.Func].call(args, ev.ts)
+	case *AggregateExpr:
+		v, err := ev.eval(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(n.Op, n.Grouping, v.(Vector)), nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(n.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := ev.eval(n.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return binary(n.Op, lhs, rhs)
+	default:
+		return nil, fmt.Errorf("unsupported expression %s", n)
+	}
+}
+
+func negate(v Value) Value {
+	switch v := v.(type) {
+	case Scalar:
+		return -v
+	case Vector:
+		return mapVector(v, func(f float64) float64 { return -f })
+	default:
+		return v
+	}
+}
+
+func binary(op string, lhs Value, rhs Value) (Value, error) {
+	if op == "and" || op == "or" {
+		return setOperation(op, lhs.(Vector), rhs.(Vector)), nil
+	}
+
+	switch l := lhs.(type) {
+	case Scalar:
+		switch r := rhs.(type) {
+		case Scalar:
+			v, keep := apply(op, float64(l), float64(r))
+			if isComparison(op) {
+				return Scalar(boolToFloat(keep)), nil
+			}
+			return Scalar(v), nil
+		case Vector:
+			return vectorScalar(op, r, float64(l), true), nil
+		}
+	case Vector:
+		switch r := rhs.(type) {
+		case Scalar:
+			return vectorScalar(op, l, float64(r), false), nil
+		case Vector:
+			return vectorVector(op, l, r)
+		}
+	}
+
+	return nil, fmt.Errorf("invalid operands for %q: %s and %s", op, lhs.Type(), rhs.Type())
+}
+
+func vectorScalar(op string, v Vector, s float64, swap bool) Vector {
+	result := make(Vector, 0, len(v))
+	for _, sample := range v {
+		l, r := sample.Value, s
+		if swap {
+			l, r = r, l
+		}
+
+		value, keep := apply(op, l, r)
+		switch {
+		case !isComparison(op):
+			result = append(result, Sample{Labels: sample.Labels.WithoutName(), Value: value})
+		case keep:
+			result = append(result, sample)
+		}
+	}
+
+	return result
+}
+
+func vectorVector(op string, lhs Vector, rhs Vector) (Vector, error) {
+	index := make(map[string]Sample, len(rhs))
+	for _, s := range rhs {
+		sig := s.Labels.signature()
+		if _, ok := index[sig]; ok {
+			return nil, fmt.Errorf("many-to-many matching not allowed: found duplicate series for %s on the right side of %q", sig, op)
+		}
+		index[sig] = s
+	}
+
+	result := make(Vector, 0, len(lhs))
+	for _, l := range lhs {
+		r, ok := index[l.Labels.signature()]
+		if !ok {
+			continue
+		}
+
+		value, keep := apply(op, l.Value, r.Value)
+		switch {
+		case !isComparison(op):
+			result = append(result, Sample{Labels: l.Labels.WithoutName(), Value: value})
+		case keep:
+			result = append(result, l)
+		}
+	}
+
+	return result, nil
+}
+
+func setOperation(op string, lhs Vector, rhs Vector) Vector {
+	rhsSigs := make(map[string]struct{}, len(rhs))
+	for _, s := range rhs {
+		rhsSigs[s.Labels.signature()] = struct{}{}
+	}
+
+	result := make(Vector, 0, len(lhs))
+	lhsSigs := make(map[string]struct{}, len(lhs))
+	for _, s := range lhs {
+		lhsSigs[s.Labels.signature()] = struct{}{}
+		if _, ok := rhsSigs[s.Labels.signature()]; ok || op == "or" {
+			result = append(result, s)
+		}
+	}
+
+	if op == "or" {
+		for _, s := range rhs {
+			if _, ok := lhsSigs[s.Labels.signature()]; !ok {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return result
+}
+
+func apply(op string, l float64, r float64) (float64, bool) {
+	switch op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		return l / r, true
+	case "%":
+		return math.Mod(l, r), true
+	case "==":
+		return l, l == r
+	case "!=":
+		return l, l != r
+	case ">":
+		return l, l > r
+	case "<":
+		return l, l < r
+	case ">=":
+		return l, l >= r
+	case "<=":
+		return l, l <= r
+	default:
+		return math.NaN(), false
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", ">", "<", ">=", "<=":
+		return true
+	default:
+		return false
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func checkTypes(n Node) error {
+	t, err := typeOf(n)
+	if err != nil {
+		return err
+	}
+
+	if t == ValueTypeMatrix {
+		return fmt.Errorf("range selector %s must be used inside a function", n)
+	}
+
+	return nil
+}
+
+func typeOf(n Node) (ValueType, error) {
+	switch n := n.(type) {
+	case *NumberLiteral:
+		return ValueTypeScalar, nil
+	case *VectorSelector:
+		return ValueTypeVector, nil
+	case *MatrixSelector:
+		return ValueTypeMatrix, nil
+	case *ParenExpr:
+		return typeOf(n.Expr)
+	case *UnaryExpr:
+		t, err := typeOf(n.Expr)
+		if err != nil {
+			return "", err
+		}
+		if t == ValueTypeMatrix {
+			return "", fmt.Errorf("unary %q is not allowed on range selector %s", n.Op, n.Expr)
+		}
+		return t, nil
+	case *Call:
+		f := functions[n.Func]
+		if len(n.Args) != len(f.args) {
+			return "", fmt.Errorf("function %q expects %d arguments, got %d", n.Func, len(f.args), len(n.Args))
+		}
+		for i, a := range n.Args {
+			t, err := typeOf(a)
+			if err != nil {
+				return "", err
+			}
+			if t != f.args[i] {
+				return "", fmt.Errorf("function %q expects %s argument, got %s", n.Func, f.args[i], t)
+			}
+		}
+		return f.result, nil
+	case *AggregateExpr:
+		t, err := typeOf(n.Expr)
+		if err != nil {
+			return "", err
+		}
+		if t != ValueTypeVector {
+			return "", fmt.Errorf("aggregation %q expects vector argument, got %s", n.Op, t)
+		}
+		return ValueTypeVector, nil
+	case *BinaryExpr:
+		lt, err := typeOf(n.LHS)
+		if err != nil {
+			return "", err
+		}
+		rt, err := typeOf(n.RHS)
+		if err != nil {
+			return "", err
+		}
+		if lt == ValueTypeMatrix || rt == ValueTypeMatrix {
+			return "", fmt.Errorf("binary %q is not allowed on range selectors", n.Op)
+		}
+		if (n.Op == "and" || n.Op == "or") && (lt != ValueTypeVector || rt != ValueTypeVector) {
+			return "", fmt.Errorf("set operator %q is only allowed between vectors", n.Op)
+		}
+		if lt == ValueTypeScalar && rt == ValueTypeScalar {
+			return ValueTypeScalar, nil
+		}
+		return ValueTypeVector, nil
+	default:
+		return "", fmt.Errorf("unsupported expression %s", n)
+	}
+}
@@ -0,0 +1,408 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSource struct {
+	series []Series
+}
+
+func (s testSource) Select(matchers []*Matcher) (Vector, error) {
+	var result Vector
+	for _, ser := range s.series {
+		if MatchAll(matchers, ser.Labels) && len(ser.Points) > 0 {
+			result = append(result, Sample{Labels: ser.Labels, Value: ser.Points[len(ser.Points)-1].Value})
+		}
+	}
+
+	return result, nil
+}
+
+func (s testSource) SelectRange(matchers []*Matcher, from time.Time, to time.Time) (Matrix, error) {
+	var result Matrix
+	for _, ser := range s.series {
+		if !MatchAll(matchers, ser.Labels) {
+			continue
+		}
+
+		var points []Point
+		for _, p := range ser.Points {
+			if !p.Time.Before(from) && !p.Time.After(to) {
+				points = append(points, p)
+			}
+		}
+		result = append(result, Series{Labels: ser.Labels, Points: points})
+	}
+
+	return result, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Plain selector",
+			input: "Alloc",
+			want:  `Alloc`,
+		},
+		{
+			name:  "Selector with matchers",
+			input: `Alloc{type="gauge", host=~"web.*"}`,
+			want:  `Alloc{type="gauge",host=~"web.*"}`,
+		},
+		{
+			name:  "Operator precedence",
+			input: "1 + 2 * 3",
+			want:  `1 + 2 * 3`,
+		},
+		{
+			name:  "Function with range selector",
+			input: "rate(PollCount[5m]) > 10",
+			want:  `rate(PollCount[5m]) > 10`,
+		},
+		{
+			name:  "Parentheses and unary minus",
+			input: "-(Alloc - 1)",
+			want:  `-(Alloc - 1)`,
+		},
+		{
+			name:  "Aggregation with grouping before expression",
+			input: `sum by (type) ({type=~"gauge|counter"})`,
+			want:  `sum by (type) ({type=~"gauge|counter"})`,
+		},
+		{
+			name:  "Aggregation with grouping after expression",
+			input: "max(rate(PollCount[1m])) by (type, host)",
+			want:  `max by (type, host) (rate(PollCount[1m]))`,
+		},
+		{
+			name:  "Aggregation without grouping",
+			input: "avg(Alloc)",
+			want:  `avg(Alloc)`,
+		},
+		{
+			name:  "Aggregation name used as metric name",
+			input: "count + 1",
+			want:  `count + 1`,
+		},
+		{
+			name:  "Set operators and comparison precedence",
+			input: "Alloc > 1 and Free < 2 or Total",
+			want:  `Alloc > 1 and Free < 2 or Total`,
+		},
+		{
+			name:  "Exponent number and duration units",
+			input: "rate(PollCount[1h30m]) * 1e3",
+			want:  `rate(PollCount[1h30m]) * 1000`,
+		},
+		{
+			name:  "Day range",
+			input: "avg_over_time(Alloc[1d])",
+			want:  `avg_over_time(Alloc[24h])`,
+		},
+		{
+			name:    "Aggregation of range selector",
+			input:   "sum(PollCount[5m])",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate grouping",
+			input:   "sum by (type) (Alloc) by (type)",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid grouping label",
+			input:   `sum by ("type") (Alloc)`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid range duration",
+			input:   "rate(PollCount[5x])",
+			wantErr: true,
+		},
+		{
+			name:    "Empty selector",
+			input:   "{}",
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated string",
+			input:   `Alloc{type="gauge}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unexpected character",
+			input:   "Alloc # 1",
+			wantErr: true,
+		},
+		{
+			name:    "Range selector outside function",
+			input:   "Alloc[5m]",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown function",
+			input:   "foo(Alloc)",
+			wantErr: true,
+		},
+		{
+			name:    "Wrong argument type",
+			input:   "rate(Alloc)",
+			wantErr: true,
+		},
+		{
+			name:    "Set operator between scalars",
+			input:   "1 and 2",
+			wantErr: true,
+		},
+		{
+			name:    "Unclosed brace",
+			input:   `Alloc{type="gauge"`,
+			wantErr: true,
+		},
+		{
+			name:    "Trailing tokens",
+			input:   "Alloc Alloc",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid regexp",
+			input:   `Alloc{type=~"("}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, e.Root().String())
+		})
+	}
+}
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "Selector with matchers",
+			input: `Alloc{type!~"c.*"}`,
+			want:  []string{"Alloc", "{", "type", "!~", "c.*", "}"},
+		},
+		{
+			name:  "Range and operators",
+			input: "rate(PollCount[ 5m ])>=.5",
+			want:  []string{"rate", "(", "PollCount", "[", "5m", "]", ")", ">=", ".5"},
+		},
+		{
+			name:  "Escaped quote in string",
+			input: `'it\'s'`,
+			want:  []string{"it's"},
+		},
+		{
+			name:    "Unclosed range",
+			input:   "PollCount[5m",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lex(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			got := make([]string, 0, len(tokens))
+			for _, tok := range tokens {
+				if tok.kind != tokenEOF {
+					got = append(got, tok.value)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	ts := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	src := testSource{series: []Series{
+		{
+			Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"},
+			Points: []Point{{Time: ts, Value: 100}},
+		},
+		{
+			Labels: Labels{MetricNameLabel: "Free", TypeLabel: "gauge"},
+			Points: []Point{{Time: ts, Value: 25}},
+		},
+		{
+			Labels: Labels{MetricNameLabel: "PollCount", TypeLabel: "counter"},
+			Points: []Point{
+				{Time: ts.Add(-2 * time.Minute), Value: 10},
+				{Time: ts.Add(-time.Minute), Value: 70},
+				{Time: ts, Value: 20},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name  string
+		input string
+		want  Value
+	}{
+		{
+			name:  "Scalar arithmetic",
+			input: "1 + 2 * 3 - 4 / 2",
+			want:  Scalar(5),
+		},
+		{
+			name:  "Scalar comparison",
+			input: "2 > 1",
+			want:  Scalar(1),
+		},
+		{
+			name:  "Selector by name",
+			input: "Alloc",
+			want:  Vector{{Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"}, Value: 100}},
+		},
+		{
+			name:  "Selector by label",
+			input: `{type="counter"}`,
+			want:  Vector{{Labels: Labels{MetricNameLabel: "PollCount", TypeLabel: "counter"}, Value: 20}},
+		},
+		{
+			name:  "Vector and scalar arithmetic drops name",
+			input: "Alloc * 2",
+			want:  Vector{{Labels: Labels{TypeLabel: "gauge"}, Value: 200}},
+		},
+		{
+			name:  "Comparison filters vector",
+			input: `{type="gauge"} > 50`,
+			want:  Vector{{Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"}, Value: 100}},
+		},
+		{
+			name:  "Comparison without matches",
+			input: "Alloc < 50",
+			want:  Vector{},
+		},
+		{
+			name:  "Vector arithmetic matches by labels",
+			input: "Free / Alloc",
+			want:  Vector{{Labels: Labels{TypeLabel: "gauge"}, Value: 0.25}},
+		},
+		{
+			name:  "Unary minus",
+			input: "-Alloc",
+			want:  Vector{{Labels: Labels{TypeLabel: "gauge"}, Value: -100}},
+		},
+		{
+			name:  "Rate handles counter reset",
+			input: "rate(PollCount[5m])",
+			want:  Vector{{Labels: Labels{TypeLabel: "counter"}, Value: 80.0 / 120}},
+		},
+		{
+			name:  "Increase handles counter reset",
+			input: "increase(PollCount[5m])",
+			want:  Vector{{Labels: Labels{TypeLabel: "counter"}, Value: 80}},
+		},
+		{
+			name:  "Average over time",
+			input: "avg_over_time(PollCount[5m])",
+			want:  Vector{{Labels: Labels{TypeLabel: "counter"}, Value: 100.0 / 3}},
+		},
+		{
+			name:  "Min and max over time",
+			input: "max_over_time(PollCount[90s]) - min_over_time(PollCount[90s])",
+			want:  Vector{{Labels: Labels{TypeLabel: "counter"}, Value: 50}},
+		},
+		{
+			name:  "Sum and count over time",
+			input: "sum_over_time(PollCount[5m]) / count_over_time(PollCount[5m])",
+			want:  Vector{{Labels: Labels{TypeLabel: "counter"}, Value: 100.0 / 3}},
+		},
+		{
+			name:  "Sum by type",
+			input: `sum by (type) ({type=~".+"})`,
+			want: Vector{
+				{Labels: Labels{TypeLabel: "gauge"}, Value: 125},
+				{Labels: Labels{TypeLabel: "counter"}, Value: 20},
+			},
+		},
+		{
+			name:  "Average without grouping",
+			input: `avg({type="gauge"})`,
+			want:  Vector{{Labels: Labels{}, Value: 62.5}},
+		},
+		{
+			name:  "Min and max",
+			input: `max({type="gauge"}) - min({type="gauge"})`,
+			want:  Vector{{Labels: Labels{}, Value: 75}},
+		},
+		{
+			name:  "Count by missing label",
+			input: `count by (host) ({type=~".+"})`,
+			want:  Vector{{Labels: Labels{}, Value: 3}},
+		},
+		{
+			name:  "Aggregation of empty vector",
+			input: "sum(Unknown)",
+			want:  Vector{},
+		},
+		{
+			name:  "Scalar on left side of comparison",
+			input: "50 < Alloc",
+			want:  Vector{{Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"}, Value: 100}},
+		},
+		{
+			name:  "Scalar on left side of arithmetic",
+			input: "200 - Alloc",
+			want:  Vector{{Labels: Labels{TypeLabel: "gauge"}, Value: 100}},
+		},
+		{
+			name:  "Modulo",
+			input: "Alloc % 30",
+			want:  Vector{{Labels: Labels{TypeLabel: "gauge"}, Value: 10}},
+		},
+		{
+			name:  "And keeps matching left samples",
+			input: `Alloc and {type="gauge"}`,
+			want:  Vector{{Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"}, Value: 100}},
+		},
+		{
+			name:  "Or adds missing right samples",
+			input: `Alloc or PollCount`,
+			want: Vector{
+				{Labels: Labels{MetricNameLabel: "Alloc", TypeLabel: "gauge"}, Value: 100},
+				{Labels: Labels{MetricNameLabel: "PollCount", TypeLabel: "counter"}, Value: 20},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MustParse(tt.input).Eval(src, ts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := MustParse(`Alloc + {type="gauge"}`).Eval(src, ts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package expr
+
+import (
+	"math"
+	"time"
+)
+
+type function struct {
+	args   []ValueType
+	result ValueType
+	call   func(args []Value, ts time.Time) (Value, error)
+}
+
+var functions = map[string]function{
+	"abs": {
+		args:   []ValueType{ValueTypeVector},
+		result: ValueTypeVector,
+		call: func(args []Value, _ time.Time) (Value, error) {
+			return mapVector(args[0].(Vector), math.Abs), nil
+		},
+	},
+	"rate": {
+		args:   []ValueType{ValueTypeMatrix},
+		result: ValueTypeVector,
+		call: func(args []Value, _ time.Time) (Value, error) {
+			return reduceMatrix(args[0].(Matrix), rate), nil
+		},
+	},
+	"increase":        overTime(increase),
+	"avg_over_time":   overTime(avgOverTime),
+	"min_over_time":   overTime(minOverTime),
+	"max_over_time":   overTime(maxOverTime),
+	"sum_over_time":   overTime(sumOverTime),
+	"count_over_time": overTime(countOverTime),
+}
+
+func overTime(fn func(points []Point) (float64, bool)) function {
+	return function{
+		args:   []ValueType{ValueTypeMatrix},
+		result: ValueTypeVector,
+		call: func(args []Value, _ time.Time) (Value, error) {
+			return reduceMatrix(args[0].(Matrix), fn), nil
+		},
+	}
+}
+
+func mapVector(v Vector, fn func(float64) float64) Vector {
+	result := make(Vector, 0, len(v))
+	for _, s := range v {
+		result = append(result, Sample{Labels: s.Labels.WithoutName(), Value: fn(s.Value)})
+	}
+
+	return result
+}
+
+func reduceMatrix(m Matrix, fn func(points []Point) (float64, bool)) Vector {
+	result := make(Vector, 0, len(m))
+	for _, s := range m {
+		if v, ok := fn(s.Points); ok {
+			result = append(result, Sample{Labels: s.Labels.WithoutName(), Value: v})
+		}
+	}
+
+	return result
+}
+
+func increase(points []Point) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+
+	var total float64
+	for i := 1; i < len(points); i++ {
+		if points[i].Value >= points[i-1].Value {
+			total += points[i].Value - points[i-1].Value
+		} else {
+			total += points[i].Value
+		}
+	}
+
+	return total, true
+}
+
+func rate(points []Point) (float64, bool) {
+	total, ok := increase(points)
+	if !ok {
+		return 0, false
+	}
+
+	elapsed := points[len(points)-1].Time.Sub(points[0].Time).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	return total / elapsed, true
+}
+
+func sumOverTime(points []Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	var sum float64
+	for _, p := range points {
+		sum += p.Value
+	}
+
+	return sum, true
+}
+
+func avgOverTime(points []Point) (float64, bool) {
+	sum, ok := sumOverTime(points)
+	if !ok {
+		return 0, false
+	}
+
+	return sum / float64(len(points)), true
+}
+
+func minOverTime(points []Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	v := points[0].Value
+	for _, p := range points[1:] {
+		if p.Value < v {
+			v = p.Value
+		}
+	}
+
+	return v, true
+}
+
+func maxOverTime(points []Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	v := points[0].Value
+	for _, p := range points[1:] {
+		if p.Value > v {
+			v = p.Value
+		}
+	}
+
+	return v, true
+}
+
+func countOverTime(points []Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+
+	return float64(len(points)), true
+}
@@ -0,0 +1,114 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	MetricNameLabel = "__name__"
+	TypeLabel       = "type"
+)
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+type Labels map[string]string
+
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewMatcher(name string, t MatchType, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("invalid match type %q", t)
+	}
+
+	return m, nil
+}
+
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	default:
+		return false
+	}
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+func MatchAll(ms []*Matcher, l Labels) bool {
+	for _, m := range ms {
+		if !m.Matches(l[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l Labels) Name() string {
+	return l[MetricNameLabel]
+}
+
+func (l Labels) WithoutName() Labels {
+	result := make(Labels, len(l))
+	for k, v := range l {
+		if k != MetricNameLabel {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		if k != MetricNameLabel {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, l[k]))
+	}
+
+	return l.Name() + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (l Labels) signature() string {
+	return l.WithoutName().String()
+}
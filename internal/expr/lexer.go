@@ -0,0 +1,165 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenDuration
+	tokenIdent
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBracket
+	tokenRightBracket
+	tokenLeftBrace
+	tokenRightBrace
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", ">", "<", "="}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, value: ")", pos: i})
+			i++
+		case c == '[':
+			end := strings.IndexByte(input[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed range at position %d", i)
+			}
+			tokens = append(tokens,
+				token{kind: tokenLeftBracket, value: "[", pos: i},
+				token{kind: tokenDuration, value: strings.TrimSpace(input[i+1 : i+end]), pos: i + 1},
+				token{kind: tokenRightBracket, value: "]", pos: i + end},
+			)
+			i += end + 1
+		case c == '{':
+			tokens = append(tokens, token{kind: tokenLeftBrace, value: "{", pos: i})
+			i++
+		case c == '}':
+			tokens = append(tokens, token{kind: tokenRightBrace, value: "}", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, value: ",", pos: i})
+			i++
+		case c == '"' || c == '\'':
+			s, n, err := lexString(input[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%s at position %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: s, pos: i})
+			i += n
+		case isDigit(c) || (c == '.' && i+1 < len(input) && isDigit(rune(input[i+1]))):
+			n := lexNumber(input[i:])
+			tokens = append(tokens, token{kind: tokenNumber, value: input[i : i+n], pos: i})
+			i += n
+		case isIdentStart(c):
+			n := 1
+			for i+n < len(input) && isIdentChar(rune(input[i+n])) {
+				n++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: input[i : i+n], pos: i})
+			i += n
+		default:
+			op := matchOperator(input[i:])
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, value: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(input)}), nil
+}
+
+func lexString(input string) (string, int, error) {
+	quote := input[0]
+
+	var b strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 >= len(input) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			b.WriteByte(input[i])
+		case quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(input[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func lexNumber(input string) int {
+	n := 0
+	for n < len(input) && (isDigit(rune(input[n])) || input[n] == '.') {
+		n++
+	}
+
+	if n < len(input) && (input[n] == 'e' || input[n] == 'E') {
+		m := n + 1
+		if m < len(input) && (input[m] == '+' || input[m] == '-') {
+			m++
+		}
+		if m < len(input) && isDigit(rune(input[m])) {
+			for m < len(input) && isDigit(rune(input[m])) {
+				m++
+			}
+			n = m
+		}
+	}
+
+	return n
+}
+
+func matchOperator(input string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(input, op) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c rune) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c rune) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,368 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type Expr struct {
+	root  Node
+	input string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+var precedence = map[string]int{
+	"or":  1,
+	"and": 2,
+	"==":  3,
+	"!=":  3,
+	">":   3,
+	"<":   3,
+	">=":  3,
+	"<=":  3,
+	"+":   4,
+	"-":   4,
+	"*":   5,
+	"/":   5,
+	"%":   5,
+}
+
+func Parse(input string) (*Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.value, t.pos)
+	}
+
+	if err := checkTypes(root); err != nil {
+		return nil, err
+	}
+
+	return &Expr{root: root, input: input}, nil
+}
+
+func MustParse(input string) *Expr {
+	e, err := Parse(input)
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.input
+}
+
+func (e *Expr) Root() Node {
+	return e.root
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s, got %q at position %d", what, t.value, t.pos)
+	}
+
+	return t, nil
+}
+
+func (p *parser) parseExpr(minPrecedence int) (Node, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.binaryOperator()
+		if !ok || precedence[op] <= minPrecedence {
+			return lhs, nil
+		}
+		p.next()
+
+		rhs, err := p.parseExpr(precedence[op])
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *parser) binaryOperator() (string, bool) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenOperator:
+		_, ok := precedence[t.value]
+		return t.value, ok
+	case t.kind == tokenIdent && (t.value == "and" || t.value == "or"):
+		return t.value, true
+	default:
+		return "", false
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if t := p.peek(); t.kind == tokenOperator && (t.value == "-" || t.value == "+") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if t.value == "+" {
+			return e, nil
+		}
+		if n, ok := e.(*NumberLiteral); ok {
+			return &NumberLiteral{Value: -n.Value}, nil
+		}
+		return &UnaryExpr{Op: "-", Expr: e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		v, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.value, t.pos)
+		}
+		return &NumberLiteral{Value: v}, nil
+	case tokenLeftParen:
+		e, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: e}, nil
+	case tokenIdent:
+		if _, ok := aggregations[t.value]; ok && (p.peek().kind == tokenLeftParen || p.peek().value == "by") {
+			return p.parseAggregate(t)
+		}
+		if p.peek().kind == tokenLeftParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t)
+	case tokenLeftBrace:
+		p.pos--
+		return p.parseSelector(token{})
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", t.value, t.pos)
+	}
+}
+
+func (p *parser) parseCall(name token) (Node, error) {
+	if _, ok := functions[name.value]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.value, name.pos)
+	}
+	p.next()
+
+	call := &Call{Func: name.value}
+	if p.peek().kind == tokenRightParen {
+		p.next()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		t := p.next()
+		switch t.kind {
+		case tokenComma:
+			continue
+		case tokenRightParen:
+			return call, nil
+		default:
+			return nil, fmt.Errorf("expected \",\" or \")\", got %q at position %d", t.value, t.pos)
+		}
+	}
+}
+
+func (p *parser) parseSelector(name token) (Node, error) {
+	vs := &VectorSelector{Name: name.value}
+	if name.value != "" {
+		m, err := NewMatcher(MetricNameLabel, MatchEqual, name.value)
+		if err != nil {
+			return nil, err
+		}
+		vs.Matchers = append(vs.Matchers, m)
+	}
+
+	if p.peek().kind == tokenLeftBrace {
+		p.next()
+		ms, err := p.parseMatchers()
+		if err != nil {
+			return nil, err
+		}
+		vs.Matchers = append(vs.Matchers, ms...)
+	}
+
+	if len(vs.Matchers) == 0 {
+		return nil, fmt.Errorf("selector must contain a metric name or at least one matcher at position %d", p.peek().pos)
+	}
+
+	if p.peek().kind != tokenLeftBracket {
+		return vs, nil
+	}
+	p.next()
+
+	d, err := p.expect(tokenDuration, "range duration")
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseDuration(d.value)
+	if err != nil {
+		return nil, fmt.Errorf("%s at position %d", err, d.pos)
+	}
+	if r <= 0 {
+		return nil, fmt.Errorf("range must be positive at position %d", d.pos)
+	}
+	if _, err := p.expect(tokenRightBracket, "\"]\""); err != nil {
+		return nil, err
+	}
+
+	return &MatrixSelector{Vector: vs, Range: r}, nil
+}
+
+func (p *parser) parseMatchers() ([]*Matcher, error) {
+	var ms []*Matcher
+	for {
+		if p.peek().kind == tokenRightBrace {
+			p.next()
+			return ms, nil
+		}
+
+		name, err := p.expect(tokenIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		op, err := p.expect(tokenOperator, "label match operator")
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.expect(tokenString, "label value")
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := NewMatcher(name.value, MatchType(op.value), value.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher at position %d: %s", name.pos, err)
+		}
+		ms = append(ms, m)
+
+		t := p.next()
+		switch t.kind {
+		case tokenComma:
+			continue
+		case tokenRightBrace:
+			return ms, nil
+		default:
+			return nil, fmt.Errorf("expected \",\" or \"}\", got %q at position %d", t.value, t.pos)
+		}
+	}
+}
+
+func (p *parser) parseAggregate(op token) (Node, error) {
+	agg := &AggregateExpr{Op: op.value}
+
+	if p.peek().kind == tokenIdent && p.peek().value == "by" {
+		p.next()
+		grouping, err := p.parseGrouping()
+		if err != nil {
+			return nil, err
+		}
+		agg.Grouping = grouping
+	}
+
+	if _, err := p.expect(tokenLeftParen, "\"(\""); err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRightParen, "\")\""); err != nil {
+		return nil, err
+	}
+	agg.Expr = e
+
+	if p.peek().kind == tokenIdent && p.peek().value == "by" {
+		if agg.Grouping != nil {
+			return nil, fmt.Errorf("duplicate grouping for %q at position %d", op.value, p.peek().pos)
+		}
+		p.next()
+		grouping, err := p.parseGrouping()
+		if err != nil {
+			return nil, err
+		}
+		agg.Grouping = grouping
+	}
+
+	return agg, nil
+}
+
+func (p *parser) parseGrouping() ([]string, error) {
+	if _, err := p.expect(tokenLeftParen, "\"(\""); err != nil {
+		return nil, err
+	}
+
+	grouping := []string{}
+	if p.peek().kind == tokenRightParen {
+		p.next()
+		return grouping, nil
+	}
+
+	for {
+		l, err := p.expect(tokenIdent, "label name")
+		if err != nil {
+			return nil, err
+		}
+		grouping = append(grouping, l.value)
+
+		t := p.next()
+		switch t.kind {
+		case tokenComma:
+			continue
+		case tokenRightParen:
+			return grouping, nil
+		default:
+			return nil, fmt.Errorf("expected \",\" or \")\", got %q at position %d", t.value, t.pos)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package expr
+
+import (
+	"time"
+)
+
+type ValueType string
+
+const (
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+	ValueTypeMatrix ValueType = "matrix"
+)
+
+type Value interface {
+	Type() ValueType
+}
+
+type Scalar float64
+
+type Sample struct {
+	Labels Labels  `json:"labels"`
+	Value  float64 `json:"value"`
+}
+
+type Vector []Sample
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type Series struct {
+	Labels Labels  `json:"labels"`
+	Points []Point `json:"points"`
+}
+
+type Matrix []Series
+
+type Source interface {
+	Select(matchers []*Matcher) (Vector, error)
+	SelectRange(matchers []*Matcher, from time.Time, to time.Time) (Matrix, error)
+}
+
+func (Scalar) Type() ValueType {
+	return ValueTypeScalar
+}
+
+func (Vector) Type() ValueType {
+	return ValueTypeVector
+}
+
+func (Matrix) Type() ValueType {
+	return ValueTypeMatrix
+}
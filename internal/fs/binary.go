@@ -0,0 +1,355 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const (
+	compressionNone byte = iota
+	compressionGzip
+)
+
+const (
+	recordCounter byte = 1 << iota
+	recordGauge
+	recordUnit
+	recordHelp
+	recordOwner
+)
+
+// maxRecordSize bounds the allocation for a record whose length is corrupt.
+const maxRecordSize = 1 << 20
+
+var binaryMagic = []byte("SMSB")
+
+type binaryDecoder struct {
+	body *bufio.Reader
+	prev string
+}
+
+// byteCounter counts the bytes of the header read so far.
+type byteCounter struct {
+	r *bufio.Reader
+	n int64
+}
+
+// The binary layout is: magic, uvarint version, uvarint count, varint unix nano
+// timestamp, compression byte, stored body, sha256 of the stored body. The body
+// is a sequence of uvarint length-prefixed records sorted by name, each name
+// stored as the length of the prefix shared with the previous one plus the
+// suffix. Version 1 had the checksum in front of the body.
+func encodeBinary(w io.Writer, header SnapshotHeader, metrics []metric.Metrics, compress bool) error {
+	sorted := make([]metric.Metrics, len(metrics))
+	copy(sorted, metrics)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	compression := compressionNone
+	if compress {
+		compression = compressionGzip
+	}
+
+	head := append([]byte{}, binaryMagic...)
+	head = binary.AppendUvarint(head, uint64(header.Version))
+	head = binary.AppendUvarint(head, uint64(header.Count))
+	head = binary.AppendVarint(head, header.Timestamp.UnixNano())
+	head = append(head, compression)
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	body := io.MultiWriter(w, sum)
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(body)
+		body = zw
+	}
+
+	var (
+		record []byte
+		prev   string
+	)
+	for _, m := range sorted {
+		var err error
+		record, err = appendRecord(record[:0], prev, m)
+		if err != nil {
+			return err
+		}
+		if _, err := body.Write(binary.AppendUvarint(nil, uint64(len(record)))); err != nil {
+			return err
+		}
+		if _, err := body.Write(record); err != nil {
+			return err
+		}
+		prev = m.ID
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(sum.Sum(nil))
+
+	return err
+}
+
+func decodeBinary(file *os.File, size int64) (SnapshotHeader, metricDecoder, error) {
+	var header SnapshotHeader
+
+	r := &byteCounter{r: bufio.NewReader(io.NewSectionReader(file, 0, size))}
+	if _, err := r.Discard(len(binaryMagic)); err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+	if version != 1 && version != SnapshotVersion {
+		return header, nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+	ts, err := binary.ReadVarint(r)
+	if err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+	compression, err := r.ReadByte()
+	if err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+
+	sum := make([]byte, sha256.Size)
+	start, end := r.n, size-sha256.Size
+	if version == 1 {
+		if _, err := io.ReadFull(r, sum); err != nil {
+			return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+		}
+		start, end = r.n, size
+	} else {
+		if end < start {
+			return header, nil, fmt.Errorf("%w: missing checksum", ErrCorruptSnapshot)
+		}
+		if _, err := file.ReadAt(sum, end); err != nil {
+			return header, nil, err
+		}
+	}
+
+	header = SnapshotHeader{
+		Version:   int(version),
+		Count:     int(count),
+		Timestamp: time.Unix(0, ts).UTC(),
+		Checksum:  hex.EncodeToString(sum),
+	}
+
+	stored := io.NewSectionReader(file, start, end-start)
+	if err := verifyChecksum(stored, header.Checksum); err != nil {
+		return header, nil, err
+	}
+
+	var body io.Reader = stored
+	switch compression {
+	case compressionNone:
+	case compressionGzip:
+		if body, err = gzip.NewReader(bufio.NewReader(stored)); err != nil {
+			return header, nil, err
+		}
+	default:
+		return header, nil, fmt.Errorf("unsupported snapshot compression %d", compression)
+	}
+
+	return header, &binaryDecoder{body: bufio.NewReader(body)}, nil
+}
+
+func appendRecord(b []byte, prev string, m metric.Metrics) ([]byte, error) {
+	shared := 0
+	for shared < len(prev) && shared < len(m.ID) && prev[shared] == m.ID[shared] {
+		shared++
+	}
+	b = binary.AppendUvarint(b, uint64(shared))
+	b = appendString(b, m.ID[shared:])
+
+	var flags byte
+	switch {
+	case m.MType == metric.CounterType && m.Delta != nil:
+		flags = recordCounter
+	case m.MType == metric.GaugeType && m.Value != nil:
+		flags = recordGauge
+	default:
+		return nil, fmt.Errorf("invalid metric '%s' of type '%s'", m.ID, m.MType)
+	}
+	if m.Unit != "" {
+		flags |= recordUnit
+	}
+	if m.Help != "" {
+		flags |= recordHelp
+	}
+	if m.Owner != "" {
+		flags |= recordOwner
+	}
+	b = append(b, flags)
+
+	if flags&recordCounter != 0 {
+		b = binary.AppendVarint(b, *m.Delta)
+	} else {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(*m.Value))
+	}
+
+	if m.Unit != "" {
+		b = appendString(b, m.Unit)
+	}
+	if m.Help != "" {
+		b = appendString(b, m.Help)
+	}
+	if m.Owner != "" {
+		b = appendString(b, m.Owner)
+	}
+
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func (d *binaryDecoder) decode() (metric.Metrics, error) {
+	size, err := binary.ReadUvarint(d.body)
+	if err == io.EOF {
+		return metric.Metrics{}, io.EOF
+	}
+	if err != nil || size > maxRecordSize {
+		return metric.Metrics{}, fmt.Errorf("%w: invalid record length", ErrCorruptSnapshot)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(d.body, record); err != nil {
+		return metric.Metrics{}, fmt.Errorf("%w: %s", ErrCorruptSnapshot, err)
+	}
+
+	m, err := d.decodeRecord(bytes.NewReader(record))
+	if err != nil {
+		return metric.Metrics{}, fmt.Errorf("%w: %s", ErrCorruptSnapshot, err)
+	}
+	d.prev = m.ID
+
+	return m, nil
+}
+
+func (d *binaryDecoder) decodeRecord(r *bytes.Reader) (metric.Metrics, error) {
+	m := metric.Metrics{}
+
+	shared, err := binary.ReadUvarint(r)
+	if err != nil {
+		return m, err
+	}
+	if shared > uint64(len(d.prev)) {
+		return m, fmt.Errorf("invalid name prefix length %d", shared)
+	}
+	suffix, err := readString(r)
+	if err != nil {
+		return m, err
+	}
+	m.ID = d.prev[:shared] + suffix
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return m, err
+	}
+
+	switch {
+	case flags&recordCounter != 0:
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return m, err
+		}
+		m.MType = metric.CounterType
+		m.Delta = &delta
+	case flags&recordGauge != 0:
+		var bits [8]byte
+		if _, err := io.ReadFull(r, bits[:]); err != nil {
+			return m, err
+		}
+		value := math.Float64frombits(binary.LittleEndian.Uint64(bits[:]))
+		m.MType = metric.GaugeType
+		m.Value = &value
+	default:
+		return m, fmt.Errorf("unknown record type %d", flags)
+	}
+
+	for _, f := range []struct {
+		flag byte
+		dst  *string
+	}{
+		{recordUnit, &m.Unit},
+		{recordHelp, &m.Help},
+		{recordOwner, &m.Owner},
+	} {
+		if flags&f.flag == 0 {
+			continue
+		}
+		if *f.dst, err = readString(r); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", fmt.Errorf("invalid string length %d", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+func (c *byteCounter) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+
+	return b, err
+}
+
+func (c *byteCounter) Discard(n int) (int, error) {
+	n, err := c.r.Discard(n)
+	c.n += int64(n)
+
+	return n, err
+}
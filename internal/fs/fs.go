@@ -1,64 +1,427 @@
 package fs
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 )
 
+// SnapshotVersion 2 moved the checksum behind the metrics, so snapshots are
+// written in one pass. Version 1 snapshots are still read.
+const SnapshotVersion = 2
+
+type Format string
+
+const (
+	FormatJSON       Format = "json"
+	FormatBinary     Format = "binary"
+	FormatBinaryGzip Format = "binary+gzip"
+)
+
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+var jsonTrailerSize = int64(len(`{"checksum":""}`) + hex.EncodedLen(sha256.Size) + 1)
+
+type SnapshotHeader struct {
+	Version   int       `json:"version"`
+	Count     int       `json:"count"`
+	Timestamp time.Time `json:"timestamp"`
+	Checksum  string    `json:"checksum,omitempty"`
+}
+
+type jsonTrailer struct {
+	Checksum string `json:"checksum"`
+}
+
+type SnapshotOptions struct {
+	Keep   int
+	Format Format
+}
+
 type MetricWriter struct {
-	file    *os.File
-	encoder *json.Encoder
+	path    string
+	opts    SnapshotOptions
+	metrics []metric.Metrics
 }
 
 type MetricReader struct {
+	header  SnapshotHeader
+	count   int
+	decoder metricDecoder
 	file    *os.File
-	decoder *json.Decoder
 }
 
-func NewMetricWriter(filepath string) (*MetricWriter, error) {
-	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0664)
-	if err != nil {
+type metricDecoder interface {
+	decode() (metric.Metrics, error)
+}
+
+type jsonDecoder struct {
+	*json.Decoder
+}
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "", FormatJSON, FormatBinary, FormatBinaryGzip:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown snapshot format %q", s)
+	}
+}
+
+func FormatFromPath(path string) Format {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return FormatBinaryGzip
+	case strings.HasSuffix(path, ".bin"):
+		return FormatBinary
+	default:
+		return FormatJSON
+	}
+}
+
+func NewMetricWriter(filepath string, opts SnapshotOptions) (*MetricWriter, error) {
+	if filepath == "" {
+		return nil, fmt.Errorf("snapshot path is empty")
+	}
+
+	if opts.Format == "" {
+		opts.Format = FormatFromPath(filepath)
+	}
+	if _, err := ParseFormat(string(opts.Format)); err != nil {
 		return nil, err
 	}
 
 	return &MetricWriter{
-		file:    file,
-		encoder: json.NewEncoder(file),
+		path: filepath,
+		opts: opts,
 	}, nil
 }
 
 func (mw *MetricWriter) Write(m metric.Metrics) error {
-	return mw.encoder.Encode(m)
+	mw.metrics = append(mw.metrics, m)
+
+	return nil
 }
 
 func (mw *MetricWriter) Close() error {
-	return mw.file.Close()
+	header := SnapshotHeader{
+		Version:   SnapshotVersion,
+		Count:     len(mw.metrics),
+		Timestamp: time.Now().UTC(),
+	}
+	encode := func(w io.Writer) error {
+		if mw.opts.Format == FormatJSON {
+			return encodeJSON(w, header, mw.metrics)
+		}
+		return encodeBinary(w, header, mw.metrics, mw.opts.Format == FormatBinaryGzip)
+	}
+
+	return replaceFile(mw.path, encode, func() error {
+		return rotateSnapshots(mw.path, mw.opts.Keep)
+	})
 }
 
+// NewMetricReader checks the snapshot at filepath against its checksum and
+// opens it for reading. The metrics are streamed from the file, so the reader
+// must be closed.
 func NewMetricReader(filepath string) (*MetricReader, error) {
-	file, err := os.OpenFile(filepath, os.O_RDONLY|os.O_CREATE, 0664)
+	file, err := os.Open(filepath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &MetricReader{decoder: jsonDecoder{json.NewDecoder(bytes.NewReader(nil))}, count: -1}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &MetricReader{
-		file:    file,
-		decoder: json.NewDecoder(file),
-	}, nil
+	mr, err := readSnapshot(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w in %s", err, filepath)
+	}
+
+	return mr, nil
+}
+
+func readSnapshot(file *os.File) (*MetricReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	mr := &MetricReader{file: file}
+	magic := make([]byte, len(binaryMagic))
+	n, _ := file.ReadAt(magic, 0)
+	switch {
+	case info.Size() == 0:
+		mr.decoder = jsonDecoder{json.NewDecoder(bytes.NewReader(nil))}
+		mr.count = -1
+	case n == len(magic) && bytes.Equal(magic, binaryMagic):
+		mr.header, mr.decoder, err = decodeBinary(file, info.Size())
+	default:
+		mr.header, mr.decoder, err = decodeJSON(file, info.Size())
+		if mr.header.Version == 0 {
+			mr.count = -1
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return mr, nil
+}
+
+// OpenSnapshot opens path or, if it fails verification, the newest of its
+// keep rotated copies that passes. It returns the path actually opened.
+func OpenSnapshot(path string, keep int) (*MetricReader, string, error) {
+	var firstErr error
+	for i, p := range snapshotPaths(path, keep) {
+		if i > 0 {
+			if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+
+		mr, err := NewMetricReader(p)
+		if err == nil {
+			return mr, p, nil
+		}
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			return nil, "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, "", firstErr
+}
+
+func (mr *MetricReader) Header() SnapshotHeader {
+	return mr.header
 }
 
 func (mr *MetricReader) Read() (metric.Metrics, error) {
-	m := metric.Metrics{}
-	err := mr.decoder.Decode(&m)
+	m, err := mr.decoder.decode()
+	if err == io.EOF && mr.count >= 0 && mr.count != mr.header.Count {
+		return metric.Metrics{}, fmt.Errorf("%w: expected %d metrics, got %d", ErrCorruptSnapshot, mr.header.Count, mr.count)
+	}
 	if err != nil {
 		return metric.Metrics{}, err
 	}
 
+	if mr.count >= 0 {
+		mr.count++
+	}
+
 	return m, nil
 }
 
 func (mr *MetricReader) Close() error {
+	if mr.file == nil {
+		return nil
+	}
+
 	return mr.file.Close()
 }
+
+// encodeJSON writes the header line, one line per metric and a trailer line
+// with the checksum of the metric lines.
+func encodeJSON(w io.Writer, header SnapshotHeader, metrics []metric.Metrics) error {
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	encoder := json.NewEncoder(io.MultiWriter(w, sum))
+	for _, m := range metrics {
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+
+	trailer, err := json.Marshal(jsonTrailer{Checksum: hex.EncodeToString(sum.Sum(nil))})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(trailer, '\n'))
+
+	return err
+}
+
+func decodeJSON(file *os.File, size int64) (SnapshotHeader, metricDecoder, error) {
+	var header SnapshotHeader
+
+	line, err := bufio.NewReader(io.NewSectionReader(file, 0, size)).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return header, nil, err
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, nil, fmt.Errorf("%w: invalid header: %s", ErrCorruptSnapshot, err)
+	}
+
+	start, end := int64(len(line)), size
+	switch header.Version {
+	case 0:
+		// Snapshots written before headers were introduced start with a metric.
+		return header, jsonDecoder{json.NewDecoder(bufio.NewReader(io.NewSectionReader(file, 0, size)))}, nil
+	case 1:
+	case SnapshotVersion:
+		end -= jsonTrailerSize
+		if end < start {
+			return header, nil, fmt.Errorf("%w: missing checksum", ErrCorruptSnapshot)
+		}
+
+		var trailer jsonTrailer
+		b := make([]byte, jsonTrailerSize)
+		if _, err := file.ReadAt(b, end); err != nil {
+			return header, nil, err
+		}
+		if err := json.Unmarshal(b, &trailer); err != nil {
+			return header, nil, fmt.Errorf("%w: invalid checksum: %s", ErrCorruptSnapshot, err)
+		}
+		header.Checksum = trailer.Checksum
+	default:
+		return header, nil, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	body := io.NewSectionReader(file, start, end-start)
+	if err := verifyChecksum(body, header.Checksum); err != nil {
+		return header, nil, err
+	}
+
+	return header, jsonDecoder{json.NewDecoder(bufio.NewReader(body))}, nil
+}
+
+// verifyChecksum hashes body and rewinds it for decoding.
+func verifyChecksum(body *io.SectionReader, checksum string) error {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, body); err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != checksum {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+
+	_, err := body.Seek(0, io.SeekStart)
+
+	return err
+}
+
+func (d jsonDecoder) decode() (metric.Metrics, error) {
+	m := metric.Metrics{}
+	if err := d.Decode(&m); err != nil {
+		return metric.Metrics{}, err
+	}
+
+	return m, nil
+}
+
+func snapshotPaths(path string, keep int) []string {
+	paths := []string{path}
+	for i := 1; i <= keep; i++ {
+		paths = append(paths, fmt.Sprintf("%s.%d", path, i))
+	}
+
+	return paths
+}
+
+// replaceFile writes a synced temp file and renames it over path, so a crash
+// leaves either the old or the new content. beforeRename, if set, runs once
+// the new content is safely on disk.
+func replaceFile(path string, write func(w io.Writer) error, beforeRename func() error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(tmp, write); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func writeSnapshot(file *os.File, write func(w io.Writer) error) error {
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func rotateSnapshots(path string, keep int) error {
+	paths := snapshotPaths(path, keep)
+	for i := len(paths) - 1; i > 1; i-- {
+		err := os.Rename(paths[i-1], paths[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if keep == 0 {
+		return nil
+	}
+
+	// The current snapshot stays in place until it is atomically replaced.
+	if err := os.Remove(paths[1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, paths[1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
@@ -1,8 +1,14 @@
 package fs
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,12 +35,11 @@ func TestMetricReaderWriter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			mw, wErr := NewMetricWriter(tt.path)
+			mw, wErr := NewMetricWriter(tt.path, SnapshotOptions{})
 			require.Nil(t, wErr)
-			defer mw.Close()
-
 			wErr = mw.Write(tt.metric)
 			require.Nil(t, wErr)
+			require.Nil(t, mw.Close())
 
 			assert.FileExists(t, tt.path)
 
@@ -52,3 +57,365 @@ func TestMetricReaderWriter(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshotIntegrity(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		wantErr error
+	}{
+		{
+			name:    "Valid snapshot",
+			corrupt: func(data []byte) []byte { return data },
+		},
+		{
+			name:    "Truncated snapshot",
+			corrupt: func(data []byte) []byte { return data[:len(data)-10] },
+			wantErr: ErrCorruptSnapshot,
+		},
+		{
+			name: "Flipped byte",
+			corrupt: func(data []byte) []byte {
+				data[len(data)-5] ^= 0xff
+				return data
+			},
+			wantErr: ErrCorruptSnapshot,
+		},
+		{
+			name: "Count mismatch",
+			corrupt: func(data []byte) []byte {
+				return bytes.Replace(data, []byte(`"count":2`), []byte(`"count":3`), 1)
+			},
+			wantErr: ErrCorruptSnapshot,
+		},
+		{
+			name:    "Broken header",
+			corrupt: func(data []byte) []byte { return data[5:] },
+			wantErr: ErrCorruptSnapshot,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			writeSnapshotFile(t, path, SnapshotOptions{}, 1, 2)
+
+			data, err := os.ReadFile(path)
+			require.Nil(t, err)
+			require.Nil(t, os.WriteFile(path, tt.corrupt(data), 0664))
+
+			got, err := readSnapshotFile(path)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, []int64{1, 2}, got)
+		})
+	}
+}
+
+func TestSnapshotRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+
+	for i := int64(1); i <= 4; i++ {
+		writeSnapshotFile(t, path, SnapshotOptions{Keep: 2}, i)
+	}
+
+	for p, want := range map[string]int64{path: 4, path + ".1": 3, path + ".2": 2} {
+		got, err := readSnapshotFile(p)
+		require.Nil(t, err)
+		assert.Equal(t, []int64{want}, got, p)
+	}
+	assert.NoFileExists(t, path+".3")
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestOpenSnapshotFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt []string
+		remove  []string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Primary snapshot",
+			want: "",
+		},
+		{
+			name:    "Corrupt primary snapshot",
+			corrupt: []string{""},
+			want:    ".1",
+		},
+		{
+			name:    "Corrupt primary and first rotated snapshot",
+			corrupt: []string{"", ".1"},
+			want:    ".2",
+		},
+		{
+			name:    "Missing rotated snapshot is skipped",
+			corrupt: []string{""},
+			remove:  []string{".1"},
+			want:    ".2",
+		},
+		{
+			name:    "No valid snapshot",
+			corrupt: []string{"", ".1", ".2"},
+			wantErr: ErrCorruptSnapshot,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			for i := int64(1); i <= 3; i++ {
+				writeSnapshotFile(t, path, SnapshotOptions{Keep: 2}, i)
+			}
+			for _, suffix := range tt.corrupt {
+				require.Nil(t, os.WriteFile(path+suffix, []byte("{broken\n"), 0664))
+			}
+			for _, suffix := range tt.remove {
+				require.Nil(t, os.Remove(path+suffix))
+			}
+
+			mr, opened, err := OpenSnapshot(path, 2)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, path+tt.want, opened)
+			assert.Equal(t, 1, mr.Header().Count)
+		})
+	}
+}
+
+func TestLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.Nil(t, os.WriteFile(path, []byte(`{"id":"PollCount","type":"counter","delta":7}`+"\n"), 0664))
+
+	got, err := readSnapshotFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{7}, got)
+
+	got, err = readSnapshotFile(filepath.Join(t.TempDir(), "missing.json"))
+	require.Nil(t, err)
+	assert.Empty(t, got)
+
+	// Version 1 had the checksum in front of the metrics.
+	body := []byte(`{"id":"PollCount","type":"counter","delta":3}` + "\n")
+	sum := sha256.Sum256(body)
+	header := fmt.Sprintf(`{"version":1,"count":1,"timestamp":"2023-04-01T12:00:00Z","checksum":"%x"}`+"\n", sum)
+	require.Nil(t, os.WriteFile(path, append([]byte(header), body...), 0664))
+
+	got, err = readSnapshotFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{3}, got)
+
+	delta := int64(4)
+	record, err := appendRecord(nil, "", metric.Metrics{ID: "PollCount", MType: metric.CounterType, Delta: &delta})
+	require.Nil(t, err)
+	stored := append(binary.AppendUvarint(nil, uint64(len(record))), record...)
+	sum = sha256.Sum256(stored)
+	data := append([]byte{}, binaryMagic...)
+	data = binary.AppendUvarint(data, 1)
+	data = binary.AppendUvarint(data, 1)
+	data = binary.AppendVarint(data, 0)
+	data = append(data, compressionNone)
+	data = append(append(data, sum[:]...), stored...)
+	path = filepath.Join(t.TempDir(), "metrics.bin")
+	require.Nil(t, os.WriteFile(path, data, 0664))
+
+	got, err = readSnapshotFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, []int64{4}, got)
+}
+
+func writeSnapshotFile(t *testing.T, path string, opts SnapshotOptions, deltas ...int64) {
+	mw, err := NewMetricWriter(path, opts)
+	require.Nil(t, err)
+
+	for _, d := range deltas {
+		d := d
+		m, err := metric.NewMetrics("PollCount", metric.CounterType, &d, nil)
+		require.Nil(t, err)
+		require.Nil(t, mw.Write(m))
+	}
+
+	require.Nil(t, mw.Close())
+}
+
+func readSnapshotFile(path string) ([]int64, error) {
+	mr, err := NewMetricReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer mr.Close()
+
+	var deltas []int64
+	for {
+		m, err := mr.Read()
+		if err == io.EOF {
+			return deltas, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, *m.Delta)
+	}
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	metrics := testMetrics(50)
+
+	tests := []struct {
+		name   string
+		file   string
+		format Format
+		want   Format
+	}{
+		{
+			name: "JSON by extension",
+			file: "metrics.json",
+			want: FormatJSON,
+		},
+		{
+			name: "Binary by extension",
+			file: "metrics.bin",
+			want: FormatBinary,
+		},
+		{
+			name: "Gzipped binary by extension",
+			file: "metrics.bin.gz",
+			want: FormatBinaryGzip,
+		},
+		{
+			name:   "Binary from config",
+			file:   "metrics.json",
+			format: FormatBinary,
+			want:   FormatBinary,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+
+			mw, err := NewMetricWriter(path, SnapshotOptions{Format: tt.format})
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, mw.opts.Format)
+			for _, m := range metrics {
+				require.Nil(t, mw.Write(m))
+			}
+			require.Nil(t, mw.Close())
+
+			mr, err := NewMetricReader(path)
+			require.Nil(t, err)
+			defer mr.Close()
+			assert.Equal(t, len(metrics), mr.Header().Count)
+
+			var got []metric.Metrics
+			for {
+				m, err := mr.Read()
+				if err == io.EOF {
+					break
+				}
+				require.Nil(t, err)
+				got = append(got, m)
+			}
+			assert.ElementsMatch(t, metrics, got)
+
+			data, err := os.ReadFile(path)
+			require.Nil(t, err)
+			data[len(data)-3] ^= 0xff
+			require.Nil(t, os.WriteFile(path, data, 0664))
+
+			_, err = NewMetricReader(path)
+			assert.ErrorIs(t, err, ErrCorruptSnapshot)
+		})
+	}
+
+	_, err := NewMetricWriter("metrics.json", SnapshotOptions{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	metrics := testMetrics(100000)
+
+	for _, format := range []Format{FormatJSON, FormatBinary, FormatBinaryGzip} {
+		path := filepath.Join(b.TempDir(), "metrics")
+		opts := SnapshotOptions{Format: format}
+
+		b.Run(string(format)+"/write", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mw, err := NewMetricWriter(path, opts)
+				require.Nil(b, err)
+				for _, m := range metrics {
+					require.Nil(b, mw.Write(m))
+				}
+				require.Nil(b, mw.Close())
+			}
+
+			info, err := os.Stat(path)
+			require.Nil(b, err)
+			b.ReportMetric(float64(info.Size()), "bytes/snapshot")
+		})
+
+		b.Run(string(format)+"/restore", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mr, err := NewMetricReader(path)
+				require.Nil(b, err)
+				for {
+					_, err := mr.Read()
+					if err == io.EOF {
+						break
+					}
+					require.Nil(b, err)
+				}
+				require.Nil(b, mr.Close())
+			}
+		})
+	}
+}
+
+func testMetrics(n int) []metric.Metrics {
+	metrics := make([]metric.Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("host%03d_cpu_utilization_%d", i%100, i)
+		if i%2 == 0 {
+			delta := int64(i * 10)
+			metrics = append(metrics, metric.Metrics{ID: name, MType: metric.CounterType, Delta: &delta})
+			continue
+		}
+
+		value := float64(i) / 3
+		m := metric.Metrics{ID: name, MType: metric.GaugeType, Value: &value}
+		if i%5 == 1 {
+			m.Metadata = metric.Metadata{Unit: "percent", Help: "CPU utilization"}
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+func TestWriteState(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	require.Nil(t, WriteState(path, map[string]int{"firing": 1}))
+	require.Nil(t, WriteState(path, map[string]int{"firing": 2}))
+
+	// A failed write must leave the previous state readable.
+	assert.NotNil(t, WriteState(path, map[string]interface{}{"firing": make(chan int)}))
+
+	var got map[string]int
+	require.Nil(t, ReadState(path, &got))
+	assert.Equal(t, map[string]int{"firing": 2}, got)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, entries, 1, "no temp files are left behind")
+}
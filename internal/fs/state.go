@@ -0,0 +1,29 @@
+package fs
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+func WriteState(filepath string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(filepath, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	}, nil)
+}
+
+func ReadState(filepath string, v interface{}) error {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(v)
+}
@@ -0,0 +1,220 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"
+	SyncInterval SyncPolicy = "interval"
+	SyncNever    SyncPolicy = "never"
+)
+
+type WALOp string
+
+const (
+	WALUpdate         WALOp = "update"
+	WALDelete         WALOp = "delete"
+	WALSetMetadata    WALOp = "set_metadata"
+	WALDeleteMetadata WALOp = "delete_metadata"
+)
+
+const maxWALRecordSize = 1 << 20
+
+type WALRecord struct {
+	Op     WALOp          `json:"op"`
+	Metric metric.Metrics `json:"metric"`
+}
+
+type WAL struct {
+	file   *os.File
+	path   string
+	policy SyncPolicy
+	dirty  bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown wal sync policy %q", s)
+	}
+}
+
+func OpenWAL(path string, policy SyncPolicy, interval time.Duration) (*WAL, error) {
+	if _, err := ParseSyncPolicy(string(policy)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		file:   file,
+		path:   path,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+
+	if policy == SyncInterval && interval > 0 {
+		w.wg.Add(1)
+		go w.syncLoop(interval)
+	}
+
+	return w, nil
+}
+
+func (w *WAL) Append(records ...WALRecord) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%08x %s\n", crc32.ChecksumIEEE(b), b)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if w.policy == SyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+
+	return nil
+}
+
+func (w *WAL) Replay(fn func(WALRecord) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	file, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return w.truncateTo(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		r, ok := decodeWALRecord(line)
+		if !ok {
+			return w.truncateTo(offset)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+	}
+}
+
+func (w *WAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.truncateTo(0)
+}
+
+func (w *WAL) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.policy != SyncNever {
+		if err := w.file.Sync(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	return w.file.Close()
+}
+
+func (w *WAL) truncateTo(offset int64) error {
+	if err := w.file.Truncate(offset); err != nil {
+		return err
+	}
+	w.dirty = false
+
+	return w.file.Sync()
+}
+
+func (w *WAL) syncLoop(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty {
+				if err := w.file.Sync(); err == nil {
+					w.dirty = false
+				}
+			}
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func decodeWALRecord(line []byte) (WALRecord, bool) {
+	var r WALRecord
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 10 || len(line) > maxWALRecordSize || line[8] != ' ' {
+		return r, false
+	}
+
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE(line[9:]) {
+		return r, false
+	}
+
+	if err := json.Unmarshal(line[9:], &r); err != nil {
+		return r, false
+	}
+
+	return r, true
+}
@@ -0,0 +1,112 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestWAL(t *testing.T) {
+	delta := int64(5)
+	value := 1.5
+	records := []WALRecord{
+		{Op: WALUpdate, Metric: metric.Metrics{ID: "PollCount", MType: metric.CounterType, Delta: &delta}},
+		{Op: WALUpdate, Metric: metric.Metrics{ID: "Alloc", MType: metric.GaugeType, Value: &value}},
+		{Op: WALDelete, Metric: metric.Metrics{ID: "Alloc"}},
+	}
+
+	tests := []struct {
+		name   string
+		policy SyncPolicy
+		tail   string
+		want   []WALRecord
+	}{
+		{
+			name:   "Replay appended records",
+			policy: SyncAlways,
+			want:   records,
+		},
+		{
+			name:   "Replay with interval sync",
+			policy: SyncInterval,
+			want:   records,
+		},
+		{
+			name:   "Torn tail is dropped",
+			policy: SyncNever,
+			tail:   `0000abcd {"op":"upd`,
+			want:   records,
+		},
+		{
+			name:   "Corrupt record stops replay",
+			policy: SyncAlways,
+			tail:   "00000000 {\"op\":\"delete\",\"metric\":{\"id\":\"PollCount\"}}\n",
+			want:   records,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.wal")
+
+			w, err := OpenWAL(path, tt.policy, 10*time.Millisecond)
+			require.Nil(t, err)
+			require.Nil(t, w.Append(records[:2]...))
+			require.Nil(t, w.Append(records[2]))
+			require.Nil(t, w.Close())
+
+			size := fileSize(t, path)
+			if tt.tail != "" {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0664)
+				require.Nil(t, err)
+				_, err = f.WriteString(tt.tail)
+				require.Nil(t, err)
+				require.Nil(t, f.Close())
+			}
+
+			w, err = OpenWAL(path, tt.policy, 0)
+			require.Nil(t, err)
+			defer w.Close()
+
+			var got []WALRecord
+			require.Nil(t, w.Replay(func(r WALRecord) error {
+				got = append(got, r)
+				return nil
+			}))
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, size, fileSize(t, path))
+
+			require.Nil(t, w.Truncate())
+			assert.Equal(t, int64(0), fileSize(t, path))
+
+			got = nil
+			require.Nil(t, w.Append(records[0]))
+			require.Nil(t, w.Replay(func(r WALRecord) error {
+				got = append(got, r)
+				return nil
+			}))
+			assert.Equal(t, records[:1], got)
+		})
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	p, err := ParseSyncPolicy("interval")
+	assert.Nil(t, err)
+	assert.Equal(t, SyncInterval, p)
+
+	_, err = ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+
+	return info.Size()
+}
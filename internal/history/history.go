@@ -0,0 +1,278 @@
+package history
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const defaultCapacity = 8640
+
+type Tier string
+
+const (
+	TierRaw    Tier = "raw"
+	TierMinute Tier = "1m"
+	TierHour   Tier = "1h"
+)
+
+type Retention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+var DefaultRetention = Retention{
+	Raw:    24 * time.Hour,
+	Minute: 30 * 24 * time.Hour,
+	Hour:   365 * 24 * time.Hour,
+}
+
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+type Rollup struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
+type History struct {
+	series    map[string]*series
+	capacity  int
+	retention Retention
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+type series struct {
+	raw        *ring[Sample]
+	minutes    *ring[Rollup]
+	hours      *ring[Rollup]
+	openMinute *Rollup
+	openHour   *Rollup
+}
+
+func New(capacity int, retention Retention) *History {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if retention.Raw <= 0 {
+		retention.Raw = DefaultRetention.Raw
+	}
+	if retention.Minute <= 0 {
+		retention.Minute = DefaultRetention.Minute
+	}
+	if retention.Hour <= 0 {
+		retention.Hour = DefaultRetention.Hour
+	}
+
+	return &History{
+		series:    make(map[string]*series),
+		capacity:  capacity,
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+func (h *History) Record(ms ...metric.IMetric) {
+	t := h.now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, m := range ms {
+		v, err := strconv.ParseFloat(m.ValueAsString(), 64)
+		if err != nil {
+			continue
+		}
+
+		s, ok := h.series[m.Name()]
+		if !ok {
+			s = &series{
+				raw:     newRing[Sample](h.capacity),
+				minutes: newRing[Rollup](int(h.retention.Minute / time.Minute)),
+				hours:   newRing[Rollup](int(h.retention.Hour / time.Hour)),
+			}
+			h.series[m.Name()] = s
+		}
+
+		s.raw.push(Sample{Time: t, Value: v})
+		s.add(t, v)
+	}
+}
+
+func (h *History) Range(name string, from time.Time) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.series[name]
+	if !ok {
+		return nil
+	}
+
+	switch tier := s.tierFor(from); tier {
+	case TierMinute, TierHour:
+		return samplesOf(s.rollups(tier, from))
+	default:
+		samples := s.raw.list()
+		i := sort.Search(len(samples), func(i int) bool {
+			return !samples[i].Time.Before(from)
+		})
+		return samples[i:]
+	}
+}
+
+func (h *History) Rollups(name string, tier Tier, from time.Time) []Rollup {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.series[name]
+	if !ok {
+		return nil
+	}
+
+	return s.rollups(tier, from)
+}
+
+func (h *History) TierFor(name string, from time.Time) Tier {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s, ok := h.series[name]
+	if !ok {
+		return TierRaw
+	}
+
+	return s.tierFor(from)
+}
+
+func (h *History) Compact() {
+	now := h.now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.series {
+		if s.openMinute != nil && !s.openMinute.Time.Add(time.Minute).After(now) {
+			s.closeMinute()
+		}
+		if s.openHour != nil && !s.openHour.Time.Add(time.Hour).After(now) {
+			s.closeHour()
+		}
+
+		rawCutoff := now.Add(-h.retention.Raw)
+		s.raw.dropWhile(func(v Sample) bool { return v.Time.Before(rawCutoff) })
+		minuteCutoff := now.Add(-h.retention.Minute)
+		s.minutes.dropWhile(func(r Rollup) bool { return r.Time.Before(minuteCutoff) })
+		hourCutoff := now.Add(-h.retention.Hour)
+		s.hours.dropWhile(func(r Rollup) bool { return r.Time.Before(hourCutoff) })
+	}
+}
+
+func (h *History) Delete(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, name)
+}
+
+func (s *series) add(t time.Time, v float64) {
+	bucket := t.Truncate(time.Minute)
+	if s.openMinute != nil && !s.openMinute.Time.Equal(bucket) {
+		s.closeMinute()
+	}
+	if s.openMinute == nil {
+		s.openMinute = &Rollup{Time: bucket, Min: v, Max: v}
+	}
+	s.openMinute.merge(Rollup{Min: v, Max: v, Avg: v, Count: 1})
+}
+
+func (s *series) closeMinute() {
+	r := *s.openMinute
+	s.openMinute = nil
+	s.minutes.push(r)
+
+	bucket := r.Time.Truncate(time.Hour)
+	if s.openHour != nil && !s.openHour.Time.Equal(bucket) {
+		s.closeHour()
+	}
+	if s.openHour == nil {
+		s.openHour = &Rollup{Time: bucket, Min: r.Min, Max: r.Max}
+	}
+	s.openHour.merge(r)
+}
+
+func (s *series) closeHour() {
+	s.hours.push(*s.openHour)
+	s.openHour = nil
+}
+
+func (s *series) rollups(tier Tier, from time.Time) []Rollup {
+	var rs []Rollup
+	var width time.Duration
+	switch tier {
+	case TierMinute:
+		rs, width = s.minutes.list(), time.Minute
+		if s.openMinute != nil {
+			rs = append(rs, *s.openMinute)
+		}
+	case TierHour:
+		rs, width = s.hours.list(), time.Hour
+		if s.openHour != nil {
+			rs = append(rs, *s.openHour)
+		}
+	default:
+		return nil
+	}
+
+	i := sort.Search(len(rs), func(i int) bool {
+		return rs[i].Time.Add(width).After(from)
+	})
+
+	return rs[i:]
+}
+
+func (s *series) tierFor(from time.Time) Tier {
+	if from.IsZero() || covers(s.raw.dropped, s.raw.len() > 0 && !s.raw.at(0).Time.After(from)) {
+		return TierRaw
+	}
+	if covers(s.minutes.dropped, s.minutes.len() > 0 && !s.minutes.at(0).Time.After(from)) {
+		return TierMinute
+	}
+
+	return TierHour
+}
+
+func covers(dropped bool, oldestBeforeFrom bool) bool {
+	return !dropped || oldestBeforeFrom
+}
+
+func (r *Rollup) merge(o Rollup) {
+	if o.Min < r.Min {
+		r.Min = o.Min
+	}
+	if o.Max > r.Max {
+		r.Max = o.Max
+	}
+
+	total := r.Avg*float64(r.Count) + o.Avg*float64(o.Count)
+	r.Count += o.Count
+	r.Avg = total / float64(r.Count)
+}
+
+func samplesOf(rs []Rollup) []Sample {
+	result := make([]Sample, 0, len(rs))
+	for _, r := range rs {
+		result = append(result, Sample{Time: r.Time, Value: r.Avg})
+	}
+
+	return result
+}
@@ -0,0 +1,106 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestHistoryRecord(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		capacity int
+		values   []float64
+		from     time.Time
+		want     []float64
+	}{
+		{
+			name:     "Keep all samples when capacity is not reached",
+			capacity: 5,
+			values:   []float64{1, 2, 3},
+			from:     start,
+			want:     []float64{1, 2, 3},
+		},
+		{
+			name:     "Drop oldest samples when capacity is exceeded",
+			capacity: 3,
+			values:   []float64{1, 2, 3, 4, 5},
+			from:     start.Add(2 * time.Second),
+			want:     []float64{3, 4, 5},
+		},
+		{
+			name:     "Return samples newer than from",
+			capacity: 5,
+			values:   []float64{1, 2, 3, 4},
+			from:     start.Add(2 * time.Second),
+			want:     []float64{3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(tt.capacity, DefaultRetention)
+			tick := 0
+			h.now = func() time.Time {
+				return start.Add(time.Duration(tick) * time.Second)
+			}
+
+			for _, v := range tt.values {
+				h.Record(metric.NewGaugeMetric("Alloc", metric.Gauge(v)))
+				tick++
+			}
+
+			var got []float64
+			for _, s := range h.Range("Alloc", tt.from) {
+				got = append(got, s.Value)
+			}
+
+			assert.Equal(t, tt.want, got)
+			assert.Empty(t, h.Range("Unknown", start))
+		})
+	}
+}
+
+func TestHistoryTiers(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	h := New(10, Retention{Raw: 5 * time.Minute, Minute: time.Hour, Hour: 24 * time.Hour})
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < 180; i++ {
+		now = start.Add(time.Duration(i) * 20 * time.Second)
+		h.Record(metric.NewGaugeMetric("Alloc", metric.Gauge(i%3)))
+	}
+	now = start.Add(time.Hour)
+
+	assert.Equal(t, TierRaw, h.TierFor("Alloc", now.Add(-2*time.Minute)))
+	assert.Len(t, h.Range("Alloc", now.Add(-2*time.Minute)), 6)
+
+	assert.Equal(t, TierMinute, h.TierFor("Alloc", now.Add(-30*time.Minute)))
+	minutes := h.Rollups("Alloc", TierMinute, now.Add(-30*time.Minute))
+	require.Len(t, minutes, 30)
+	assert.Equal(t, Rollup{Time: start.Add(30 * time.Minute), Min: 0, Max: 2, Avg: 1, Count: 3}, minutes[0])
+	assert.Len(t, h.Range("Alloc", now.Add(-30*time.Minute)), 30)
+
+	h.Compact()
+
+	assert.Len(t, h.Rollups("Alloc", TierMinute, time.Time{}), 60)
+	hours := h.Rollups("Alloc", TierHour, time.Time{})
+	require.Len(t, hours, 1)
+	assert.Equal(t, Rollup{Time: start, Min: 0, Max: 2, Avg: 1, Count: 180}, hours[0])
+	assert.Len(t, h.Range("Alloc", now.Add(-3*time.Minute)), 9)
+
+	now = start.Add(3 * time.Hour)
+	h.Compact()
+
+	assert.Empty(t, h.Rollups("Alloc", TierMinute, time.Time{}))
+	assert.Empty(t, h.Range("Alloc", now.Add(-time.Minute)), "raw samples older than retention are dropped")
+	assert.Equal(t, TierHour, h.TierFor("Alloc", start))
+	assert.Equal(t, []Sample{{Time: start, Value: 1}}, h.Range("Alloc", start))
+}
@@ -0,0 +1,56 @@
+package history
+
+type ring[T any] struct {
+	items    []T
+	start    int
+	capacity int
+	dropped  bool
+}
+
+func newRing[T any](capacity int) *ring[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &ring[T]{capacity: capacity}
+}
+
+func (r *ring[T]) push(v T) {
+	if len(r.items) < r.capacity {
+		r.items = append(r.items, v)
+		return
+	}
+
+	r.items[r.start] = v
+	r.start = (r.start + 1) % len(r.items)
+	r.dropped = true
+}
+
+func (r *ring[T]) len() int {
+	return len(r.items)
+}
+
+func (r *ring[T]) at(i int) T {
+	return r.items[(r.start+i)%len(r.items)]
+}
+
+func (r *ring[T]) list() []T {
+	result := make([]T, 0, len(r.items))
+	result = append(result, r.items[r.start:]...)
+
+	return append(result, r.items[:r.start]...)
+}
+
+func (r *ring[T]) dropWhile(old func(T) bool) {
+	n := 0
+	for n < len(r.items) && old(r.at(n)) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	r.items = r.list()[n:]
+	r.start = 0
+	r.dropped = true
+}
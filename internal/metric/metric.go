@@ -32,12 +32,19 @@ type CounterMetric struct {
 	value Counter
 }
 
+type Metadata struct {
+	Unit  string `json:"unit,omitempty"`
+	Help  string `json:"help,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
+
 type Metrics struct {
 	ID    string   `json:"id"`
 	MType string   `json:"type"`
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
 	Hash  string   `json:"hash,omitempty"`
+	Metadata
 }
 
 type MetricsBatch struct {
@@ -101,6 +108,24 @@ func NewMetricsFromIMetric(m IMetric) (Metrics, error) {
 	}
 }
 
+func (md Metadata) IsZero() bool {
+	return md == Metadata{}
+}
+
+func (md Metadata) Merge(nmd Metadata) Metadata {
+	if nmd.Unit != "" {
+		md.Unit = nmd.Unit
+	}
+	if nmd.Help != "" {
+		md.Help = nmd.Help
+	}
+	if nmd.Owner != "" {
+		md.Owner = nmd.Owner
+	}
+
+	return md
+}
+
 func (m *Metrics) Sign(key string) error {
 	var s string
 	switch m.MType {
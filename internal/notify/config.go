@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultRepeatInterval = 4 * time.Hour
+	defaultMaxRetries     = 3
+	defaultBackoff        = time.Second
+	defaultTimeout        = 10 * time.Second
+)
+
+type Receiver struct {
+	Name           string            `yaml:"name"`
+	URL            string            `yaml:"url"`
+	Secret         string            `yaml:"secret"`
+	Template       string            `yaml:"template"`
+	Match          map[string]string `yaml:"match"`
+	GroupBy        []string          `yaml:"group_by"`
+	RepeatInterval time.Duration     `yaml:"repeat_interval"`
+	MaxRetries     *int              `yaml:"max_retries"`
+	Backoff        time.Duration     `yaml:"backoff"`
+	Timeout        time.Duration     `yaml:"timeout"`
+
+	template *template.Template
+}
+
+type receiversFile struct {
+	Receivers []Receiver `yaml:"receivers"`
+}
+
+func LoadReceivers(path string) ([]Receiver, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseReceivers(b)
+}
+
+func ParseReceivers(b []byte) ([]Receiver, error) {
+	var f receiversFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(f.Receivers))
+	for i := range f.Receivers {
+		r := &f.Receivers[i]
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("receiver %d: %w", i, err)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("receiver %d: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+
+	return f.Receivers, nil
+}
+
+func (r *Receiver) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("receiver name is required")
+	}
+	if r.URL == "" {
+		return fmt.Errorf("receiver %q: url is required", r.Name)
+	}
+
+	if r.RepeatInterval <= 0 {
+		r.RepeatInterval = defaultRepeatInterval
+	}
+	if r.MaxRetries == nil {
+		n := defaultMaxRetries
+		r.MaxRetries = &n
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = defaultBackoff
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = defaultTimeout
+	}
+
+	if r.Template != "" {
+		t, err := template.New(r.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(r.Template)
+		if err != nil {
+			return fmt.Errorf("receiver %q: template: %w", r.Name, err)
+		}
+		r.template = t
+	}
+
+	return nil
+}
+
+func (r Receiver) payload(msg Message) ([]byte, error) {
+	if r.template == nil {
+		return json.Marshal(msg)
+	}
+
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, msg); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("receiver %q: template produced invalid json", r.Name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (r Receiver) matches(labels map[string]string) bool {
+	for k, v := range r.Match {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
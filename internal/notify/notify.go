@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/1g0rbm/sysmonitor/internal/alerting"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+type Message struct {
+	Receiver    string            `json:"receiver"`
+	Status      string            `json:"status"`
+	GroupLabels map[string]string `json:"group_labels"`
+	Alerts      []alerting.Alert  `json:"alerts"`
+}
+
+type group struct {
+	fingerprint string
+	lastSent    time.Time
+}
+
+type Notifier struct {
+	receivers []Receiver
+	groups    map[string]*group
+	sender    *sender
+	logger    zerolog.Logger
+	now       func() time.Time
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+func NewNotifier(l zerolog.Logger) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Notifier{
+		groups: make(map[string]*group),
+		sender: newSender(),
+		logger: l,
+		now:    time.Now,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (n *Notifier) SetReceivers(receivers []Receiver) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.receivers = receivers
+	n.groups = make(map[string]*group)
+}
+
+func (n *Notifier) Notify(alerts []alerting.Alert) {
+	now := n.now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seen := make(map[string]struct{})
+	for _, r := range n.receivers {
+		for key, msg := range groupAlerts(r, alerts) {
+			seen[key] = struct{}{}
+
+			fp := fingerprint(msg.Alerts)
+			g, ok := n.groups[key]
+			if !ok {
+				g = &group{}
+				n.groups[key] = g
+			}
+
+			switch {
+			case fp == "" && g.fingerprint == "":
+				continue
+			case fp == "":
+				msg.Status = StatusResolved
+			case fp != g.fingerprint || now.Sub(g.lastSent) >= r.RepeatInterval:
+				msg.Status = StatusFiring
+			default:
+				continue
+			}
+
+			g.fingerprint = fp
+			g.lastSent = now
+			n.send(r, msg)
+		}
+	}
+
+	for key := range n.groups {
+		if _, ok := seen[key]; !ok {
+			delete(n.groups, key)
+		}
+	}
+}
+
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+func (n *Notifier) send(r Receiver, msg Message) {
+	body, err := r.payload(msg)
+	if err != nil {
+		n.logger.Error().Msgf("Notification payload error for receiver %s: %s", r.Name, err)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		if err := n.sender.deliver(n.ctx, r, body); err != nil {
+			n.logger.Error().Msgf("Notification delivery to receiver %s failed: %s", r.Name, err)
+		}
+	}()
+}
+
+func groupAlerts(r Receiver, alerts []alerting.Alert) map[string]Message {
+	groups := make(map[string]Message)
+	for _, a := range alerts {
+		if a.State == alerting.StatePending || !r.matches(a.Labels) {
+			continue
+		}
+
+		labels := make(map[string]string, len(r.GroupBy))
+		for _, l := range r.GroupBy {
+			labels[l] = a.Labels[l]
+		}
+
+		key := r.Name + "/" + labelsKey(labels)
+		msg, ok := groups[key]
+		if !ok {
+			msg = Message{Receiver: r.Name, GroupLabels: labels}
+		}
+		msg.Alerts = append(msg.Alerts, a)
+		groups[key] = msg
+	}
+
+	return groups
+}
+
+func fingerprint(alerts []alerting.Alert) string {
+	var keys []string
+	for _, a := range alerts {
+		if a.State == alerting.StateFiring {
+			keys = append(keys, labelsKey(a.Labels))
+		}
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ";")
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/alerting"
+)
+
+type delivery struct {
+	body      []byte
+	signature string
+}
+
+type testReceiver struct {
+	server     *httptest.Server
+	deliveries []delivery
+	statuses   []int
+	attempts   int
+	mu         sync.Mutex
+}
+
+func newTestReceiver(statuses ...int) *testReceiver {
+	tr := &testReceiver{statuses: statuses}
+	tr.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+
+		status := http.StatusOK
+		if tr.attempts < len(tr.statuses) {
+			status = tr.statuses[tr.attempts]
+		}
+		tr.attempts++
+
+		if status == http.StatusOK {
+			tr.deliveries = append(tr.deliveries, delivery{body: body, signature: r.Header.Get(SignatureHeader)})
+		}
+		w.WriteHeader(status)
+	}))
+
+	return tr
+}
+
+func (tr *testReceiver) messages(t *testing.T) []Message {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	var result []Message
+	for _, d := range tr.deliveries {
+		var msg Message
+		require.NoError(t, json.Unmarshal(d.body, &msg))
+		result = append(result, msg)
+	}
+
+	return result
+}
+
+func testAlert(rule string, host string, state alerting.State) alerting.Alert {
+	return alerting.Alert{
+		Rule:   rule,
+		State:  state,
+		Labels: map[string]string{alerting.AlertNameLabel: rule, "host": host},
+	}
+}
+
+func newTestNotifier(t *testing.T, config string) (*Notifier, *time.Time) {
+	receivers, err := ParseReceivers([]byte(config))
+	require.NoError(t, err)
+
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	n := NewNotifier(zerolog.New(os.Stdout))
+	n.now = func() time.Time { return now }
+	n.SetReceivers(receivers)
+	t.Cleanup(n.Close)
+
+	return n, &now
+}
+
+func TestParseReceivers(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name:   "Valid receiver with defaults",
+			config: "receivers:\n  - name: oncall\n    url: http://localhost/hook\n",
+		},
+		{
+			name:    "Missing url",
+			config:  "receivers:\n  - name: oncall\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid template",
+			config:  "receivers:\n  - name: oncall\n    url: http://localhost/hook\n    template: '{{ .Status'\n",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate name",
+			config:  "receivers:\n  - name: a\n    url: http://a\n  - name: a\n    url: http://b\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receivers, err := ParseReceivers([]byte(tt.config))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, receivers, 1)
+			assert.Equal(t, defaultRepeatInterval, receivers[0].RepeatInterval)
+			assert.Equal(t, defaultMaxRetries, *receivers[0].MaxRetries)
+		})
+	}
+}
+
+func TestNotifierGroupsAndRepeats(t *testing.T) {
+	tr := newTestReceiver()
+	defer tr.server.Close()
+
+	n, now := newTestNotifier(t, `
+receivers:
+  - name: oncall
+    url: `+tr.server.URL+`
+    secret: topsecret
+    group_by: [alertname]
+    repeat_interval: 1h
+`)
+
+	firing := []alerting.Alert{
+		testAlert("HighCPU", "web-1", alerting.StateFiring),
+		testAlert("HighCPU", "web-2", alerting.StateFiring),
+		testAlert("LowMemory", "web-1", alerting.StatePending),
+	}
+
+	n.Notify(firing)
+	n.Wait()
+
+	msgs := tr.messages(t)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "oncall", msgs[0].Receiver)
+	assert.Equal(t, StatusFiring, msgs[0].Status)
+	assert.Equal(t, map[string]string{alerting.AlertNameLabel: "HighCPU"}, msgs[0].GroupLabels)
+	assert.Len(t, msgs[0].Alerts, 2)
+	assert.Equal(t, Sign("topsecret", tr.deliveries[0].body), tr.deliveries[0].signature)
+
+	*now = now.Add(30 * time.Minute)
+	n.Notify(firing)
+	n.Wait()
+	assert.Len(t, tr.messages(t), 1, "unchanged group should not be sent before repeat interval")
+
+	*now = now.Add(30 * time.Minute)
+	n.Notify(firing)
+	n.Wait()
+	assert.Len(t, tr.messages(t), 2, "unchanged group should be repeated after repeat interval")
+
+	n.Notify([]alerting.Alert{
+		testAlert("HighCPU", "web-1", alerting.StateResolved),
+		testAlert("HighCPU", "web-2", alerting.StateResolved),
+	})
+	n.Wait()
+
+	msgs = tr.messages(t)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, StatusResolved, msgs[2].Status)
+
+	n.Notify(nil)
+	n.Wait()
+	assert.Len(t, tr.messages(t), 3)
+}
+
+func TestNotifierMatchAndTemplate(t *testing.T) {
+	tr := newTestReceiver()
+	defer tr.server.Close()
+
+	n, _ := newTestNotifier(t, `
+receivers:
+  - name: chat
+    url: `+tr.server.URL+`
+    match:
+      host: web-2
+    template: '{"text": {{ json .Status }}, "count": {{ len .Alerts }}}'
+`)
+
+	n.Notify([]alerting.Alert{
+		testAlert("HighCPU", "web-1", alerting.StateFiring),
+		testAlert("HighCPU", "web-2", alerting.StateFiring),
+	})
+	n.Wait()
+
+	require.Len(t, tr.deliveries, 1)
+	assert.JSONEq(t, `{"text":"firing","count":1}`, string(tr.deliveries[0].body))
+	assert.Empty(t, tr.deliveries[0].signature)
+}
+
+func TestNotifierRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantDelivery bool
+	}{
+		{
+			name:         "Retry server errors with backoff",
+			statuses:     []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			wantAttempts: 3,
+			wantDelivery: true,
+		},
+		{
+			name:         "Give up after max retries",
+			statuses:     []int{500, 500, 500, 500},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Do not retry client errors",
+			statuses:     []int{http.StatusBadRequest},
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestReceiver(tt.statuses...)
+			defer tr.server.Close()
+
+			n, _ := newTestNotifier(t, `
+receivers:
+  - name: oncall
+    url: `+tr.server.URL+`
+    max_retries: 2
+    backoff: 1ms
+`)
+
+			n.Notify([]alerting.Alert{testAlert("HighCPU", "web-1", alerting.StateFiring)})
+			n.Wait()
+
+			assert.Equal(t, tt.wantAttempts, tr.attempts)
+			assert.Equal(t, tt.wantDelivery, len(tr.deliveries) == 1)
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := Receiver{Backoff: time.Second}
+
+	assert.Equal(t, time.Second, backoff(r, 1))
+	assert.Equal(t, 4*time.Second, backoff(r, 3))
+	assert.Equal(t, maxBackoff, backoff(r, 100), "the delay stops growing instead of overflowing")
+}
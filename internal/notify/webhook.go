@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const SignatureHeader = "X-Sysmonitor-Signature"
+
+// maxBackoff caps the delay between retries, however many are configured.
+const maxBackoff = 5 * time.Minute
+
+type sender struct {
+	client *http.Client
+}
+
+func newSender() *sender {
+	return &sender{client: &http.Client{}}
+}
+
+func (s *sender) deliver(ctx context.Context, r Receiver, body []byte) error {
+	var err error
+	for attempt := 0; attempt <= *r.MaxRetries; attempt++ {
+		if attempt > 0 && !sleep(ctx, backoff(r, attempt)) {
+			return ctx.Err()
+		}
+
+		var retry bool
+		retry, err = s.post(ctx, r, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", *r.MaxRetries+1, err)
+}
+
+// backoff doubles the receiver's delay with each retry, up to maxBackoff.
+func backoff(r Receiver, attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *sender) post(ctx context.Context, r Receiver, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(r.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+}
+
+func Sign(key string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,136 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type Rule struct {
+	Name string `yaml:"record"`
+	Expr string `yaml:"expr"`
+
+	expr *expr.Expr
+}
+
+type rulesFile struct {
+	Records []Rule `yaml:"records"`
+}
+
+type Recorder struct {
+	rules []Rule
+	now   func() time.Time
+	mu    sync.RWMutex
+}
+
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRules(b)
+}
+
+func ParseRules(b []byte) ([]Rule, error) {
+	var f rulesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(f.Records))
+	for i := range f.Records {
+		r := &f.Records[i]
+		if !validName.MatchString(r.Name) {
+			return nil, fmt.Errorf("record %d: invalid metric name %q", i, r.Name)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("record %d: duplicate metric name %q", i, r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		e, err := expr.Parse(r.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("record %q: %w", r.Name, err)
+		}
+		r.expr = e
+	}
+
+	return f.Records, nil
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{now: time.Now}
+}
+
+func (rec *Recorder) SetRules(rules []Rule) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.rules = rules
+}
+
+func (rec *Recorder) Rules() []Rule {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+
+	return append([]Rule(nil), rec.rules...)
+}
+
+func (rec *Recorder) Eval(src expr.Source) ([]metric.IMetric, error) {
+	now := rec.now()
+
+	var result []metric.IMetric
+	var errs []string
+	for _, r := range rec.Rules() {
+		v, ok, err := r.eval(src, now)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("record %q: %s", r.Name, err))
+			continue
+		}
+		if ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			result = append(result, metric.NewGaugeMetric(r.Name, metric.Gauge(v)))
+		}
+	}
+
+	if len(errs) > 0 {
+		return result, errors.New(strings.Join(errs, "; "))
+	}
+
+	return result, nil
+}
+
+func (r Rule) eval(src expr.Source, ts time.Time) (float64, bool, error) {
+	v, err := r.expr.Eval(src, ts)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch v := v.(type) {
+	case expr.Scalar:
+		return float64(v), true, nil
+	case expr.Vector:
+		switch len(v) {
+		case 0:
+			return 0, false, nil
+		case 1:
+			return v[0].Value, true, nil
+		default:
+			return 0, false, fmt.Errorf("expression returned %d series, expected one", len(v))
+		}
+	default:
+		return 0, false, fmt.Errorf("unexpected result type %s", v.Type())
+	}
+}
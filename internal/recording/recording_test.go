@@ -0,0 +1,107 @@
+package recording
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/expr"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type testSource map[string]float64
+
+func (s testSource) Select(matchers []*expr.Matcher) (expr.Vector, error) {
+	var result expr.Vector
+	for name, v := range s {
+		l := expr.Labels{expr.MetricNameLabel: name, expr.TypeLabel: "gauge"}
+		if expr.MatchAll(matchers, l) {
+			result = append(result, expr.Sample{Labels: l, Value: v})
+		}
+	}
+
+	return result, nil
+}
+
+func (s testSource) SelectRange(_ []*expr.Matcher, _ time.Time, _ time.Time) (expr.Matrix, error) {
+	return nil, nil
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "Valid records",
+			rules: "records:\n  - record: MemoryUsedRatio\n    expr: (TotalMemory - FreeMemory) / TotalMemory\n",
+			want:  1,
+		},
+		{
+			name:  "Alerting rules are ignored",
+			rules: "rules:\n  - alert: HighCPU\n    expr: CPUutilization1 > 90\n",
+			want:  0,
+		},
+		{
+			name:    "Invalid metric name",
+			rules:   "records:\n  - record: Memory-Used\n    expr: TotalMemory\n",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate metric name",
+			rules:   "records:\n  - record: A\n    expr: X\n  - record: A\n    expr: Y\n",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid expression",
+			rules:   "records:\n  - record: A\n    expr: X +\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules([]byte(tt.rules))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Len(t, rules, tt.want)
+		})
+	}
+}
+
+func TestRecorderEval(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+records:
+  - record: MemoryUsedRatio
+    expr: (TotalMemory - FreeMemory) / TotalMemory
+  - record: HeapFragmentation
+    expr: HeapIdle - HeapReleased
+  - record: Constant
+    expr: 2 * 21
+  - record: Missing
+    expr: Unknown * 2
+  - record: DivisionByZero
+    expr: FreeMemory / 0
+  - record: TooManySeries
+    expr: '{type="gauge"}'
+`))
+	require.NoError(t, err)
+
+	rec := NewRecorder()
+	rec.SetRules(rules)
+
+	got, err := rec.Eval(testSource{"TotalMemory": 200, "FreeMemory": 50, "HeapIdle": 30, "HeapReleased": 10})
+	assert.ErrorContains(t, err, `record "TooManySeries"`)
+	assert.Equal(t, []metric.IMetric{
+		metric.NewGaugeMetric("MemoryUsedRatio", 0.75),
+		metric.NewGaugeMetric("HeapFragmentation", 20),
+		metric.NewGaugeMetric("Constant", 42),
+	}, got)
+}
@@ -0,0 +1,237 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+)
+
+const (
+	StreamPath = "/api/v1/replication/stream"
+
+	retryInterval = time.Second
+	idleTimeout   = 3 * heartbeatInterval
+)
+
+type Target interface {
+	Apply(records ...fs.WALRecord) error
+	Reset(records ...fs.WALRecord) error
+}
+
+type Status struct {
+	Primary   string `json:"primary,omitempty"`
+	Log       string `json:"log"`
+	Seq       uint64 `json:"seq"`
+	Connected bool   `json:"connected"`
+}
+
+// Follower keeps a replica in sync with its primary until it is promoted.
+type Follower struct {
+	primary   string
+	token     string
+	target    Target
+	client    *http.Client
+	pos       Position
+	connected bool
+	promoted  bool
+	cancel    context.CancelFunc
+	done      chan struct{}
+	logger    zerolog.Logger
+	mu        sync.Mutex
+}
+
+func NewFollower(primary string, token string, target Target, l zerolog.Logger) *Follower {
+	return &Follower{
+		primary: strings.TrimRight(primary, "/"),
+		token:   token,
+		target:  target,
+		client:  &http.Client{},
+		logger:  l,
+	}
+}
+
+func (f *Follower) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.promoted || f.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+
+	go f.run(ctx)
+}
+
+// Active reports whether the server still follows its primary.
+func (f *Follower) Active() bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return !f.promoted
+}
+
+// Promote stops following the primary. Changes already applied stay, so the
+// replica carries on as a primary from the last position it reached.
+func (f *Follower) Promote() bool {
+	f.mu.Lock()
+	if f.promoted {
+		f.mu.Unlock()
+		return false
+	}
+	f.promoted = true
+	f.mu.Unlock()
+
+	f.Stop()
+
+	return true
+}
+
+func (f *Follower) Stop() {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	cancel, done := f.cancel, f.done
+	f.cancel = nil
+	f.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return Status{
+		Primary:   f.primary,
+		Log:       f.pos.Log,
+		Seq:       f.pos.Seq,
+		Connected: f.connected,
+	}
+}
+
+func (f *Follower) run(ctx context.Context) {
+	defer close(f.done)
+
+	for {
+		err := f.follow(ctx)
+		f.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		f.logger.Error().Msgf("Replication stream error: %s", err)
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *Follower) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pos := f.position()
+	q := url.Values{}
+	q.Set("log", pos.Log)
+	q.Set("after", fmt.Sprint(pos.Seq))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.primary+StreamPath+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// The primary sends heartbeats, so silence means the connection is gone.
+	idle := time.AfterFunc(idleTimeout, cancel)
+	defer idle.Stop()
+
+	f.setConnected(true)
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		idle.Reset(idleTimeout)
+
+		if err := f.apply(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Follower) apply(e Entry) error {
+	switch e.Op {
+	case "":
+		return nil
+	case OpReset:
+		if err := f.target.Reset(e.Snapshot...); err != nil {
+			return err
+		}
+		f.setPosition(Position{Log: e.Log, Seq: e.Seq})
+	default:
+		if err := f.target.Apply(e.WALRecord); err != nil {
+			return err
+		}
+		f.setPosition(Position{Log: f.position().Log, Seq: e.Seq})
+	}
+
+	return nil
+}
+
+func (f *Follower) position() Position {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.pos
+}
+
+func (f *Follower) setPosition(pos Position) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pos = pos
+}
+
+func (f *Follower) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.connected = connected
+}
@@ -0,0 +1,105 @@
+package replication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+)
+
+type recordingTarget struct {
+	names  []string
+	resets int
+	mu     sync.Mutex
+}
+
+func (t *recordingTarget) Apply(records ...fs.WALRecord) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, r := range records {
+		t.names = append(t.names, r.Metric.ID)
+	}
+
+	return nil
+}
+
+func (t *recordingTarget) Reset(records ...fs.WALRecord) error {
+	t.mu.Lock()
+	t.names = nil
+	t.resets++
+	t.mu.Unlock()
+
+	return t.Apply(records...)
+}
+
+func (t *recordingTarget) state() ([]string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]string(nil), t.names...), t.resets
+}
+
+func TestFollower(t *testing.T) {
+	l := NewLog(100)
+	require.Nil(t, l.Append(record("A")))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, StreamPath, r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		seq, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+		require.Nil(t, err)
+
+		from := Position{Log: r.URL.Query().Get("log"), Seq: seq}
+		err = Serve(r.Context(), w, l, from, func() ([]fs.WALRecord, error) {
+			return []fs.WALRecord{record("Snapshot")}, nil
+		})
+		assert.Nil(t, err)
+	}))
+	defer ts.Close()
+
+	target := &recordingTarget{}
+	f := NewFollower(ts.URL+"/", "secret", target, zerolog.New(os.Stdout))
+	assert.True(t, f.Active())
+
+	f.Start()
+
+	assert.Eventually(t, func() bool {
+		_, resets := target.state()
+		return resets == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.Nil(t, l.Append(record("B"), record("C")))
+
+	assert.Eventually(t, func() bool {
+		names, _ := target.state()
+		return len(names) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	names, resets := target.state()
+	assert.Equal(t, []string{"Snapshot", "B", "C"}, names)
+	assert.Equal(t, 1, resets)
+	assert.Equal(t, Position{Log: l.ID(), Seq: 3}, f.position())
+	assert.True(t, f.Status().Connected)
+
+	assert.True(t, f.Promote())
+	assert.False(t, f.Promote())
+	assert.False(t, f.Active())
+	assert.False(t, f.Status().Connected)
+
+	require.Nil(t, l.Append(record("D")))
+	time.Sleep(50 * time.Millisecond)
+
+	names, _ = target.state()
+	assert.Equal(t, []string{"Snapshot", "B", "C"}, names)
+}
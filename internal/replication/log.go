@@ -0,0 +1,119 @@
+package replication
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+)
+
+const OpReset fs.WALOp = "reset"
+
+// Entry is a numbered change of the primary. A reset entry carries the whole
+// state of the primary at Seq, an entry without an operation is a heartbeat.
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	fs.WALRecord
+	Log      string         `json:"log,omitempty"`
+	Snapshot []fs.WALRecord `json:"snapshot,omitempty"`
+}
+
+// Position is the last entry a replica applied. Sequence numbers are only
+// meaningful within the log they came from.
+type Position struct {
+	Log string `json:"log"`
+	Seq uint64 `json:"seq"`
+}
+
+// Log keeps the latest changes of the primary so replicas that fell behind
+// for a while can catch up without a full snapshot.
+type Log struct {
+	id      string
+	entries []Entry
+	last    uint64
+	next    chan struct{}
+	done    chan struct{}
+	closed  bool
+	mu      sync.RWMutex
+}
+
+func NewLog(size int) *Log {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Log{
+		id:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		entries: make([]Entry, size),
+		next:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (l *Log) ID() string {
+	return l.id
+}
+
+func (l *Log) Last() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.last
+}
+
+func (l *Log) Append(records ...fs.WALRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, r := range records {
+		l.last++
+		l.entries[l.last%uint64(len(l.entries))] = Entry{Seq: l.last, WALRecord: r}
+	}
+
+	close(l.next)
+	l.next = make(chan struct{})
+
+	return nil
+}
+
+// Since returns the entries following seq, or false when some of them are no
+// longer kept. The channel is closed on the next append.
+func (l *Log) Since(seq uint64) ([]Entry, bool, <-chan struct{}) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	size := uint64(len(l.entries))
+	if seq > l.last || l.last-seq > size {
+		return nil, false, l.next
+	}
+
+	entries := make([]Entry, 0, l.last-seq)
+	for n := seq + 1; n <= l.last; n++ {
+		entries = append(entries, l.entries[n%size])
+	}
+
+	return entries, true, l.next
+}
+
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+}
+
+func (l *Log) Done() <-chan struct{} {
+	return l.done
+}
@@ -0,0 +1,70 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func record(name string) fs.WALRecord {
+	return fs.WALRecord{Op: fs.WALDelete, Metric: metric.Metrics{ID: name}}
+}
+
+func seqs(entries []Entry) []uint64 {
+	result := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.Seq)
+	}
+
+	return result
+}
+
+func TestLog(t *testing.T) {
+	l := NewLog(3)
+
+	entries, ok, next := l.Since(0)
+	require.True(t, ok)
+	assert.Empty(t, entries)
+
+	require.Nil(t, l.Append(record("A"), record("B")))
+	select {
+	case <-next:
+	default:
+		t.Fatal("append must wake up waiting streams")
+	}
+
+	entries, ok, _ = l.Since(0)
+	require.True(t, ok)
+	assert.Equal(t, []uint64{1, 2}, seqs(entries))
+	assert.Equal(t, "B", entries[1].Metric.ID)
+
+	require.Nil(t, l.Append(record("C"), record("D")))
+	assert.Equal(t, uint64(4), l.Last())
+
+	_, ok, _ = l.Since(0)
+	assert.False(t, ok, "entry 1 is no longer kept")
+
+	entries, ok, _ = l.Since(1)
+	require.True(t, ok)
+	assert.Equal(t, []uint64{2, 3, 4}, seqs(entries))
+	assert.Equal(t, "D", entries[2].Metric.ID)
+
+	entries, ok, _ = l.Since(4)
+	require.True(t, ok)
+	assert.Empty(t, entries)
+
+	_, ok, _ = l.Since(5)
+	assert.False(t, ok, "position from another log")
+
+	l.Close()
+	l.Close()
+	select {
+	case <-l.Done():
+	default:
+		t.Fatal("closed log must be done")
+	}
+}
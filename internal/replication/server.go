@@ -0,0 +1,77 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+)
+
+const heartbeatInterval = 5 * time.Second
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// Serve streams log entries following from as newline-delimited JSON. A
+// replica of another log, or one that fell too far behind, gets a reset
+// entry first.
+func Serve(ctx context.Context, w http.ResponseWriter, l *Log, from Position, snapshot func() ([]fs.WALRecord, error)) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	seq := from.Seq
+	reset := from.Log != l.ID()
+	for {
+		entries, ok, next := l.Since(seq)
+		if reset || !ok {
+			// Taken before the snapshot: the entries replayed on top of it
+			// set final values, so the replica ends up in the same state.
+			seq = l.Last()
+			records, err := snapshot()
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(Entry{Seq: seq, WALRecord: fs.WALRecord{Op: OpReset}, Log: l.ID(), Snapshot: records}); err != nil {
+				return err
+			}
+			flusher.Flush()
+			reset = false
+			continue
+		}
+
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+			seq = e.Seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-next:
+		case <-heartbeat.C:
+			if err := enc.Encode(Entry{Seq: seq}); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-l.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -2,22 +2,45 @@ package storage
 
 import "strings"
 
-const metricsTable = `
-CREATE TABLE IF NOT EXISTS metrics (
-  id    VARCHAR(255),
-  m_type VARCHAR(255),
-  delta	BIGINT,
-  val   DOUBLE PRECISION,
-  PRIMARY KEY (id, m_type)
+const schemaMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    BIGINT PRIMARY KEY,
+  name       VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 `
 
+const selectSchemaVersions = `
+SELECT version
+FROM schema_migrations
+ORDER BY version
+`
+
+const insertSchemaVersion = `
+INSERT INTO schema_migrations
+	(version, name)
+	VALUES ($1, $2)
+`
+
+const deleteSchemaVersion = `
+DELETE FROM schema_migrations
+WHERE version = $1
+`
+
+const lockMigrations = `
+SELECT pg_advisory_lock($1)
+`
+
+const unlockMigrations = `
+SELECT pg_advisory_unlock($1)
+`
+
 const createOrUpdateGauge = `
 INSERT INTO metrics
 	(id, m_type, val)
 	VALUES ($1, $2, $3)
 	ON CONFLICT (id,m_type) 
-	DO UPDATE SET val=$3;
+	DO UPDATE SET val=$3, updated_at=now();
 `
 
 const createOrUpdateCounter = `
@@ -25,24 +48,135 @@ INSERT INTO metrics
 	(id, m_type, delta)
 	VALUES ($1, $2, $3)
 	ON CONFLICT (id,m_type)
-	DO UPDATE SET delta=(metrics.delta + ($3))
+	DO UPDATE SET delta=(metrics.delta + ($3)), updated_at=now()
 	RETURNING delta;
 `
 
+const bulkUpsertMetrics = `
+INSERT INTO metrics
+	(id, m_type, delta, val)
+	SELECT u.id, u.m_type,
+		CASE WHEN u.m_type = 'counter' THEN u.delta END,
+		CASE WHEN u.m_type = 'gauge' THEN u.val END
+	FROM unnest($1::text[], $2::text[], $3::bigint[], $4::double precision[]) AS u(id, m_type, delta, val)
+	ON CONFLICT (id,m_type)
+	DO UPDATE SET delta=(metrics.delta + EXCLUDED.delta), val=EXCLUDED.val, updated_at=now()
+	RETURNING id, m_type, delta, val;
+`
+
+const bulkSelectConflictingType = `
+SELECT m.id, m.m_type
+FROM metrics m
+JOIN unnest($1::text[], $2::text[]) AS u(id, m_type)
+  ON m.id = u.id AND m.m_type <> u.m_type
+LIMIT 1
+`
+
+const bulkDeleteConflictingType = `
+DELETE FROM metrics m
+USING unnest($1::text[], $2::text[]) AS u(id, m_type)
+WHERE m.id = u.id AND m.m_type <> u.m_type
+`
+
+const selectConflictingType = `
+SELECT m_type
+FROM metrics
+WHERE id = $1 AND m_type <> $2
+LIMIT 1
+`
+
 const selectMetric = `
 SELECT id,m_type,delta,val
 FROM metrics
 WHERE id = $1
+ORDER BY m_type
+LIMIT 1
+`
+
+const selectTypedMetric = `
+SELECT id,m_type,delta,val
+FROM metrics
+WHERE id = $1 AND m_type = $2
+`
+
+const deleteConflictingType = `
+DELETE FROM metrics
+WHERE id = $1 AND m_type <> $2
 `
 
 const selectMetrics = `
 SELECT id,m_type,delta,val
 FROM metrics
-LIMIT $1 OFFSET $2
+WHERE (id, m_type) > ($1::text, $2::text)
+  AND ($3::text = '' OR m_type = $3::text)
+  AND ($4::text = '' OR left(id, length($4::text)) = $4::text)
+  AND ($5::text = '' OR id ~ $5::text)
+ORDER BY id, m_type
+LIMIT $6::bigint
+`
+
+const deleteMetric = `
+DELETE FROM metrics
+WHERE id = $1
+RETURNING id
+`
+
+const deleteMatchingMetrics = `
+DELETE FROM metrics
+WHERE id ~ $1::text
+RETURNING id
+`
+
+const deleteStaleMetrics = `
+DELETE FROM metrics
+WHERE updated_at < $1
+RETURNING id
+`
+
+const selectMetadata = `
+SELECT id,unit,help,owner
+FROM metrics_metadata
+WHERE id = ANY($1)
+`
+
+const mergeMetadata = `
+INSERT INTO metrics_metadata
+	(id, unit, help, owner)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id)
+	DO UPDATE SET
+		unit=COALESCE(NULLIF(EXCLUDED.unit, ''), metrics_metadata.unit),
+		help=COALESCE(NULLIF(EXCLUDED.help, ''), metrics_metadata.help),
+		owner=COALESCE(NULLIF(EXCLUDED.owner, ''), metrics_metadata.owner);
 `
 
-func MetricsTable() string {
-	return strings.Trim(metricsTable, " ")
+const deleteMetadata = `
+DELETE FROM metrics_metadata
+WHERE id = $1
+`
+
+func SchemaMigrationsTable() string {
+	return strings.Trim(schemaMigrationsTable, " ")
+}
+
+func SelectSchemaVersions() string {
+	return strings.Trim(selectSchemaVersions, " ")
+}
+
+func InsertSchemaVersion() string {
+	return strings.Trim(insertSchemaVersion, " ")
+}
+
+func DeleteSchemaVersion() string {
+	return strings.Trim(deleteSchemaVersion, " ")
+}
+
+func LockMigrations() string {
+	return strings.Trim(lockMigrations, " ")
+}
+
+func UnlockMigrations() string {
+	return strings.Trim(unlockMigrations, " ")
 }
 
 func CreateOrUpdateGauge() string {
@@ -53,10 +187,58 @@ func CreateOrUpdateCounter() string {
 	return strings.Trim(createOrUpdateCounter, " ")
 }
 
+func BulkUpsertMetrics() string {
+	return strings.Trim(bulkUpsertMetrics, " ")
+}
+
+func BulkSelectConflictingType() string {
+	return strings.Trim(bulkSelectConflictingType, " ")
+}
+
+func BulkDeleteConflictingType() string {
+	return strings.Trim(bulkDeleteConflictingType, " ")
+}
+
+func SelectConflictingType() string {
+	return strings.Trim(selectConflictingType, " ")
+}
+
 func SelectMetric() string {
 	return strings.Trim(selectMetric, " ")
 }
 
+func SelectTypedMetric() string {
+	return strings.Trim(selectTypedMetric, " ")
+}
+
+func DeleteConflictingType() string {
+	return strings.Trim(deleteConflictingType, " ")
+}
+
 func SelectMetrics() string {
 	return strings.Trim(selectMetrics, " ")
 }
+
+func DeleteMetric() string {
+	return strings.Trim(deleteMetric, " ")
+}
+
+func DeleteMatchingMetrics() string {
+	return strings.Trim(deleteMatchingMetrics, " ")
+}
+
+func DeleteStaleMetrics() string {
+	return strings.Trim(deleteStaleMetrics, " ")
+}
+
+func SelectMetadata() string {
+	return strings.Trim(selectMetadata, " ")
+}
+
+func MergeMetadata() string {
+	return strings.Trim(mergeMetadata, " ")
+}
+
+func DeleteMetadata() string {
+	return strings.Trim(deleteMetadata, " ")
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const boltScheme = "bolt://"
+
+// IsBoltDSN reports whether dsn points NewStorage at a bolt file.
+func IsBoltDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, boltScheme)
+}
+
+var (
+	metricsBucket  = []byte("metrics")
+	metadataBucket = []byte("metadata")
+)
+
+type BoltStorage struct {
+	db     *bbolt.DB
+	policy ConflictPolicy
+	now    func() time.Time
+}
+
+type boltMetric struct {
+	Type    string    `json:"type"`
+	Delta   *int64    `json:"delta,omitempty"`
+	Value   *float64  `json:"value,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+func NewBoltStorage(path string, policy ConflictPolicy) (Storage, error) {
+	s, err := newBoltStorage(path, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func newBoltStorage(path string, policy ConflictPolicy) (*BoltStorage, error) {
+	db, err := bbolt.Open(path, 0664, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{metricsBucket, metadataBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{
+		db:     db,
+		policy: policy,
+		now:    time.Now,
+	}, nil
+}
+
+func (s *BoltStorage) Get(name string) (m metric.IMetric, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		for _, mType := range metricTypes {
+			if m, err = getBoltMetric(tx, metricKey{name, mType}); m != nil || err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return m, nil
+}
+
+func (s *BoltStorage) GetByType(name string, mType string) (m metric.IMetric, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		m, err = getBoltMetric(tx, metricKey{name, mType})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return m, nil
+}
+
+func (s *BoltStorage) Find(q Query) ([]metric.IMetric, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]metric.IMetric, 0)
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(metricsBucket).Cursor()
+		for k, v := c.Seek([]byte(q.After.Name)); k != nil; k, v = c.Next() {
+			m, err := decodeBoltMetric(k, v)
+			if err != nil {
+				return err
+			}
+			if !match(m) {
+				continue
+			}
+
+			result = append(result, m)
+			if len(result) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *BoltStorage) Update(m metric.IMetric) (metric.IMetric, error) {
+	result, err := s.BatchUpdate([]metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
+
+	return result[0], nil
+}
+
+func (s *BoltStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	result := make([]metric.IMetric, 0, len(sm))
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		result = result[:0]
+		for _, m := range sm {
+			updM, err := s.update(tx, m)
+			if err != nil {
+				return err
+			}
+			result = append(result, updM)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *BoltStorage) update(tx *bbolt.Tx, m metric.IMetric) (metric.IMetric, error) {
+	k := keyOf(m)
+	ok := metricKey{k.name, otherType(k.mType)}
+
+	current, err := getBoltMetric(tx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflict string
+	if tx.Bucket(metricsBucket).Get(boltKey(ok)) != nil {
+		conflict = ok.mType
+	}
+
+	if m, err = applyUpdate(s.policy, current, conflict, m); err != nil {
+		return nil, err
+	}
+
+	if conflict != "" && s.policy == ConflictLastWriterWins {
+		if err := tx.Bucket(metricsBucket).Delete(boltKey(ok)); err != nil {
+			return nil, err
+		}
+	}
+
+	mm, err := metric.NewMetricsFromIMetric(m)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := json.Marshal(boltMetric{
+		Type:    mm.MType,
+		Delta:   mm.Delta,
+		Value:   mm.Value,
+		Updated: s.now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Bucket(metricsBucket).Put(boltKey(k), v); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (s *BoltStorage) Delete(name string) error {
+	found := false
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(metricsBucket)
+		for _, mType := range metricTypes {
+			k := boltKey(metricKey{name, mType})
+			if b.Get(k) == nil {
+				continue
+			}
+			found = true
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) DeleteMatching(pattern string) ([]string, error) {
+	re, err := CompileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.deleteWhere(func(name string, _ boltMetric) bool {
+		return re.MatchString(name)
+	})
+}
+
+func (s *BoltStorage) DeleteStale(before time.Time) ([]string, error) {
+	return s.deleteWhere(func(_ string, bm boltMetric) bool {
+		return bm.Updated.Before(before)
+	})
+}
+
+func (s *BoltStorage) deleteWhere(fn func(name string, bm boltMetric) bool) ([]string, error) {
+	deleted := make([]string, 0)
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		deleted = deleted[:0]
+		b := tx.Bucket(metricsBucket)
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var bm boltMetric
+			if err := json.Unmarshal(v, &bm); err != nil {
+				return err
+			}
+			name := parseBoltKey(k).name
+			if fn(name, bm) {
+				keys = append(keys, append([]byte(nil), k...))
+				if len(deleted) == 0 || deleted[len(deleted)-1] != name {
+					deleted = append(deleted, name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+func (s *BoltStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	result := make(map[string]metric.Metadata, len(names))
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(metadataBucket)
+		for _, name := range names {
+			v := b.Get([]byte(name))
+			if v == nil {
+				continue
+			}
+
+			var md metric.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			result[name] = md
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *BoltStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(metadataBucket)
+		for name, md := range mds {
+			var current metric.Metadata
+			if v := b.Get([]byte(name)); v != nil {
+				if err := json.Unmarshal(v, &current); err != nil {
+					return err
+				}
+			}
+
+			v, err := json.Marshal(current.Merge(md))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) DeleteMetadata(name string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(metadataBucket).Delete([]byte(name))
+	})
+}
+
+func (s *BoltStorage) Ping(_ context.Context) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(metricsBucket) == nil {
+			return fmt.Errorf("bucket '%s' not found", metricsBucket)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func getBoltMetric(tx *bbolt.Tx, k metricKey) (metric.IMetric, error) {
+	v := tx.Bucket(metricsBucket).Get(boltKey(k))
+	if v == nil {
+		return nil, nil
+	}
+
+	return decodeBoltMetric(boltKey(k), v)
+}
+
+func decodeBoltMetric(k []byte, v []byte) (metric.IMetric, error) {
+	var bm boltMetric
+	if err := json.Unmarshal(v, &bm); err != nil {
+		return nil, err
+	}
+
+	name := parseBoltKey(k).name
+	switch {
+	case bm.Type == metric.CounterType && bm.Delta != nil:
+		return metric.NewCounterMetric(name, metric.Counter(*bm.Delta)), nil
+	case bm.Type == metric.GaugeType && bm.Value != nil:
+		return metric.NewGaugeMetric(name, metric.Gauge(*bm.Value)), nil
+	default:
+		return nil, fmt.Errorf("invalid metric type: %s", bm.Type)
+	}
+}
+
+// Keys are "name\x00type", so the bucket stays ordered by name and then type.
+func boltKey(k metricKey) []byte {
+	return []byte(k.name + "\x00" + k.mType)
+}
+
+func parseBoltKey(b []byte) metricKey {
+	name, mType, _ := strings.Cut(string(b), "\x00")
+
+	return metricKey{name, mType}
+}
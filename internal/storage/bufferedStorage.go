@@ -0,0 +1,589 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+// BufferedStorage stages writes and passes them to the wrapped storage in
+// batches. It must be the only writer of that storage: the counters it returns
+// are the last total it saw plus its own pending deltas, so writes made by
+// anyone else are not seen until the counter is deleted or the buffer is
+// recreated.
+type BufferedStorage struct {
+	storage  Storage
+	size     int
+	pending  map[metricKey]metric.IMetric
+	order    []metricKey
+	flushing map[metricKey]metric.IMetric
+	types    map[string]string
+	totals   map[metricKey]int64
+	failed   atomic.Uint64
+	done     chan struct{}
+	wg       sync.WaitGroup
+	writeMu  sync.Mutex
+	mu       sync.RWMutex
+}
+
+type BufferStats struct {
+	FailedFlushes uint64
+}
+
+func NewBufferedStorage(s Storage, size int, interval time.Duration) *BufferedStorage {
+	bs := &BufferedStorage{
+		storage: s,
+		size:    size,
+		pending: make(map[metricKey]metric.IMetric),
+		types:   make(map[string]string),
+		totals:  make(map[metricKey]int64),
+		done:    make(chan struct{}),
+	}
+
+	bs.wg.Add(1)
+	go bs.run(interval)
+
+	return bs
+}
+
+func (s *BufferedStorage) Unwrap() Storage {
+	return s.storage
+}
+
+func (s *BufferedStorage) Stats() BufferStats {
+	return BufferStats{
+		FailedFlushes: s.failed.Load(),
+	}
+}
+
+func (s *BufferedStorage) Get(name string) (metric.IMetric, error) {
+	return s.GetContext(context.Background(), name)
+}
+
+func (s *BufferedStorage) GetContext(ctx context.Context, name string) (metric.IMetric, error) {
+	ck := metricKey{name, metric.CounterType}
+	if m, ok := s.buffered(ck); ok {
+		return m, nil
+	}
+
+	m, err := GetContext(ctx, s.storage, name)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.has(ck) {
+		return s.current(ck), nil
+	}
+	gk := metricKey{name, metric.GaugeType}
+	if s.has(gk) && (err != nil || m.Type() == metric.GaugeType) {
+		return s.current(gk), nil
+	}
+
+	return m, err
+}
+
+func (s *BufferedStorage) GetByType(name string, mType string) (metric.IMetric, error) {
+	return s.GetByTypeContext(context.Background(), name, mType)
+}
+
+func (s *BufferedStorage) GetByTypeContext(ctx context.Context, name string, mType string) (metric.IMetric, error) {
+	if m, ok := s.buffered(metricKey{name, mType}); ok {
+		return m, nil
+	}
+
+	return GetByTypeContext(ctx, s.storage, name, mType)
+}
+
+func (s *BufferedStorage) Find(q Query) ([]metric.IMetric, error) {
+	return s.FindContext(context.Background(), q)
+}
+
+// FindContext overlays buffered writes on the first page of the wrapped
+// storage. Every metric that belongs on the page is either stored or
+// buffered, so the merged page cut to the limit is exact.
+func (s *BufferedStorage) FindContext(ctx context.Context, q Query) ([]metric.IMetric, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	ms, err := FindContext(ctx, s.storage, q)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[metricKey]int, len(ms))
+	for i, m := range ms {
+		index[keyOf(m)] = i
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	overlay := func(k metricKey) {
+		m := s.current(k)
+		if !match(m) {
+			return
+		}
+		if i, ok := index[k]; ok {
+			ms[i] = m
+			return
+		}
+		index[k] = len(ms)
+		ms = append(ms, m)
+	}
+	for k := range s.flushing {
+		overlay(k)
+	}
+	for _, k := range s.order {
+		overlay(k)
+	}
+
+	sort.Slice(ms, func(i, j int) bool {
+		return CursorOf(ms[i]).Less(ms[j])
+	})
+	if q.Limit > 0 && len(ms) > q.Limit {
+		ms = ms[:q.Limit]
+	}
+
+	return ms, nil
+}
+
+func (s *BufferedStorage) Update(m metric.IMetric) (metric.IMetric, error) {
+	return s.UpdateContext(context.Background(), m)
+}
+
+func (s *BufferedStorage) UpdateContext(ctx context.Context, m metric.IMetric) (metric.IMetric, error) {
+	result, err := s.BatchUpdateContext(ctx, []metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
+
+	return result[0], nil
+}
+
+func (s *BufferedStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	return s.BatchUpdateContext(context.Background(), sm)
+}
+
+func (s *BufferedStorage) BatchUpdateContext(ctx context.Context, sm []metric.IMetric) ([]metric.IMetric, error) {
+	if err := s.lookupTypes(ctx, sm); err != nil {
+		return nil, err
+	}
+
+	if s.full() {
+		if err := s.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	// Type changes are left to the conflict policy of the wrapped storage.
+	if s.changesType(sm) {
+		s.mu.Unlock()
+		return s.writeThrough(ctx, sm)
+	}
+	defer s.mu.Unlock()
+
+	result := make([]metric.IMetric, 0, len(sm))
+	for _, m := range sm {
+		k := keyOf(m)
+		s.stage(k, m)
+		result = append(result, s.current(k))
+	}
+
+	return result, nil
+}
+
+func (s *BufferedStorage) Delete(name string) error {
+	return s.DeleteContext(context.Background(), name)
+}
+
+func (s *BufferedStorage) DeleteContext(ctx context.Context, name string) error {
+	_, err := s.deleteWith(ctx, func() ([]string, error) {
+		return []string{name}, DeleteContext(ctx, s.storage, name)
+	})
+
+	return err
+}
+
+func (s *BufferedStorage) DeleteMatching(pattern string) ([]string, error) {
+	return s.DeleteMatchingContext(context.Background(), pattern)
+}
+
+func (s *BufferedStorage) DeleteMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	return s.deleteWith(ctx, func() ([]string, error) {
+		return DeleteMatchingContext(ctx, s.storage, pattern)
+	})
+}
+
+func (s *BufferedStorage) DeleteStale(before time.Time) ([]string, error) {
+	return s.DeleteStaleContext(context.Background(), before)
+}
+
+func (s *BufferedStorage) DeleteStaleContext(ctx context.Context, before time.Time) ([]string, error) {
+	return s.deleteWith(ctx, func() ([]string, error) {
+		return DeleteStaleContext(ctx, s.storage, before)
+	})
+}
+
+func (s *BufferedStorage) deleteWith(ctx context.Context, fn func() ([]string, error)) ([]string, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.flushLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	deleted, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range deleted {
+		s.forget(name)
+	}
+
+	return deleted, nil
+}
+
+func (s *BufferedStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	return s.storage.FindMetadata(names)
+}
+
+func (s *BufferedStorage) FindMetadataContext(ctx context.Context, names []string) (map[string]metric.Metadata, error) {
+	return FindMetadataContext(ctx, s.storage, names)
+}
+
+func (s *BufferedStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	return s.storage.SetMetadata(mds)
+}
+
+func (s *BufferedStorage) SetMetadataContext(ctx context.Context, mds map[string]metric.Metadata) error {
+	return SetMetadataContext(ctx, s.storage, mds)
+}
+
+func (s *BufferedStorage) DeleteMetadata(name string) error {
+	return s.storage.DeleteMetadata(name)
+}
+
+func (s *BufferedStorage) DeleteMetadataContext(ctx context.Context, name string) error {
+	return DeleteMetadataContext(ctx, s.storage, name)
+}
+
+func (s *BufferedStorage) Ping(ctx context.Context) error {
+	if p, ok := s.storage.(pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (s *BufferedStorage) Flush() error {
+	return s.flush(context.Background())
+}
+
+func (s *BufferedStorage) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	if c, ok := s.storage.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (s *BufferedStorage) run(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// A failed flush keeps the writes pending for the next tick.
+			if err := s.Flush(); err != nil {
+				s.failed.Add(1)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// writeThrough passes a batch straight to the wrapped storage after the
+// writes buffered before it.
+func (s *BufferedStorage) writeThrough(ctx context.Context, sm []metric.IMetric) ([]metric.IMetric, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.flushLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	result, err := BatchUpdateContext(ctx, s.storage, sm)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.learn(result...)
+	s.mu.Unlock()
+
+	return result, nil
+}
+
+func (s *BufferedStorage) flush(ctx context.Context) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.flushLocked(ctx)
+}
+
+// flushLocked writes the pending metrics without holding the buffer lock, so
+// reads don't wait for the wrapped storage. Until the batch is written, reads
+// still find it in flushing. Must be called with writeMu held.
+func (s *BufferedStorage) flushLocked(ctx context.Context) error {
+	s.mu.Lock()
+	order := s.order
+	if len(order) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	batch := make([]metric.IMetric, 0, len(order))
+	for _, k := range order {
+		batch = append(batch, s.pending[k])
+	}
+	s.flushing, s.pending, s.order = s.pending, make(map[metricKey]metric.IMetric), nil
+	s.mu.Unlock()
+
+	result, err := BatchUpdateContext(ctx, s.storage, batch)
+	if errors.Is(err, ErrTypeConflict) {
+		// Another writer changed a type behind the buffer.
+		return s.flushEach(ctx, order)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.requeue(order)
+		return err
+	}
+
+	s.learn(result...)
+	s.flushing = nil
+
+	return nil
+}
+
+// flushEach writes the flushing metrics one by one and drops the ones the
+// wrapped storage rejects, so a single conflict can't wedge the whole buffer.
+func (s *BufferedStorage) flushEach(ctx context.Context, order []metricKey) error {
+	for i, k := range order {
+		m, err := UpdateContext(ctx, s.storage, s.flushing[k])
+
+		s.mu.Lock()
+		switch {
+		case errors.Is(err, ErrTypeConflict):
+			s.forget(k.name)
+		case err != nil:
+			s.requeue(order[i:])
+			s.mu.Unlock()
+			return err
+		default:
+			s.learn(m)
+		}
+		delete(s.flushing, k)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.flushing = nil
+	s.mu.Unlock()
+
+	return nil
+}
+
+// requeue puts the part of a batch that failed to flush back in front of the
+// writes staged meanwhile.
+func (s *BufferedStorage) requeue(order []metricKey) {
+	pending, staged := s.pending, s.order
+	s.pending, s.order, s.flushing = s.flushing, order, nil
+
+	for _, k := range staged {
+		s.stage(k, pending[k])
+	}
+}
+
+// lookupTypes checks the batch and learns the types of names the buffer has
+// not seen yet from the wrapped storage. Names are looked up once.
+func (s *BufferedStorage) lookupTypes(ctx context.Context, sm []metric.IMetric) error {
+	unknown := make(map[string]bool)
+
+	s.mu.RLock()
+	for _, m := range sm {
+		if m.Type() != metric.CounterType && m.Type() != metric.GaugeType {
+			s.mu.RUnlock()
+			return fmt.Errorf("invalid metric type: %s", m.Type())
+		}
+		if _, ok := s.types[m.Name()]; !ok {
+			unknown[m.Name()] = true
+		}
+	}
+	s.mu.RUnlock()
+
+	for name := range unknown {
+		stored, err := GetContext(ctx, s.storage, name)
+		if errors.Is(err, ErrMetricNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if _, ok := s.types[name]; !ok {
+			s.learn(stored)
+		}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// changesType reports whether the batch writes a name under a type other than
+// the one last seen for it.
+func (s *BufferedStorage) changesType(sm []metric.IMetric) bool {
+	seen := make(map[string]string, len(sm))
+	for _, m := range sm {
+		mType, ok := seen[m.Name()]
+		if !ok {
+			mType = s.types[m.Name()]
+		}
+		if mType != "" && mType != m.Type() {
+			return true
+		}
+		seen[m.Name()] = m.Type()
+	}
+
+	return false
+}
+
+func (s *BufferedStorage) full() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.order) >= s.size
+}
+
+func (s *BufferedStorage) stage(k metricKey, m metric.IMetric) {
+	s.types[k.name] = k.mType
+
+	current, ok := s.pending[k]
+	if !ok {
+		s.order = append(s.order, k)
+		s.pending[k] = m
+		return
+	}
+
+	if k.mType == metric.CounterType {
+		m = metric.NewCounterMetric(k.name, metric.Counter(counterValue(current)+counterValue(m)))
+	}
+	s.pending[k] = m
+}
+
+func (s *BufferedStorage) buffered(k metricKey) (metric.IMetric, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.has(k) {
+		return nil, false
+	}
+
+	return s.current(k), true
+}
+
+func (s *BufferedStorage) has(k metricKey) bool {
+	_, pending := s.pending[k]
+	_, flushing := s.flushing[k]
+
+	return pending || flushing
+}
+
+// current is the value of a buffered metric: the newest gauge, or the last
+// counter total seen plus the deltas not written yet.
+func (s *BufferedStorage) current(k metricKey) metric.IMetric {
+	m, pending := s.pending[k]
+	inflight, flushing := s.flushing[k]
+	if k.mType != metric.CounterType {
+		if pending {
+			return m
+		}
+		return inflight
+	}
+
+	total := s.totals[k]
+	if pending {
+		total += counterValue(m)
+	}
+	if flushing {
+		total += counterValue(inflight)
+	}
+
+	return metric.NewCounterMetric(k.name, metric.Counter(total))
+}
+
+func (s *BufferedStorage) learn(ms ...metric.IMetric) {
+	for _, m := range ms {
+		s.types[m.Name()] = m.Type()
+		if m.Type() == metric.CounterType {
+			s.totals[keyOf(m)] = counterValue(m)
+		}
+	}
+}
+
+func (s *BufferedStorage) forget(name string) {
+	delete(s.types, name)
+	for _, mType := range metricTypes {
+		delete(s.totals, metricKey{name, mType})
+	}
+}
+
+func counterValue(m metric.IMetric) int64 {
+	v, _ := strconv.ParseInt(m.ValueAsString(), 10, 64)
+
+	return v
+}
+
+func BufferStatsOf(s Storage) (BufferStats, bool) {
+	for {
+		if b, ok := s.(*BufferedStorage); ok {
+			return b.Stats(), true
+		}
+
+		w, ok := s.(wrapper)
+		if !ok {
+			return BufferStats{}, false
+		}
+		s = w.Unwrap()
+	}
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type unreachableStorage struct {
+	Storage
+}
+
+func (unreachableStorage) BatchUpdate([]metric.IMetric) ([]metric.IMetric, error) {
+	return nil, errors.New("connection refused")
+}
+
+// gatedStorage holds each batch write until it is released and then fails it.
+type gatedStorage struct {
+	Storage
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s gatedStorage) BatchUpdate([]metric.IMetric) ([]metric.IMetric, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return nil, errors.New("connection reset")
+}
+
+func TestBufferedStorage(t *testing.T) {
+	t.Run("Writes are coalesced until flush", func(t *testing.T) {
+		mem := newMemStorage()
+		_, err := mem.Update(metric.NewCounterMetric("PollCount", 10))
+		require.Nil(t, err)
+
+		s := NewBufferedStorage(mem, 100, time.Hour)
+		defer s.Close()
+
+		for i := 0; i < 3; i++ {
+			result, err := s.BatchUpdate([]metric.IMetric{
+				metric.NewCounterMetric("PollCount", 2),
+				metric.NewGaugeMetric("Alloc", metric.Gauge(i)),
+			})
+			require.Nil(t, err)
+			assert.Equal(t, metric.NewCounterMetric("PollCount", metric.Counter(12+2*i)), result[0])
+		}
+
+		stored, err := mem.Find(Query{})
+		require.Nil(t, err)
+		assert.Equal(t, []metric.IMetric{metric.NewCounterMetric("PollCount", 10)}, stored)
+
+		got, err := s.Get("PollCount")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 16), got)
+
+		got, err = s.GetByType("Alloc", metric.GaugeType)
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewGaugeMetric("Alloc", 2), got)
+
+		require.Nil(t, s.Flush())
+
+		stored, err = mem.Find(Query{})
+		require.Nil(t, err)
+		assert.Equal(t, []metric.IMetric{
+			metric.NewGaugeMetric("Alloc", 2),
+			metric.NewCounterMetric("PollCount", 16),
+		}, stored)
+	})
+
+	t.Run("Find merges pending writes into pages", func(t *testing.T) {
+		mem := newMemStorage()
+		for _, name := range []string{"A", "C", "E"} {
+			_, err := mem.Update(metric.NewGaugeMetric(name, 1))
+			require.Nil(t, err)
+		}
+
+		s := NewBufferedStorage(mem, 100, time.Hour)
+		defer s.Close()
+
+		_, err := s.BatchUpdate([]metric.IMetric{
+			metric.NewGaugeMetric("B", 2),
+			metric.NewGaugeMetric("C", 2),
+			metric.NewGaugeMetric("D", 2),
+		})
+		require.Nil(t, err)
+
+		var pages [][]string
+		q := Query{Limit: 2}
+		for {
+			ms, err := s.Find(q)
+			require.Nil(t, err)
+			if len(ms) == 0 {
+				break
+			}
+
+			var page []string
+			for _, m := range ms {
+				page = append(page, m.Name()+"="+m.ValueAsString())
+			}
+			pages = append(pages, page)
+			q.After = CursorOf(ms[len(ms)-1])
+		}
+		assert.Equal(t, [][]string{{"A=1", "B=2"}, {"C=2", "D=2"}, {"E=1"}}, pages)
+	})
+
+	t.Run("Full buffer is flushed", func(t *testing.T) {
+		mem := newMemStorage()
+		s := NewBufferedStorage(mem, 2, time.Hour)
+		defer s.Close()
+
+		for i := 0; i < 3; i++ {
+			_, err := s.Update(metric.NewGaugeMetric(fmt.Sprintf("Gauge%d", i), 1))
+			require.Nil(t, err)
+		}
+
+		stored, err := mem.Find(Query{})
+		require.Nil(t, err)
+		assert.Len(t, stored, 2)
+	})
+
+	t.Run("Interval flushes the buffer", func(t *testing.T) {
+		mem := newMemStorage()
+		s := NewBufferedStorage(mem, 100, 10*time.Millisecond)
+		defer s.Close()
+
+		_, err := s.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			_, err := mem.Get("Alloc")
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Type conflicts are reported on write", func(t *testing.T) {
+		mem := newMemStorage()
+		s := NewBufferedStorage(mem, 100, time.Hour)
+		defer s.Close()
+
+		_, err := s.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+
+		_, err = s.Update(metric.NewCounterMetric("Alloc", 1))
+		assert.ErrorIs(t, err, ErrTypeConflict)
+
+		_, err = s.BatchUpdate([]metric.IMetric{
+			metric.NewGaugeMetric("HeapIdle", 1),
+			metric.NewCounterMetric("HeapIdle", 1),
+		})
+		assert.ErrorIs(t, err, ErrTypeConflict)
+
+		_, err = s.Get("HeapIdle")
+		assert.ErrorIs(t, err, ErrMetricNotFound)
+	})
+
+	t.Run("Pending metrics can be deleted", func(t *testing.T) {
+		mem := newMemStorage()
+		s := NewBufferedStorage(mem, 100, time.Hour)
+
+		_, err := s.BatchUpdate([]metric.IMetric{
+			metric.NewGaugeMetric("Alloc", 1),
+			metric.NewCounterMetric("PollCount", 5),
+		})
+		require.Nil(t, err)
+
+		require.Nil(t, s.Delete("Alloc"))
+		_, err = s.Get("Alloc")
+		assert.ErrorIs(t, err, ErrMetricNotFound)
+
+		_, err = s.Update(metric.NewCounterMetric("PollCount", 1))
+		require.Nil(t, err)
+		require.Nil(t, s.Close())
+
+		got, err := mem.Get("PollCount")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 6), got)
+	})
+	t.Run("Failed interval flushes are counted", func(t *testing.T) {
+		s := NewBufferedStorage(unreachableStorage{newMemStorage()}, 100, 10*time.Millisecond)
+
+		_, err := s.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			return s.Stats().FailedFlushes >= 2
+		}, time.Second, 10*time.Millisecond)
+
+		stats, ok := BufferStatsOf(NewFeedStorage(s, &recordingFeed{}))
+		assert.True(t, ok)
+		assert.GreaterOrEqual(t, stats.FailedFlushes, uint64(2))
+
+		got, err := s.Get("Alloc")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewGaugeMetric("Alloc", 1), got, "failed writes stay pending")
+		assert.Error(t, s.Close())
+	})
+
+	t.Run("Reads and writes go on during a flush", func(t *testing.T) {
+		gate := gatedStorage{newMemStorage(), make(chan struct{}), make(chan struct{})}
+		s := NewBufferedStorage(gate, 100, time.Hour)
+		assert.Implements(t, (*ContextStorage)(nil), s)
+
+		_, err := s.BatchUpdate([]metric.IMetric{
+			metric.NewCounterMetric("PollCount", 2),
+			metric.NewGaugeMetric("Alloc", 1),
+		})
+		require.Nil(t, err)
+
+		flushed := make(chan error)
+		go func() {
+			flushed <- s.Flush()
+		}()
+		<-gate.started
+
+		got, err := s.Get("PollCount")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 2), got)
+
+		result, err := s.BatchUpdate([]metric.IMetric{
+			metric.NewCounterMetric("PollCount", 3),
+			metric.NewGaugeMetric("Alloc", 2),
+		})
+		require.Nil(t, err)
+		assert.Equal(t, []metric.IMetric{
+			metric.NewCounterMetric("PollCount", 5),
+			metric.NewGaugeMetric("Alloc", 2),
+		}, result)
+
+		close(gate.release)
+		assert.Error(t, <-flushed)
+
+		found, err := s.Find(Query{})
+		require.Nil(t, err)
+		assert.Equal(t, []metric.IMetric{
+			metric.NewGaugeMetric("Alloc", 2),
+			metric.NewCounterMetric("PollCount", 5),
+		}, found, "a failed batch is merged with the writes staged meanwhile")
+		assert.Len(t, s.order, 2)
+	})
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type CachedStorage struct {
+	storage Storage
+	cache   *lru.Cache
+	ttl     time.Duration
+	now     func() time.Time
+	epoch   atomic.Uint64
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	mu      sync.Mutex
+}
+
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+type cacheEntry struct {
+	m       metric.IMetric
+	expires time.Time
+}
+
+func NewCachedStorage(s Storage, size int, ttl time.Duration) (*CachedStorage, error) {
+	c, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachedStorage{
+		storage: s,
+		cache:   c,
+		ttl:     ttl,
+		now:     time.Now,
+	}, nil
+}
+
+func (s *CachedStorage) Unwrap() Storage {
+	return s.storage
+}
+
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+		Size:   s.cache.Len(),
+	}
+}
+
+// Get caches the metric under an empty type, since it may be either one.
+func (s *CachedStorage) Get(name string) (metric.IMetric, error) {
+	return s.GetContext(context.Background(), name)
+}
+
+func (s *CachedStorage) GetContext(ctx context.Context, name string) (metric.IMetric, error) {
+	return s.through(metricKey{name, ""}, func() (metric.IMetric, error) {
+		return GetContext(ctx, s.storage, name)
+	})
+}
+
+func (s *CachedStorage) GetByType(name string, mType string) (metric.IMetric, error) {
+	return s.GetByTypeContext(context.Background(), name, mType)
+}
+
+func (s *CachedStorage) GetByTypeContext(ctx context.Context, name string, mType string) (metric.IMetric, error) {
+	return s.through(metricKey{name, mType}, func() (metric.IMetric, error) {
+		return GetByTypeContext(ctx, s.storage, name, mType)
+	})
+}
+
+func (s *CachedStorage) Find(q Query) ([]metric.IMetric, error) {
+	return s.storage.Find(q)
+}
+
+func (s *CachedStorage) FindContext(ctx context.Context, q Query) ([]metric.IMetric, error) {
+	return FindContext(ctx, s.storage, q)
+}
+
+func (s *CachedStorage) Update(m metric.IMetric) (metric.IMetric, error) {
+	return s.UpdateContext(context.Background(), m)
+}
+
+func (s *CachedStorage) UpdateContext(ctx context.Context, m metric.IMetric) (metric.IMetric, error) {
+	result, err := s.BatchUpdateContext(ctx, []metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
+
+	return result[0], nil
+}
+
+func (s *CachedStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	return s.BatchUpdateContext(context.Background(), sm)
+}
+
+func (s *CachedStorage) BatchUpdateContext(ctx context.Context, sm []metric.IMetric) ([]metric.IMetric, error) {
+	defer s.invalidate(namesOf(sm)...)
+
+	return BatchUpdateContext(ctx, s.storage, sm)
+}
+
+func (s *CachedStorage) Delete(name string) error {
+	return s.DeleteContext(context.Background(), name)
+}
+
+func (s *CachedStorage) DeleteContext(ctx context.Context, name string) error {
+	defer s.invalidate(name)
+
+	return DeleteContext(ctx, s.storage, name)
+}
+
+func (s *CachedStorage) DeleteMatching(pattern string) ([]string, error) {
+	return s.DeleteMatchingContext(context.Background(), pattern)
+}
+
+func (s *CachedStorage) DeleteMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	deleted, err := DeleteMatchingContext(ctx, s.storage, pattern)
+	s.invalidate(deleted...)
+
+	return deleted, err
+}
+
+func (s *CachedStorage) DeleteStale(before time.Time) ([]string, error) {
+	return s.DeleteStaleContext(context.Background(), before)
+}
+
+func (s *CachedStorage) DeleteStaleContext(ctx context.Context, before time.Time) ([]string, error) {
+	deleted, err := DeleteStaleContext(ctx, s.storage, before)
+	s.invalidate(deleted...)
+
+	return deleted, err
+}
+
+func (s *CachedStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	return s.storage.FindMetadata(names)
+}
+
+func (s *CachedStorage) FindMetadataContext(ctx context.Context, names []string) (map[string]metric.Metadata, error) {
+	return FindMetadataContext(ctx, s.storage, names)
+}
+
+func (s *CachedStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	return s.storage.SetMetadata(mds)
+}
+
+func (s *CachedStorage) SetMetadataContext(ctx context.Context, mds map[string]metric.Metadata) error {
+	return SetMetadataContext(ctx, s.storage, mds)
+}
+
+func (s *CachedStorage) DeleteMetadata(name string) error {
+	return s.storage.DeleteMetadata(name)
+}
+
+func (s *CachedStorage) DeleteMetadataContext(ctx context.Context, name string) error {
+	return DeleteMetadataContext(ctx, s.storage, name)
+}
+
+func (s *CachedStorage) Ping(ctx context.Context) error {
+	if p, ok := s.storage.(pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (s *CachedStorage) Close() error {
+	s.cache.Purge()
+
+	if c, ok := s.storage.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// through serves the metric from the cache or loads it from the storage.
+// Writes of other replicas are not seen here, so entries live for ttl only.
+// A load that raced with a local write is returned but not cached.
+func (s *CachedStorage) through(k metricKey, load func() (metric.IMetric, error)) (metric.IMetric, error) {
+	if v, ok := s.cache.Get(k); ok {
+		e := v.(cacheEntry)
+		if s.now().Before(e.expires) {
+			s.hits.Add(1)
+			return e.m, nil
+		}
+		s.cache.Remove(k)
+	}
+	s.misses.Add(1)
+
+	epoch := s.epoch.Load()
+	m, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.epoch.Load() == epoch {
+		s.cache.Add(k, cacheEntry{m: m, expires: s.now().Add(s.ttl)})
+	}
+	s.mu.Unlock()
+
+	return m, nil
+}
+
+func (s *CachedStorage) invalidate(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch.Add(1)
+	for _, name := range names {
+		s.cache.Remove(metricKey{name, ""})
+		for _, mType := range metricTypes {
+			s.cache.Remove(metricKey{name, mType})
+		}
+	}
+}
+
+func namesOf(sm []metric.IMetric) []string {
+	names := make([]string, 0, len(sm))
+	for _, m := range sm {
+		names = append(names, m.Name())
+	}
+
+	return names
+}
+
+func CacheStatsOf(s Storage) (CacheStats, bool) {
+	for {
+		if c, ok := s.(*CachedStorage); ok {
+			return c.Stats(), true
+		}
+
+		w, ok := s.(wrapper)
+		if !ok {
+			return CacheStats{}, false
+		}
+		s = w.Unwrap()
+	}
+}
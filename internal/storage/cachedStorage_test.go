@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestCachedStorage(t *testing.T) {
+	t.Run("Reads are served from the cache", func(t *testing.T) {
+		mem := newMemStorage()
+		s, err := NewCachedStorage(mem, 10, time.Minute)
+		require.Nil(t, err)
+
+		_, err = s.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+
+		for i := 0; i < 3; i++ {
+			m, err := s.GetByType("Alloc", metric.GaugeType)
+			require.Nil(t, err)
+			assert.Equal(t, metric.NewGaugeMetric("Alloc", 1), m)
+		}
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Size: 1}, s.Stats())
+
+		// Writes that bypass the cache stay invisible until the entry expires.
+		_, err = mem.Update(metric.NewGaugeMetric("Alloc", 2))
+		require.Nil(t, err)
+		m, err := s.GetByType("Alloc", metric.GaugeType)
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewGaugeMetric("Alloc", 1), m)
+	})
+
+	t.Run("Entries expire after ttl", func(t *testing.T) {
+		now := time.Now()
+		mem := newMemStorage()
+		s, err := NewCachedStorage(mem, 10, time.Second)
+		require.Nil(t, err)
+		s.now = func() time.Time { return now }
+
+		_, err = mem.Update(metric.NewCounterMetric("PollCount", 1))
+		require.Nil(t, err)
+		_, err = s.Get("PollCount")
+		require.Nil(t, err)
+
+		_, err = mem.Update(metric.NewCounterMetric("PollCount", 1))
+		require.Nil(t, err)
+		now = now.Add(time.Second)
+
+		m, err := s.Get("PollCount")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 2), m)
+		assert.Equal(t, CacheStats{Hits: 0, Misses: 2, Size: 1}, s.Stats())
+	})
+
+	t.Run("Writes invalidate cached metrics", func(t *testing.T) {
+		s, err := NewCachedStorage(newMemStorage(), 10, time.Minute)
+		require.Nil(t, err)
+
+		_, err = s.Update(metric.NewCounterMetric("PollCount", 1))
+		require.Nil(t, err)
+		_, err = s.Get("PollCount")
+		require.Nil(t, err)
+		_, err = s.GetByType("PollCount", metric.CounterType)
+		require.Nil(t, err)
+
+		_, err = s.BatchUpdate([]metric.IMetric{metric.NewCounterMetric("PollCount", 2)})
+		require.Nil(t, err)
+		m, err := s.Get("PollCount")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 3), m)
+		m, err = s.GetByType("PollCount", metric.CounterType)
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("PollCount", 3), m)
+
+		require.Nil(t, s.Delete("PollCount"))
+		_, err = s.Get("PollCount")
+		assert.ErrorIs(t, err, ErrMetricNotFound)
+	})
+
+	t.Run("Missing metrics are not cached", func(t *testing.T) {
+		mem := newMemStorage()
+		s, err := NewCachedStorage(mem, 10, time.Minute)
+		require.Nil(t, err)
+
+		_, err = s.Get("Alloc")
+		assert.ErrorIs(t, err, ErrMetricNotFound)
+
+		_, err = mem.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+		_, err = s.Get("Alloc")
+		require.Nil(t, err)
+	})
+
+	t.Run("Cache is bounded", func(t *testing.T) {
+		s, err := NewCachedStorage(newMemStorage(), 2, time.Minute)
+		require.Nil(t, err)
+
+		for _, name := range []string{"A", "B", "C"} {
+			_, err := s.Update(metric.NewGaugeMetric(name, 1))
+			require.Nil(t, err)
+			_, err = s.Get(name)
+			require.Nil(t, err)
+		}
+		assert.Equal(t, 2, s.Stats().Size)
+
+		bs := NewBufferedStorage(s, 10, time.Hour)
+		defer bs.Close()
+
+		stats, ok := CacheStatsOf(bs)
+		assert.True(t, ok)
+		assert.Equal(t, s.Stats(), stats)
+	})
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type backend struct {
+	name string
+	open func(t *testing.T, policy ConflictPolicy) Storage
+}
+
+func backends() []backend {
+	bs := []backend{
+		{
+			name: "mem",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				s := newMemStorage()
+				s.policy = policy
+				return s
+			},
+		},
+		{
+			name: "sharded",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				s := newShardedMemStorage(8)
+				s.policy = policy
+				return s
+			},
+		},
+		{
+			name: "buffered",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				mem := newMemStorage()
+				mem.policy = policy
+				s := NewBufferedStorage(mem, 1000, time.Hour)
+				t.Cleanup(func() {
+					s.Close()
+				})
+				return s
+			},
+		},
+		{
+			name: "cached",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				mem := newMemStorage()
+				mem.policy = policy
+				s, err := NewCachedStorage(mem, 1000, time.Minute)
+				require.Nil(t, err)
+				return s
+			},
+		},
+		{
+			name: "bolt",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				s, err := NewBoltStorage(filepath.Join(t.TempDir(), "sysmon.db"), policy)
+				require.Nil(t, err)
+				t.Cleanup(func() {
+					s.(*BoltStorage).Close()
+				})
+				return s
+			},
+		},
+	}
+
+	if dsn := os.Getenv("TEST_DATABASE_DSN"); dsn != "" {
+		bs = append(bs, backend{
+			name: "postgres",
+			open: func(t *testing.T, policy ConflictPolicy) Storage {
+				s, err := NewDBStorage("pgx", dsn, policy, DBOptions{})
+				require.Nil(t, err)
+				db := s.(DBStorage)
+				_, err = db.sql.Exec("TRUNCATE metrics, metrics_metadata")
+				require.Nil(t, err)
+				t.Cleanup(func() {
+					db.Close()
+				})
+				return s
+			},
+		})
+	}
+
+	return bs
+}
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ConflictPolicy
+		before  []metric.IMetric
+		batch   []metric.IMetric
+		want    []metric.IMetric
+		wantErr error
+		stored  []metric.IMetric
+	}{
+		{
+			name:   "Counters accumulate across and within batches",
+			before: []metric.IMetric{metric.NewCounterMetric("PollCount", 2)},
+			batch: []metric.IMetric{
+				metric.NewCounterMetric("PollCount", 3),
+				metric.NewCounterMetric("PollCount", 5),
+			},
+			want: []metric.IMetric{
+				metric.NewCounterMetric("PollCount", 5),
+				metric.NewCounterMetric("PollCount", 10),
+			},
+			stored: []metric.IMetric{metric.NewCounterMetric("PollCount", 10)},
+		},
+		{
+			name:   "Gauges keep the last value",
+			before: []metric.IMetric{metric.NewGaugeMetric("Alloc", 1)},
+			batch: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 2.5),
+				metric.NewGaugeMetric("HeapIdle", 3),
+				metric.NewGaugeMetric("Alloc", 4.5),
+			},
+			want: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 2.5),
+				metric.NewGaugeMetric("HeapIdle", 3),
+				metric.NewGaugeMetric("Alloc", 4.5),
+			},
+			stored: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 4.5),
+				metric.NewGaugeMetric("HeapIdle", 3),
+			},
+		},
+		{
+			name:    "Counter over a gauge is a type conflict",
+			before:  []metric.IMetric{metric.NewGaugeMetric("Alloc", 1)},
+			batch:   []metric.IMetric{metric.NewCounterMetric("Alloc", 1)},
+			wantErr: ErrTypeConflict,
+			stored:  []metric.IMetric{metric.NewGaugeMetric("Alloc", 1)},
+		},
+		{
+			name:    "Gauge over a counter is a type conflict",
+			before:  []metric.IMetric{metric.NewCounterMetric("PollCount", 1)},
+			batch:   []metric.IMetric{metric.NewGaugeMetric("PollCount", 1)},
+			wantErr: ErrTypeConflict,
+			stored:  []metric.IMetric{metric.NewCounterMetric("PollCount", 1)},
+		},
+		{
+			name:   "Failed batch applies nothing",
+			before: []metric.IMetric{metric.NewCounterMetric("PollCount", 1), metric.NewGaugeMetric("Alloc", 1)},
+			batch: []metric.IMetric{
+				metric.NewCounterMetric("PollCount", 5),
+				metric.NewGaugeMetric("HeapIdle", 3),
+				metric.NewCounterMetric("Alloc", 7),
+				metric.NewGaugeMetric("HeapAlloc", 4),
+			},
+			wantErr: ErrTypeConflict,
+			stored: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1),
+				metric.NewCounterMetric("PollCount", 1),
+			},
+		},
+		{
+			name:   "Namespace by type keeps both metrics",
+			policy: ConflictNamespace,
+			before: []metric.IMetric{metric.NewGaugeMetric("Alloc", 1.5)},
+			batch: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 2),
+				metric.NewCounterMetric("Alloc", 3),
+			},
+			want: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 2),
+				metric.NewCounterMetric("Alloc", 5),
+			},
+			stored: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 5),
+				metric.NewGaugeMetric("Alloc", 1.5),
+			},
+		},
+		{
+			name:   "Last writer wins replaces the metric",
+			policy: ConflictLastWriterWins,
+			before: []metric.IMetric{metric.NewGaugeMetric("Alloc", 1.5), metric.NewCounterMetric("PollCount", 4)},
+			batch: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 2),
+				metric.NewCounterMetric("Alloc", 3),
+				metric.NewGaugeMetric("PollCount", 0.5),
+			},
+			want: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 2),
+				metric.NewCounterMetric("Alloc", 5),
+				metric.NewGaugeMetric("PollCount", 0.5),
+			},
+			stored: []metric.IMetric{
+				metric.NewCounterMetric("Alloc", 5),
+				metric.NewGaugeMetric("PollCount", 0.5),
+			},
+		},
+	}
+	for _, b := range backends() {
+		for _, tt := range tests {
+			t.Run(b.name+"/"+tt.name, func(t *testing.T) {
+				policy := tt.policy
+				if policy == "" {
+					policy = ConflictReject
+				}
+				s := b.open(t, policy)
+				for _, m := range tt.before {
+					_, err := s.Update(m)
+					require.Nil(t, err)
+				}
+
+				result, err := s.BatchUpdate(tt.batch)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					assert.Nil(t, result)
+				} else {
+					require.Nil(t, err)
+					assert.Equal(t, tt.want, result)
+				}
+
+				stored, err := s.Find(Query{})
+				require.Nil(t, err)
+				assert.Equal(t, tt.stored, stored)
+
+				for _, m := range tt.stored {
+					got, err := s.GetByType(m.Name(), m.Type())
+					require.Nil(t, err)
+					assert.Equal(t, m, got)
+				}
+				_, err = s.Get(tt.stored[0].Name())
+				require.Nil(t, err)
+			})
+		}
+	}
+}
+
+func TestConformanceLargeBatch(t *testing.T) {
+	var batch, want []metric.IMetric
+	totals := make(map[string]int64)
+	for i := 0; i < 4*bulkUpdateThreshold; i++ {
+		c := metric.NewCounterMetric(fmt.Sprintf("Counter%d", i%10), metric.Counter(i))
+		g := metric.NewGaugeMetric(fmt.Sprintf("Gauge%d", i%20), metric.Gauge(i))
+		totals[c.Name()] += int64(i)
+		batch = append(batch, c, g)
+		want = append(want, metric.NewCounterMetric(c.Name(), metric.Counter(totals[c.Name()]+1)), g)
+	}
+
+	for _, b := range backends() {
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t, ConflictReject)
+			for i := 0; i < 10; i++ {
+				_, err := s.Update(metric.NewCounterMetric(fmt.Sprintf("Counter%d", i), 1))
+				require.Nil(t, err)
+			}
+
+			result, err := s.BatchUpdate(batch)
+			require.Nil(t, err)
+			assert.Equal(t, want, result)
+
+			stored, err := s.Find(Query{})
+			require.Nil(t, err)
+			assert.Len(t, stored, 30)
+
+			for name, total := range totals {
+				got, err := s.GetByType(name, metric.CounterType)
+				require.Nil(t, err)
+				assert.Equal(t, metric.NewCounterMetric(name, metric.Counter(total+1)), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const bulkUpdateThreshold = 64
+
+type BulkResult struct {
+	Metrics      []metric.IMetric
+	RowsAffected int64
+}
+
+type bulkBatch struct {
+	ids    []string
+	types  []string
+	deltas []int64
+	vals   []float64
+	index  map[metricKey]int
+	mixed  *metricKey
+}
+
+func (s DBStorage) BulkUpdate(ctx context.Context, sm []metric.IMetric) (res BulkResult, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		res, err = s.bulkUpdate(ctx, sm)
+		return err
+	})
+
+	return res, err
+}
+
+func (s DBStorage) bulkUpdate(ctx context.Context, sm []metric.IMetric) (res BulkResult, err error) {
+	b, err := collapseMetrics(sm)
+	if err != nil {
+		return
+	}
+
+	if b.mixed != nil {
+		switch s.policy {
+		case ConflictNamespace:
+		case ConflictLastWriterWins:
+			// The surviving type depends on the order of updates inside the batch.
+			res.Metrics, res.RowsAffected, err = s.rowUpdate(ctx, sm)
+			return
+		default:
+			err = fmt.Errorf("%w: metric '%s' is a %s, got %s", ErrTypeConflict, b.mixed.name, otherType(b.mixed.mType), b.mixed.mType)
+			return
+		}
+	}
+
+	tx, err := s.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	defer func(tx *sql.Tx) {
+		if err != nil {
+			res = BulkResult{}
+			err = rollback(tx, err)
+		}
+	}(tx)
+
+	switch s.policy {
+	case ConflictNamespace:
+	case ConflictLastWriterWins:
+		var r sql.Result
+		if r, err = tx.ExecContext(ctx, BulkDeleteConflictingType(), b.ids, b.types); err != nil {
+			return
+		}
+		n, _ := r.RowsAffected()
+		res.RowsAffected += n
+	default:
+		var id, current string
+		switch scanErr := tx.QueryRowContext(ctx, BulkSelectConflictingType(), b.ids, b.types).Scan(&id, &current); {
+		case scanErr == nil:
+			err = fmt.Errorf("%w: metric '%s' is a %s, got %s", ErrTypeConflict, id, current, otherType(current))
+			return
+		case !errors.Is(scanErr, sql.ErrNoRows):
+			err = scanErr
+			return
+		}
+	}
+
+	totals, n, err := bulkUpsert(ctx, tx, b)
+	if err != nil {
+		return
+	}
+	res.RowsAffected += n
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		err = commitError{commitErr}
+		return
+	}
+
+	res.Metrics = b.results(sm, totals)
+
+	return
+}
+
+func bulkUpsert(ctx context.Context, tx *sql.Tx, b *bulkBatch) (totals map[metricKey]int64, affected int64, err error) {
+	r, err := tx.QueryContext(ctx, BulkUpsertMetrics(), b.ids, b.types, b.deltas, b.vals)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func(r *sql.Rows) {
+		if rErr := r.Close(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}(r)
+
+	totals = make(map[metricKey]int64)
+	for r.Next() {
+		var (
+			id    string
+			mType string
+			delta *int64
+			val   *float64
+		)
+		if err := r.Scan(&id, &mType, &delta, &val); err != nil {
+			return nil, 0, err
+		}
+		if mType == metric.CounterType && delta != nil {
+			totals[metricKey{id, mType}] = *delta
+		}
+		affected++
+	}
+
+	return totals, affected, r.Err()
+}
+
+// collapseMetrics folds the batch into one row per key, so a single
+// INSERT ... ON CONFLICT never has to touch the same row twice.
+func collapseMetrics(sm []metric.IMetric) (*bulkBatch, error) {
+	b := &bulkBatch{index: make(map[metricKey]int, len(sm))}
+	for _, m := range sm {
+		k := keyOf(m)
+		i, ok := b.index[k]
+		if !ok {
+			i = len(b.ids)
+			b.index[k] = i
+			b.ids = append(b.ids, k.name)
+			b.types = append(b.types, k.mType)
+			b.deltas = append(b.deltas, 0)
+			b.vals = append(b.vals, 0)
+
+			if _, ok := b.index[metricKey{k.name, otherType(k.mType)}]; ok && b.mixed == nil {
+				b.mixed = &metricKey{k.name, k.mType}
+			}
+		}
+
+		switch k.mType {
+		case metric.CounterType:
+			delta, err := strconv.ParseInt(m.ValueAsString(), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			b.deltas[i] += delta
+		case metric.GaugeType:
+			val, err := strconv.ParseFloat(m.ValueAsString(), 64)
+			if err != nil {
+				return nil, err
+			}
+			b.vals[i] = val
+		default:
+			return nil, fmt.Errorf("invalid metric type: %s", k.mType)
+		}
+	}
+
+	return b, nil
+}
+
+// Counters report the running total after each of their updates, the same
+// values the row-by-row path returns.
+func (b *bulkBatch) results(sm []metric.IMetric, totals map[metricKey]int64) []metric.IMetric {
+	running := make(map[metricKey]int64, len(totals))
+	for k, total := range totals {
+		running[k] = total - b.deltas[b.index[k]]
+	}
+
+	result := make([]metric.IMetric, 0, len(sm))
+	for _, m := range sm {
+		if m.Type() != metric.CounterType {
+			result = append(result, m)
+			continue
+		}
+
+		k := keyOf(m)
+		delta, _ := strconv.ParseInt(m.ValueAsString(), 10, 64)
+		running[k] += delta
+		result = append(result, metric.NewCounterMetric(m.Name(), metric.Counter(running[k])))
+	}
+
+	return result
+}
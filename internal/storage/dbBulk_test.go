@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestCollapseMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   []metric.IMetric
+		ids     []string
+		types   []string
+		deltas  []int64
+		vals    []float64
+		mixed   *metricKey
+		results []metric.IMetric
+	}{
+		{
+			name: "Counter deltas are summed",
+			batch: []metric.IMetric{
+				metric.NewCounterMetric("PollCount", 1),
+				metric.NewCounterMetric("RequestCount", 2),
+				metric.NewCounterMetric("PollCount", 3),
+			},
+			ids:    []string{"PollCount", "RequestCount"},
+			types:  []string{metric.CounterType, metric.CounterType},
+			deltas: []int64{4, 2},
+			vals:   []float64{0, 0},
+			results: []metric.IMetric{
+				metric.NewCounterMetric("PollCount", 11),
+				metric.NewCounterMetric("RequestCount", 12),
+				metric.NewCounterMetric("PollCount", 14),
+			},
+		},
+		{
+			name: "Last gauge value wins",
+			batch: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1.5),
+				metric.NewGaugeMetric("Alloc", 2.5),
+			},
+			ids:    []string{"Alloc"},
+			types:  []string{metric.GaugeType},
+			deltas: []int64{0},
+			vals:   []float64{2.5},
+			results: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1.5),
+				metric.NewGaugeMetric("Alloc", 2.5),
+			},
+		},
+		{
+			name: "Both types of one name are detected",
+			batch: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1.5),
+				metric.NewCounterMetric("Alloc", 2),
+			},
+			ids:    []string{"Alloc", "Alloc"},
+			types:  []string{metric.GaugeType, metric.CounterType},
+			deltas: []int64{0, 2},
+			vals:   []float64{1.5, 0},
+			mixed:  &metricKey{"Alloc", metric.CounterType},
+			results: []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1.5),
+				metric.NewCounterMetric("Alloc", 12),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := collapseMetrics(tt.batch)
+			require.Nil(t, err)
+			assert.Equal(t, tt.ids, b.ids)
+			assert.Equal(t, tt.types, b.types)
+			assert.Equal(t, tt.deltas, b.deltas)
+			assert.Equal(t, tt.vals, b.vals)
+			assert.Equal(t, tt.mixed, b.mixed)
+
+			// Every counter already held 10 before the batch.
+			totals := make(map[metricKey]int64)
+			for k, i := range b.index {
+				if k.mType == metric.CounterType {
+					totals[k] = 10 + b.deltas[i]
+				}
+			}
+			assert.Equal(t, tt.results, b.results(tt.batch, totals))
+		})
+	}
+}
+
+func BenchmarkDBBatchUpdate(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := NewDBStorage("pgx", dsn, ConflictReject, DBOptions{})
+	require.Nil(b, err)
+	db := s.(DBStorage)
+	defer db.Close()
+
+	for _, size := range []int{30, 500, 5000} {
+		// Agents repeat the same names, so a quarter of the batch is unique.
+		batch := make([]metric.IMetric, 0, size)
+		for i := 0; i < size; i++ {
+			if i%2 == 0 {
+				batch = append(batch, metric.NewCounterMetric(fmt.Sprintf("Counter%d", i%(size/4+1)), 1))
+			} else {
+				batch = append(batch, metric.NewGaugeMetric(fmt.Sprintf("Gauge%d", i%(size/4+1)), metric.Gauge(i)))
+			}
+		}
+
+		paths := []struct {
+			name   string
+			update func([]metric.IMetric) (int64, error)
+		}{
+			{
+				name: "rows",
+				update: func(sm []metric.IMetric) (int64, error) {
+					_, n, err := db.rowUpdate(context.Background(), sm)
+					return n, err
+				},
+			},
+			{
+				name: "bulk",
+				update: func(sm []metric.IMetric) (int64, error) {
+					res, err := db.BulkUpdate(context.Background(), sm)
+					return res.RowsAffected, err
+				},
+			},
+		}
+		for _, p := range paths {
+			b.Run(fmt.Sprintf("%s/%d", p.name, size), func(b *testing.B) {
+				_, err := db.sql.Exec("TRUNCATE metrics")
+				require.Nil(b, err)
+				b.ResetTimer()
+
+				start := time.Now()
+				var rows int64
+				for i := 0; i < b.N; i++ {
+					n, err := p.update(batch)
+					if err != nil {
+						b.Fatal(err)
+					}
+					rows += n
+				}
+				b.ReportMetric(float64(rows)/float64(b.N), "rows/op")
+				b.ReportMetric(float64(size)*float64(b.N)/time.Since(start).Seconds(), "metrics/s")
+			})
+		}
+	}
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -15,29 +16,130 @@ import (
 const updateTimeLimit = 10 * time.Second
 
 type DBStorage struct {
-	sql *sql.DB
+	sql     *sql.DB
+	policy  ConflictPolicy
+	opts    DBOptions
+	breaker *breaker
 }
 
-func NewDBStorage(driverName string, dsn string) (Storage, error) {
+// DBOptions tunes the connection pool and query resilience. Zero pool
+// settings leave the database/sql defaults in place.
+type DBOptions struct {
+	MaxOpenConns     int
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	QueryTimeout     time.Duration
+	Retries          int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+func NewDBStorage(driverName string, dsn string, policy ConflictPolicy, opts DBOptions) (Storage, error) {
 	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = updateTimeLimit
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	_, err = db.ExecContext(ctx, MetricsTable())
+	mg, err := NewMigrator(db)
 	if err != nil {
 		return nil, err
 	}
+	if _, err = mg.Up(ctx); err != nil {
+		return nil, err
+	}
 
 	return DBStorage{
-		sql: db,
+		sql:     db,
+		policy:  policy,
+		opts:    opts,
+		breaker: newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
 	}, nil
 }
 
+// do runs fn with the query timeout, retries it on transient errors and
+// reports unreachable database as ErrUnavailable.
+func (s DBStorage) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !s.breaker.allow() {
+		return ErrUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	err := fn(ctx)
+	for attempt := 0; attempt < s.opts.Retries && retryable(err); attempt++ {
+		if !sleep(ctx, retryDelay(attempt)) {
+			break
+		}
+		err = fn(ctx)
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		s.breaker.abort()
+		return err
+	case unavailable(err):
+		s.breaker.failure()
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	default:
+		s.breaker.success()
+		return err
+	}
+}
+
 func (s DBStorage) Get(name string) (metric.IMetric, error) {
+	return s.GetContext(context.Background(), name)
+}
+
+func (s DBStorage) GetContext(ctx context.Context, name string) (m metric.IMetric, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		m, err = s.scanMetric(s.sql.QueryRowContext(ctx, SelectMetric(), name))
+		return err
+	})
+	if m == nil && err == nil {
+		return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return m, err
+}
+
+func (s DBStorage) GetByType(name string, mType string) (metric.IMetric, error) {
+	return s.GetByTypeContext(context.Background(), name, mType)
+}
+
+func (s DBStorage) GetByTypeContext(ctx context.Context, name string, mType string) (m metric.IMetric, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		m, err = s.scanMetric(s.sql.QueryRowContext(ctx, SelectTypedMetric(), name, mType))
+		return err
+	})
+	if m == nil && err == nil {
+		return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return m, err
+}
+
+func (s DBStorage) scanMetric(row *sql.Row) (metric.IMetric, error) {
 	var (
 		id    string
 		mType string
@@ -45,10 +147,9 @@ func (s DBStorage) Get(name string) (metric.IMetric, error) {
 		val   *float64
 	)
 
-	err := s.sql.QueryRow(SelectMetric(), name).Scan(&id, &mType, &delta, &val)
-	if delta == nil && val == nil {
-		ErrMetricNotFound = fmt.Errorf("metric not found by name '%s'", name)
-		return nil, ErrMetricNotFound
+	err := row.Scan(&id, &mType, &delta, &val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
 	if err != nil {
 		return nil, err
@@ -64,7 +165,24 @@ func (s DBStorage) Get(name string) (metric.IMetric, error) {
 	}
 }
 
-func (s DBStorage) Find(limit int, offset int) (map[string]metric.IMetric, error) {
+func (s DBStorage) Find(q Query) ([]metric.IMetric, error) {
+	return s.FindContext(context.Background(), q)
+}
+
+func (s DBStorage) FindContext(ctx context.Context, q Query) (ms []metric.IMetric, err error) {
+	if _, err := q.matcher(); err != nil {
+		return nil, err
+	}
+
+	err = s.do(ctx, func(ctx context.Context) error {
+		ms, err = s.find(ctx, q)
+		return err
+	})
+
+	return ms, err
+}
+
+func (s DBStorage) find(ctx context.Context, q Query) (ms []metric.IMetric, err error) {
 	var (
 		id    string
 		mType string
@@ -72,7 +190,19 @@ func (s DBStorage) Find(limit int, offset int) (map[string]metric.IMetric, error
 		val   *float64
 	)
 
-	r, err := s.sql.Query(SelectMetrics(), limit, offset)
+	var pattern string
+	if q.Match != "" {
+		if pattern, err = GlobToRegexp(q.Match); err != nil {
+			return nil, err
+		}
+	}
+
+	var limit *int
+	if q.Limit > 0 {
+		limit = &q.Limit
+	}
+
+	r, err := s.sql.QueryContext(ctx, SelectMetrics(), q.After.Name, q.After.Type, q.Type, q.Prefix, pattern, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +213,7 @@ func (s DBStorage) Find(limit int, offset int) (map[string]metric.IMetric, error
 		}
 	}(r)
 
-	ms := map[string]metric.IMetric{}
+	ms = make([]metric.IMetric, 0)
 	for r.Next() {
 		if err := r.Scan(&id, &mType, &delta, &val); err != nil {
 			return nil, err
@@ -99,7 +229,7 @@ func (s DBStorage) Find(limit int, offset int) (map[string]metric.IMetric, error
 			return nil, fmt.Errorf("invalid metric type: %s", mType)
 		}
 
-		ms[id] = m
+		ms = append(ms, m)
 	}
 
 	if err := r.Err(); err != nil {
@@ -110,50 +240,60 @@ func (s DBStorage) Find(limit int, offset int) (map[string]metric.IMetric, error
 }
 
 func (s DBStorage) Update(m metric.IMetric) (metric.IMetric, error) {
-	switch m.Type() {
-	case metric.GaugeType:
-		val, _ := strconv.ParseFloat(m.ValueAsString(), 64)
-		_, err := s.sql.Exec(CreateOrUpdateGauge(), m.Name(), m.Type(), val)
-		if err != nil {
-			return nil, err
-		}
+	return s.UpdateContext(context.Background(), m)
+}
 
-		return m, nil
-	case metric.CounterType:
-		delta, _ := strconv.ParseInt(m.ValueAsString(), 10, 64)
+func (s DBStorage) UpdateContext(ctx context.Context, m metric.IMetric) (metric.IMetric, error) {
+	result, err := s.BatchUpdateContext(ctx, []metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
 
-		var newDelta int
-		err := s.sql.QueryRow(CreateOrUpdateCounter(), m.Name(), m.Type(), delta).Scan(&newDelta)
-		if err != nil {
-			return nil, err
-		}
-		return metric.NewMetric(m.Name(), m.Type(), fmt.Sprintf("%d", newDelta))
-	default:
-		return nil, fmt.Errorf("invalid metric type: %s", m.Type())
+	return result[0], nil
+}
+
+func (s DBStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	return s.BatchUpdateContext(context.Background(), sm)
+}
+
+func (s DBStorage) BatchUpdateContext(ctx context.Context, sm []metric.IMetric) (result []metric.IMetric, err error) {
+	if len(sm) < bulkUpdateThreshold {
+		err = s.do(ctx, func(ctx context.Context) error {
+			result, _, err = s.rowUpdate(ctx, sm)
+			return err
+		})
+		return result, err
 	}
+
+	res, err := s.BulkUpdate(ctx, sm)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Metrics, nil
 }
 
-func (s DBStorage) BatchUpdate(sm []metric.IMetric) (err error) {
-	tx, err := s.sql.Begin()
+func (s DBStorage) rowUpdate(ctx context.Context, sm []metric.IMetric) (result []metric.IMetric, affected int64, err error) {
+	tx, err := s.sql.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
 
 	defer func(tx *sql.Tx) {
 		if err != nil {
-			err = tx.Rollback()
+			result, affected = nil, 0
+			err = rollback(tx, err)
 		}
 	}(tx)
 
-	ctx, cancel := context.WithTimeout(context.Background(), updateTimeLimit)
-	defer cancel()
-
 	gStmt, err := tx.PrepareContext(ctx, CreateOrUpdateGauge())
 	if err != nil {
 		return
 	}
 	defer func(gStmt *sql.Stmt) {
-		err = gStmt.Close()
+		if closeErr := gStmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}(gStmt)
 
 	cStmt, err := tx.PrepareContext(ctx, CreateOrUpdateCounter())
@@ -161,10 +301,54 @@ func (s DBStorage) BatchUpdate(sm []metric.IMetric) (err error) {
 		return
 	}
 	defer func(cStmt *sql.Stmt) {
-		err = cStmt.Close()
+		if closeErr := cStmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}(cStmt)
 
+	tStmt, err := tx.PrepareContext(ctx, SelectConflictingType())
+	if err != nil {
+		return
+	}
+	defer func(tStmt *sql.Stmt) {
+		if closeErr := tStmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}(tStmt)
+
+	dStmt, err := tx.PrepareContext(ctx, DeleteConflictingType())
+	if err != nil {
+		return
+	}
+	defer func(dStmt *sql.Stmt) {
+		if closeErr := dStmt.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}(dStmt)
+
+	result = make([]metric.IMetric, 0, len(sm))
 	for _, m := range sm {
+		switch s.policy {
+		case ConflictNamespace:
+		case ConflictLastWriterWins:
+			var r sql.Result
+			if r, err = dStmt.ExecContext(ctx, m.Name(), m.Type()); err != nil {
+				return
+			}
+			n, _ := r.RowsAffected()
+			affected += n
+		default:
+			var current string
+			switch scanErr := tStmt.QueryRowContext(ctx, m.Name(), m.Type()).Scan(&current); {
+			case scanErr == nil:
+				err = fmt.Errorf("%w: metric '%s' is a %s, got %s", ErrTypeConflict, m.Name(), current, m.Type())
+				return
+			case !errors.Is(scanErr, sql.ErrNoRows):
+				err = scanErr
+				return
+			}
+		}
+
 		switch m.Type() {
 		case metric.GaugeType:
 			val, _ := strconv.ParseFloat(m.ValueAsString(), 64)
@@ -172,19 +356,187 @@ func (s DBStorage) BatchUpdate(sm []metric.IMetric) (err error) {
 			if err != nil {
 				return
 			}
+			result = append(result, m)
+			affected++
 		case metric.CounterType:
 			delta, _ := strconv.ParseInt(m.ValueAsString(), 10, 64)
-			_, err = cStmt.ExecContext(ctx, m.Name(), m.Type(), delta)
+			var newDelta int64
+			err = cStmt.QueryRowContext(ctx, m.Name(), m.Type(), delta).Scan(&newDelta)
+			if err != nil {
+				return
+			}
+			result = append(result, metric.NewCounterMetric(m.Name(), metric.Counter(newDelta)))
+			affected++
 		default:
-			return fmt.Errorf("invalid metric type: %s", m.Type())
+			err = fmt.Errorf("invalid metric type: %s", m.Type())
+			return
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		err = commitError{commitErr}
+	}
+
+	return
+}
+
+func (s DBStorage) Delete(name string) error {
+	return s.DeleteContext(context.Background(), name)
+}
+
+func (s DBStorage) DeleteContext(ctx context.Context, name string) error {
+	deleted, err := s.deleteReturning(ctx, DeleteMetric(), name)
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) == 0 {
+		return fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	return nil
+}
+
+func (s DBStorage) DeleteMatching(pattern string) ([]string, error) {
+	return s.DeleteMatchingContext(context.Background(), pattern)
+}
+
+func (s DBStorage) DeleteMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	re, err := GlobToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.deleteReturning(ctx, DeleteMatchingMetrics(), re)
+}
+
+func (s DBStorage) DeleteStale(before time.Time) ([]string, error) {
+	return s.DeleteStaleContext(context.Background(), before)
+}
+
+func (s DBStorage) DeleteStaleContext(ctx context.Context, before time.Time) ([]string, error) {
+	return s.deleteReturning(ctx, DeleteStaleMetrics(), before)
+}
+
+func (s DBStorage) deleteReturning(ctx context.Context, query string, arg any) (deleted []string, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		deleted, err = s.queryNames(ctx, query, arg)
+		return err
+	})
+
+	return deleted, err
+}
+
+func (s DBStorage) queryNames(ctx context.Context, query string, arg any) (deleted []string, err error) {
+	r, err := s.sql.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(r *sql.Rows) {
+		if rErr := r.Close(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}(r)
+
+	deleted = make([]string, 0)
+	for r.Next() {
+		var id string
+		if err := r.Scan(&id); err != nil {
+			return nil, err
 		}
+		deleted = append(deleted, id)
+	}
+
+	return deleted, r.Err()
+}
+
+func (s DBStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	return s.FindMetadataContext(context.Background(), names)
+}
+
+func (s DBStorage) FindMetadataContext(ctx context.Context, names []string) (result map[string]metric.Metadata, err error) {
+	err = s.do(ctx, func(ctx context.Context) error {
+		result, err = s.findMetadata(ctx, names)
+		return err
+	})
+
+	return result, err
+}
+
+func (s DBStorage) findMetadata(ctx context.Context, names []string) (result map[string]metric.Metadata, err error) {
+	r, err := s.sql.QueryContext(ctx, SelectMetadata(), names)
+	if err != nil {
+		return nil, err
 	}
 
-	err = tx.Commit()
+	defer func(r *sql.Rows) {
+		if rErr := r.Close(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}(r)
+
+	result = make(map[string]metric.Metadata, len(names))
+	for r.Next() {
+		var (
+			id string
+			md metric.Metadata
+		)
+		if err := r.Scan(&id, &md.Unit, &md.Help, &md.Owner); err != nil {
+			return nil, err
+		}
+		result[id] = md
+	}
+
+	return result, r.Err()
+}
+
+func (s DBStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	return s.SetMetadataContext(context.Background(), mds)
+}
+
+func (s DBStorage) SetMetadataContext(ctx context.Context, mds map[string]metric.Metadata) error {
+	return s.do(ctx, func(ctx context.Context) error {
+		return s.setMetadata(ctx, mds)
+	})
+}
+
+func (s DBStorage) setMetadata(ctx context.Context, mds map[string]metric.Metadata) (err error) {
+	tx, err := s.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+
+	defer func(tx *sql.Tx) {
+		if err != nil {
+			err = rollback(tx, err)
+		}
+	}(tx)
+
+	for name, md := range mds {
+		if _, err = tx.ExecContext(ctx, MergeMetadata(), name, md.Unit, md.Help, md.Owner); err != nil {
+			return
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		err = commitError{commitErr}
+	}
 
 	return
 }
 
+func (s DBStorage) DeleteMetadata(name string) error {
+	return s.DeleteMetadataContext(context.Background(), name)
+}
+
+func (s DBStorage) DeleteMetadataContext(ctx context.Context, name string) error {
+	return s.do(ctx, func(ctx context.Context) error {
+		_, err := s.sql.ExecContext(ctx, DeleteMetadata(), name)
+		return err
+	})
+}
+
 func (s DBStorage) Ping(ctx context.Context) error {
 	return s.sql.PingContext(ctx)
 }
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+// FeedStorage reports changes made through it to a Feed, for storages that
+// do not support one themselves.
+type FeedStorage struct {
+	storage Storage
+	feed    Feed
+}
+
+type multiFeed []Feed
+
+func NewFeedStorage(s Storage, feed Feed) *FeedStorage {
+	return &FeedStorage{
+		storage: s,
+		feed:    feed,
+	}
+}
+
+// MultiFeed passes records to each feed in turn and stops at the first error.
+func MultiFeed(feeds ...Feed) Feed {
+	return multiFeed(feeds)
+}
+
+func (f multiFeed) Append(records ...fs.WALRecord) error {
+	for _, feed := range f {
+		if err := feed.Append(records...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FeedStorage) Unwrap() Storage {
+	return s.storage
+}
+
+func (s *FeedStorage) Get(name string) (metric.IMetric, error) {
+	return s.storage.Get(name)
+}
+
+func (s *FeedStorage) GetContext(ctx context.Context, name string) (metric.IMetric, error) {
+	return GetContext(ctx, s.storage, name)
+}
+
+func (s *FeedStorage) GetByType(name string, mType string) (metric.IMetric, error) {
+	return s.storage.GetByType(name, mType)
+}
+
+func (s *FeedStorage) GetByTypeContext(ctx context.Context, name string, mType string) (metric.IMetric, error) {
+	return GetByTypeContext(ctx, s.storage, name, mType)
+}
+
+func (s *FeedStorage) Find(q Query) ([]metric.IMetric, error) {
+	return s.storage.Find(q)
+}
+
+func (s *FeedStorage) FindContext(ctx context.Context, q Query) ([]metric.IMetric, error) {
+	return FindContext(ctx, s.storage, q)
+}
+
+func (s *FeedStorage) Update(m metric.IMetric) (metric.IMetric, error) {
+	return s.UpdateContext(context.Background(), m)
+}
+
+func (s *FeedStorage) UpdateContext(ctx context.Context, m metric.IMetric) (metric.IMetric, error) {
+	result, err := s.BatchUpdateContext(ctx, []metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
+
+	return result[0], nil
+}
+
+func (s *FeedStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	return s.BatchUpdateContext(context.Background(), sm)
+}
+
+func (s *FeedStorage) BatchUpdateContext(ctx context.Context, sm []metric.IMetric) ([]metric.IMetric, error) {
+	updated, err := BatchUpdateContext(ctx, s.storage, sm)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]fs.WALRecord, 0, len(updated))
+	for _, im := range updated {
+		m, mErr := metric.NewMetricsFromIMetric(im)
+		if mErr != nil {
+			return nil, mErr
+		}
+		records = append(records, fs.WALRecord{Op: fs.WALUpdate, Metric: m})
+	}
+
+	return updated, s.feed.Append(records...)
+}
+
+func (s *FeedStorage) Delete(name string) error {
+	return s.DeleteContext(context.Background(), name)
+}
+
+func (s *FeedStorage) DeleteContext(ctx context.Context, name string) error {
+	if err := DeleteContext(ctx, s.storage, name); err != nil {
+		return err
+	}
+
+	return s.appendNames(fs.WALDelete, name)
+}
+
+func (s *FeedStorage) DeleteMatching(pattern string) ([]string, error) {
+	return s.DeleteMatchingContext(context.Background(), pattern)
+}
+
+func (s *FeedStorage) DeleteMatchingContext(ctx context.Context, pattern string) ([]string, error) {
+	deleted, err := DeleteMatchingContext(ctx, s.storage, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, s.appendNames(fs.WALDelete, deleted...)
+}
+
+func (s *FeedStorage) DeleteStale(before time.Time) ([]string, error) {
+	return s.DeleteStaleContext(context.Background(), before)
+}
+
+func (s *FeedStorage) DeleteStaleContext(ctx context.Context, before time.Time) ([]string, error) {
+	deleted, err := DeleteStaleContext(ctx, s.storage, before)
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, s.appendNames(fs.WALDelete, deleted...)
+}
+
+func (s *FeedStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	return s.storage.FindMetadata(names)
+}
+
+func (s *FeedStorage) FindMetadataContext(ctx context.Context, names []string) (map[string]metric.Metadata, error) {
+	return FindMetadataContext(ctx, s.storage, names)
+}
+
+func (s *FeedStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	return s.SetMetadataContext(context.Background(), mds)
+}
+
+func (s *FeedStorage) SetMetadataContext(ctx context.Context, mds map[string]metric.Metadata) error {
+	if err := SetMetadataContext(ctx, s.storage, mds); err != nil {
+		return err
+	}
+
+	records := make([]fs.WALRecord, 0, len(mds))
+	for name, md := range mds {
+		records = append(records, fs.WALRecord{Op: fs.WALSetMetadata, Metric: metric.Metrics{ID: name, Metadata: md}})
+	}
+
+	return s.feed.Append(records...)
+}
+
+func (s *FeedStorage) DeleteMetadata(name string) error {
+	return s.DeleteMetadataContext(context.Background(), name)
+}
+
+func (s *FeedStorage) DeleteMetadataContext(ctx context.Context, name string) error {
+	if err := DeleteMetadataContext(ctx, s.storage, name); err != nil {
+		return err
+	}
+
+	return s.appendNames(fs.WALDeleteMetadata, name)
+}
+
+func (s *FeedStorage) Ping(ctx context.Context) error {
+	if p, ok := s.storage.(pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (s *FeedStorage) Close() error {
+	if c, ok := s.storage.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (s *FeedStorage) appendNames(op fs.WALOp, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	records := make([]fs.WALRecord, 0, len(names))
+	for _, name := range names {
+		records = append(records, fs.WALRecord{Op: op, Metric: metric.Metrics{ID: name}})
+	}
+
+	return s.feed.Append(records...)
+}
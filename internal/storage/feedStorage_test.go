@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/fs"
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type failingFeed struct{}
+
+func (failingFeed) Append(...fs.WALRecord) error {
+	return errors.New("feed is closed")
+}
+
+func TestFeedStorage(t *testing.T) {
+	feed := &recordingFeed{}
+	s := NewFeedStorage(newMemStorage(), feed)
+
+	_, err := s.Update(metric.NewCounterMetric("PollCount", 2))
+	require.Nil(t, err)
+	_, err = s.BatchUpdate([]metric.IMetric{metric.NewCounterMetric("PollCount", 3), metric.NewGaugeMetric("Alloc", 1)})
+	require.Nil(t, err)
+	require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}))
+	require.Nil(t, s.DeleteMetadata("Alloc"))
+	_, err = s.DeleteMatching("Poll*")
+	require.Nil(t, err)
+	_, err = s.DeleteStale(time.Now().Add(time.Minute))
+	require.Nil(t, err)
+
+	// Failed writes are not reported.
+	assert.ErrorIs(t, s.Delete("Unknown"), ErrMetricNotFound)
+
+	first, total, alloc := int64(2), int64(5), 1.0
+	assert.Equal(t, []fs.WALRecord{
+		{Op: fs.WALUpdate, Metric: metric.Metrics{ID: "PollCount", MType: metric.CounterType, Delta: &first}},
+		{Op: fs.WALUpdate, Metric: metric.Metrics{ID: "PollCount", MType: metric.CounterType, Delta: &total}},
+		{Op: fs.WALUpdate, Metric: metric.Metrics{ID: "Alloc", MType: metric.GaugeType, Value: &alloc}},
+		{Op: fs.WALSetMetadata, Metric: metric.Metrics{ID: "Alloc", Metadata: metric.Metadata{Unit: "bytes"}}},
+		{Op: fs.WALDeleteMetadata, Metric: metric.Metrics{ID: "Alloc"}},
+		{Op: fs.WALDelete, Metric: metric.Metrics{ID: "PollCount"}},
+		{Op: fs.WALDelete, Metric: metric.Metrics{ID: "Alloc"}},
+	}, feed.records)
+}
+
+func TestMultiFeed(t *testing.T) {
+	first, second := &recordingFeed{}, &recordingFeed{}
+	records := []fs.WALRecord{{Op: fs.WALDelete, Metric: metric.Metrics{ID: "Alloc"}}}
+
+	require.Nil(t, MultiFeed(first, second).Append(records...))
+	assert.Equal(t, records, first.records)
+	assert.Equal(t, records, second.records)
+
+	assert.Error(t, MultiFeed(failingFeed{}, second).Append(records...))
+	assert.Len(t, second.records, 1, "feeds after a failed one are skipped")
+}
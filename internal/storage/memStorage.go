@@ -1,70 +1,106 @@
 package storage
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/1g0rbm/sysmonitor/internal/fs"
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 )
 
 type MemStorage struct {
-	data map[string]metric.IMetric
-	mu   sync.RWMutex
+	shards []*memShard
+	policy ConflictPolicy
+	wal    *fs.WAL
+	feed   Feed
+	opts   fs.SnapshotOptions
+	now    func() time.Time
 }
 
-func (ms *MemStorage) Find(limit int, offset int) (map[string]metric.IMetric, error) {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+// Feed receives every change of MemStorage as WAL records, in the order the
+// changes of each name were applied.
+type Feed interface {
+	Append(records ...fs.WALRecord) error
+}
+
+type memShard struct {
+	data    map[metricKey]metric.IMetric
+	meta    map[string]metric.Metadata
+	updated map[metricKey]time.Time
+	mu      sync.RWMutex
+}
 
-	if offset < 0 || offset >= len(ms.data) || limit < 0 {
-		return nil, errors.New("invalid input parameters")
+func (ms *MemStorage) Find(q Query) ([]metric.IMetric, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return nil, err
 	}
 
-	cnt := 0
-	result := make(map[string]metric.IMetric)
+	unlock := ms.rlockAll()
+	defer unlock()
+
+	keys := ms.sortedKeys()
+	start := sort.Search(len(keys), func(i int) bool {
+		return keys[i].name >= q.After.Name
+	})
+	result := make([]metric.IMetric, 0)
+	for _, k := range keys[start:] {
+		m := ms.shardOf(k.name).data[k]
+		if !match(m) {
+			continue
+		}
 
-	for key, m := range ms.data {
-		if cnt >= offset {
-			result[key] = m
-			if len(result) == limit {
-				break
-			}
+		result = append(result, m)
+		if len(result) == q.Limit {
+			break
 		}
-		cnt++
 	}
 
 	return result, nil
 }
 
 func (ms *MemStorage) Get(name string) (metric.IMetric, error) {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+	sh := ms.shardOf(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	v, ok := ms.data[name]
+	for _, mType := range metricTypes {
+		if v, ok := sh.data[metricKey{name, mType}]; ok {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+}
+
+func (ms *MemStorage) GetByType(name string, mType string) (metric.IMetric, error) {
+	sh := ms.shardOf(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	v, ok := sh.data[metricKey{name, mType}]
 	if !ok {
-		ErrMetricNotFound = fmt.Errorf("metric not found by name '%s'", name)
-		return nil, ErrMetricNotFound
+		return nil, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
 	}
 
 	return v, nil
 }
 
 func (ms *MemStorage) GetCounter(name string) (metric.CounterMetric, error) {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+	sh := ms.shardOf(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	v, ok := ms.data[name]
+	v, ok := sh.data[metricKey{name, metric.CounterType}]
 	if !ok {
-		ErrMetricNotFound = fmt.Errorf("metric not found by name '%s'", name)
-		return metric.CounterMetric{}, ErrMetricNotFound
-	}
-
-	if v.Type() != metric.CounterType {
-		err := fmt.Errorf("metric should be a counter type, but a '%s' was found", v.Type())
-		return metric.CounterMetric{}, err
+		if _, ok := sh.data[metricKey{name, metric.GaugeType}]; ok {
+			err := fmt.Errorf("metric should be a counter type, but a '%s' was found", metric.GaugeType)
+			return metric.CounterMetric{}, err
+		}
+		return metric.CounterMetric{}, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
 	}
 
 	t, _ := v.(metric.CounterMetric)
@@ -73,18 +109,17 @@ func (ms *MemStorage) GetCounter(name string) (metric.CounterMetric, error) {
 }
 
 func (ms *MemStorage) GetGauge(name string) (metric.GaugeMetric, error) {
-	ms.mu.RLock()
-	defer ms.mu.RUnlock()
+	sh := ms.shardOf(name)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	v, ok := ms.data[name]
+	v, ok := sh.data[metricKey{name, metric.GaugeType}]
 	if !ok {
-		ErrMetricNotFound = fmt.Errorf("metric not found by name '%s'", name)
-		return metric.GaugeMetric{}, ErrMetricNotFound
-	}
-
-	if v.Type() != metric.GaugeType {
-		err := fmt.Errorf("metric should be a gauge type, but a '%s' was found", v.Type())
-		return metric.GaugeMetric{}, err
+		if _, ok := sh.data[metricKey{name, metric.CounterType}]; ok {
+			err := fmt.Errorf("metric should be a gauge type, but a '%s' was found", metric.CounterType)
+			return metric.GaugeMetric{}, err
+		}
+		return metric.GaugeMetric{}, fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
 	}
 
 	t, _ := v.(metric.GaugeMetric)
@@ -93,45 +128,307 @@ func (ms *MemStorage) GetGauge(name string) (metric.GaugeMetric, error) {
 }
 
 func (ms *MemStorage) Update(m metric.IMetric) (metric.IMetric, error) {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
+	result, err := ms.BatchUpdate([]metric.IMetric{m})
+	if err != nil {
+		return nil, err
+	}
 
-	switch m.Type() {
-	case metric.CounterType:
-		em, ok := ms.data[m.Name()]
-		if !ok {
-			ms.data[m.Name()] = m
-			return m, nil
+	return result[0], nil
+}
+
+func (ms *MemStorage) set(m metric.IMetric) {
+	k := keyOf(m)
+	sh := ms.shardOf(k.name)
+	sh.data[k] = m
+	sh.updated[k] = ms.now()
+}
+
+func (ms *MemStorage) BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error) {
+	names := make([]string, 0, len(sm))
+	for _, m := range sm {
+		names = append(names, m.Name())
+	}
+	unlock := ms.lock(names...)
+	defer unlock()
+
+	staged := make(map[metricKey]metric.IMetric, len(sm))
+	removed := make(map[metricKey]bool)
+	lookup := func(k metricKey) metric.IMetric {
+		if m, ok := staged[k]; ok {
+			return m
+		}
+		if removed[k] {
+			return nil
 		}
+		return ms.shardOf(k.name).data[k]
+	}
+
+	result := make([]metric.IMetric, 0, len(sm))
+	for _, m := range sm {
+		k := keyOf(m)
+		ok := metricKey{k.name, otherType(k.mType)}
 
-		updM, updErr := m.Update(em)
-		if updErr != nil {
-			return nil, updErr
+		var conflict string
+		if lookup(ok) != nil {
+			conflict = ok.mType
 		}
 
-		ms.data[updM.Name()] = updM
-		return updM, nil
-	case metric.GaugeType:
-		ms.data[m.Name()] = m
-		return m, nil
-	default:
-		return nil, fmt.Errorf("undefined metric type '%s'", m.Type())
+		updM, err := applyUpdate(ms.policy, lookup(k), conflict, m)
+		if err != nil {
+			return nil, err
+		}
+
+		if conflict != "" && ms.policy == ConflictLastWriterWins {
+			delete(staged, ok)
+			removed[ok] = true
+		}
+		staged[k] = updM
+		result = append(result, updM)
+	}
+
+	for k := range staged {
+		delete(removed, k)
+	}
+
+	removedKeys := make([]metricKey, 0, len(removed))
+	for k := range removed {
+		removedKeys = append(removedKeys, k)
+	}
+	if err := ms.logKeys(removedKeys...); err != nil {
+		return nil, err
+	}
+
+	updated := make([]metric.IMetric, 0, len(staged))
+	for _, m := range staged {
+		updated = append(updated, m)
 	}
+	if err := ms.logMetrics(updated...); err != nil {
+		return nil, err
+	}
+
+	for _, k := range removedKeys {
+		ms.remove(k)
+	}
+	for _, m := range updated {
+		ms.set(m)
+	}
+
+	return result, nil
 }
 
-func (ms *MemStorage) BatchUpdate(sm []metric.IMetric) error {
-	for _, m := range sm {
-		_, err := ms.Update(m)
-		if err != nil {
+func (ms *MemStorage) Delete(name string) error {
+	unlock := ms.lock(name)
+	defer unlock()
+
+	if len(ms.keysOf(name)) == 0 {
+		return fmt.Errorf("%w: name '%s'", ErrMetricNotFound, name)
+	}
+
+	if err := ms.logNames(fs.WALDelete, name); err != nil {
+		return err
+	}
+
+	ms.delete(name)
+
+	return nil
+}
+
+func (ms *MemStorage) DeleteMatching(pattern string) ([]string, error) {
+	re, err := CompileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return ms.deleteWhere(func(k metricKey) bool {
+		return re.MatchString(k.name)
+	})
+}
+
+func (ms *MemStorage) DeleteStale(before time.Time) ([]string, error) {
+	return ms.deleteWhere(func(k metricKey) bool {
+		return ms.shardOf(k.name).updated[k].Before(before)
+	})
+}
+
+func (ms *MemStorage) deleteWhere(fn func(k metricKey) bool) ([]string, error) {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	keys := make([]metricKey, 0)
+	for _, k := range ms.sortedKeys() {
+		if fn(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	if err := ms.logKeys(keys...); err != nil {
+		return nil, err
+	}
+
+	deleted := make([]string, 0)
+	for _, k := range keys {
+		ms.remove(k)
+		if len(deleted) == 0 || deleted[len(deleted)-1] != k.name {
+			deleted = append(deleted, k.name)
+		}
+	}
+
+	return deleted, nil
+}
+
+func (ms *MemStorage) delete(name string) {
+	for _, k := range ms.keysOf(name) {
+		ms.remove(k)
+	}
+}
+
+func (ms *MemStorage) remove(k metricKey) {
+	sh := ms.shardOf(k.name)
+	delete(sh.data, k)
+	delete(sh.updated, k)
+}
+
+func (ms *MemStorage) keysOf(name string) []metricKey {
+	sh := ms.shardOf(name)
+	keys := make([]metricKey, 0, len(metricTypes))
+	for _, mType := range metricTypes {
+		if _, ok := sh.data[metricKey{name, mType}]; ok {
+			keys = append(keys, metricKey{name, mType})
+		}
+	}
+
+	return keys
+}
+
+func (ms *MemStorage) sortedKeys() []metricKey {
+	keys := make([]metricKey, 0)
+	for _, sh := range ms.shards {
+		for k := range sh.data {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].mType < keys[j].mType
+	})
+
+	return keys
+}
+
+func (ms *MemStorage) FindMetadata(names []string) (map[string]metric.Metadata, error) {
+	unlock := ms.rlock(names...)
+	defer unlock()
+
+	result := make(map[string]metric.Metadata, len(names))
+	for _, name := range names {
+		if md, ok := ms.shardOf(name).meta[name]; ok {
+			result[name] = md
+		}
+	}
+
+	return result, nil
+}
+
+func (ms *MemStorage) SetMetadata(mds map[string]metric.Metadata) error {
+	names := make([]string, 0, len(mds))
+	for name := range mds {
+		names = append(names, name)
+	}
+	unlock := ms.lock(names...)
+	defer unlock()
+
+	merged := make(map[string]metric.Metadata, len(mds))
+	for name, md := range mds {
+		merged[name] = ms.shardOf(name).meta[name].Merge(md)
+	}
+
+	if ms.logging() {
+		records := make([]fs.WALRecord, 0, len(merged))
+		for name, md := range merged {
+			records = append(records, fs.WALRecord{Op: fs.WALSetMetadata, Metric: metric.Metrics{ID: name, Metadata: md}})
+		}
+		if err := ms.append(records...); err != nil {
 			return err
 		}
 	}
 
+	for name, md := range merged {
+		ms.shardOf(name).meta[name] = md
+	}
+
 	return nil
 }
 
-func (ms *MemStorage) Restore(filepath string) (err error) {
-	mr, err := fs.NewMetricReader(filepath)
+func (ms *MemStorage) DeleteMetadata(name string) error {
+	unlock := ms.lock(name)
+	defer unlock()
+
+	if err := ms.logNames(fs.WALDeleteMetadata, name); err != nil {
+		return err
+	}
+
+	delete(ms.shardOf(name).meta, name)
+
+	return nil
+}
+
+func (ms *MemStorage) SetWAL(wal *fs.WAL) {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	ms.wal = wal
+}
+
+func (ms *MemStorage) SetFeed(feed Feed) {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	ms.feed = feed
+}
+
+func (ms *MemStorage) SetSnapshotOptions(opts fs.SnapshotOptions) {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	ms.opts = opts
+}
+
+func (ms *MemStorage) CloseWAL() error {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	if ms.wal == nil {
+		return nil
+	}
+
+	err := ms.wal.Close()
+	ms.wal = nil
+
+	return err
+}
+
+// Restore loads the snapshot at filepath, falling back to its rotated copies
+// when it is corrupt, and replays the WAL on top. It returns the snapshot
+// path that was loaded.
+func (ms *MemStorage) Restore(filepath string) (string, error) {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	restored, err := ms.restoreSnapshot(filepath)
+	if err != nil {
+		return "", err
+	}
+
+	return restored, ms.replayWAL()
+}
+
+func (ms *MemStorage) restoreSnapshot(filepath string) (restored string, err error) {
+	mr, restored, err := fs.OpenSnapshot(filepath, ms.opts.Keep)
+	if err != nil {
+		return "", err
+	}
 
 	defer func(mr *fs.MetricReader) {
 		closeErr := mr.Close()
@@ -145,34 +442,141 @@ func (ms *MemStorage) Restore(filepath string) (err error) {
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return "", err
+		}
 
 		im, imErr := m.ToIMetric()
 		if imErr != nil {
-			return imErr
+			return "", imErr
+		}
+
+		ms.set(im)
+
+		if !m.Metadata.IsZero() {
+			sh := ms.shardOf(m.ID)
+			sh.meta[m.ID] = sh.meta[m.ID].Merge(m.Metadata)
+		}
+	}
+
+	return restored, nil
+}
+
+func (ms *MemStorage) replayWAL() error {
+	if ms.wal == nil {
+		return nil
+	}
+
+	return ms.wal.Replay(ms.apply)
+}
+
+// Apply makes changes recorded by another MemStorage, e.g. a replication
+// primary. They are logged like local writes.
+func (ms *MemStorage) Apply(records ...fs.WALRecord) error {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	return ms.applyLogged(records)
+}
+
+// Reset replaces all metrics and metadata with the ones set by records.
+func (ms *MemStorage) Reset(records ...fs.WALRecord) error {
+	unlock := ms.lockAll()
+	defer unlock()
+
+	var clear []fs.WALRecord
+	for _, sh := range ms.shards {
+		for k := range sh.data {
+			clear = append(clear, fs.WALRecord{Op: fs.WALDelete, Metric: metric.Metrics{ID: k.name, MType: k.mType}})
 		}
+		for name := range sh.meta {
+			clear = append(clear, fs.WALRecord{Op: fs.WALDeleteMetadata, Metric: metric.Metrics{ID: name}})
+		}
+	}
 
-		_, err = ms.Update(im)
+	return ms.applyLogged(append(clear, records...))
+}
+
+// Snapshot returns the records that rebuild the current state with Reset.
+func (ms *MemStorage) Snapshot() ([]fs.WALRecord, error) {
+	unlock := ms.rlockAll()
+	defer unlock()
+
+	var records []fs.WALRecord
+	for _, sh := range ms.shards {
+		for _, im := range sh.data {
+			m, err := metric.NewMetricsFromIMetric(im)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, fs.WALRecord{Op: fs.WALUpdate, Metric: m})
+		}
+		for name, md := range sh.meta {
+			records = append(records, fs.WALRecord{Op: fs.WALSetMetadata, Metric: metric.Metrics{ID: name, Metadata: md}})
+		}
+	}
+
+	return records, nil
+}
+
+func (ms *MemStorage) applyLogged(records []fs.WALRecord) error {
+	if err := ms.append(records...); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		if err := ms.apply(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) apply(r fs.WALRecord) error {
+	switch r.Op {
+	case fs.WALUpdate:
+		im, err := r.Metric.ToIMetric()
 		if err != nil {
 			return err
 		}
+		ms.set(im)
+	case fs.WALDelete:
+		if r.Metric.MType != "" {
+			ms.remove(metricKey{r.Metric.ID, r.Metric.MType})
+			break
+		}
+		ms.delete(r.Metric.ID)
+	case fs.WALSetMetadata:
+		ms.shardOf(r.Metric.ID).meta[r.Metric.ID] = r.Metric.Metadata
+	case fs.WALDeleteMetadata:
+		delete(ms.shardOf(r.Metric.ID).meta, r.Metric.ID)
+	default:
+		return fmt.Errorf("undefined wal operation '%s'", r.Op)
 	}
 
-	return
+	return nil
 }
 
 func (ms *MemStorage) BackupData(path string) error {
-	mw, err := fs.NewMetricWriter(path)
+	unlock := ms.rlockAll()
+	defer unlock()
+
+	mw, err := fs.NewMetricWriter(path, ms.opts)
 	if err != nil {
 		return err
 	}
 
-	for _, m := range ms.data {
-		metrics, imErr := metric.NewMetricsFromIMetric(m)
-		if imErr != nil {
-			return imErr
-		}
-		if writeErr := mw.Write(metrics); writeErr != nil {
-			return writeErr
+	for _, sh := range ms.shards {
+		for _, m := range sh.data {
+			metrics, imErr := metric.NewMetricsFromIMetric(m)
+			if imErr != nil {
+				return imErr
+			}
+			metrics.Metadata = sh.meta[m.Name()]
+			if writeErr := mw.Write(metrics); writeErr != nil {
+				return writeErr
+			}
 		}
 	}
 
@@ -180,15 +584,190 @@ func (ms *MemStorage) BackupData(path string) error {
 		return closeErr
 	}
 
+	if ms.wal != nil {
+		return ms.wal.Truncate()
+	}
+
 	return nil
 }
 
-func newMemStorage() *MemStorage {
+func (ms *MemStorage) logging() bool {
+	return ms.wal != nil || ms.feed != nil
+}
+
+// append writes records to the WAL first, so the feed never gets changes a
+// restart would lose.
+func (ms *MemStorage) append(records ...fs.WALRecord) error {
+	if ms.wal != nil {
+		if err := ms.wal.Append(records...); err != nil {
+			return err
+		}
+	}
+
+	if ms.feed != nil {
+		return ms.feed.Append(records...)
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) logMetrics(ims ...metric.IMetric) error {
+	if !ms.logging() {
+		return nil
+	}
+
+	records := make([]fs.WALRecord, 0, len(ims))
+	for _, im := range ims {
+		m, err := metric.NewMetricsFromIMetric(im)
+		if err != nil {
+			return err
+		}
+		records = append(records, fs.WALRecord{Op: fs.WALUpdate, Metric: m})
+	}
+
+	return ms.append(records...)
+}
+
+func (ms *MemStorage) logKeys(keys ...metricKey) error {
+	if !ms.logging() || len(keys) == 0 {
+		return nil
+	}
+
+	records := make([]fs.WALRecord, 0, len(keys))
+	for _, k := range keys {
+		records = append(records, fs.WALRecord{Op: fs.WALDelete, Metric: metric.Metrics{ID: k.name, MType: k.mType}})
+	}
+
+	return ms.append(records...)
+}
+
+func (ms *MemStorage) logNames(op fs.WALOp, names ...string) error {
+	if !ms.logging() || len(names) == 0 {
+		return nil
+	}
+
+	records := make([]fs.WALRecord, 0, len(names))
+	for _, name := range names {
+		records = append(records, fs.WALRecord{Op: op, Metric: metric.Metrics{ID: name}})
+	}
+
+	return ms.append(records...)
+}
+
+// Both types of a name and its metadata always live in the same shard, so
+// conflict checks never cross shards. Operations spanning several shards lock
+// them in index order.
+func (ms *MemStorage) shardOf(name string) *memShard {
+	return ms.shards[ms.shardIndex(name)]
+}
+
+// shardIndex is an inlined FNV-1a, which keeps the hot path free of allocations.
+func (ms *MemStorage) shardIndex(name string) int {
+	if len(ms.shards) == 1 {
+		return 0
+	}
+
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+
+	return int(h % uint32(len(ms.shards)))
+}
+
+func (ms *MemStorage) shardsOf(names []string) []*memShard {
+	switch {
+	case len(ms.shards) == 1:
+		return ms.shards
+	case len(names) == 1:
+		return ms.shards[ms.shardIndex(names[0]) : ms.shardIndex(names[0])+1]
+	}
+
+	used := make([]bool, len(ms.shards))
+	for _, name := range names {
+		used[ms.shardIndex(name)] = true
+	}
+
+	shards := make([]*memShard, 0, len(ms.shards))
+	for i, sh := range ms.shards {
+		if used[i] {
+			shards = append(shards, sh)
+		}
+	}
+
+	return shards
+}
+
+func (ms *MemStorage) lock(names ...string) func() {
+	return lockShards(ms.shardsOf(names), false)
+}
+
+func (ms *MemStorage) rlock(names ...string) func() {
+	return lockShards(ms.shardsOf(names), true)
+}
+
+func (ms *MemStorage) lockAll() func() {
+	return lockShards(ms.shards, false)
+}
+
+func (ms *MemStorage) rlockAll() func() {
+	return lockShards(ms.shards, true)
+}
+
+func lockShards(shards []*memShard, read bool) func() {
+	for _, sh := range shards {
+		if read {
+			sh.mu.RLock()
+		} else {
+			sh.mu.Lock()
+		}
+	}
+
+	return func() {
+		for _, sh := range shards {
+			if read {
+				sh.mu.RUnlock()
+			} else {
+				sh.mu.Unlock()
+			}
+		}
+	}
+}
+
+func newMemShard() *memShard {
+	return &memShard{
+		data:    make(map[metricKey]metric.IMetric),
+		meta:    make(map[string]metric.Metadata),
+		updated: make(map[metricKey]time.Time),
+	}
+}
+
+func newShardedMemStorage(n int) *MemStorage {
+	if n < 1 {
+		n = 1
+	}
+
+	shards := make([]*memShard, n)
+	for i := range shards {
+		shards[i] = newMemShard()
+	}
+
 	return &MemStorage{
-		data: make(map[string]metric.IMetric),
+		shards: shards,
+		policy: ConflictReject,
+		now:    time.Now,
 	}
 }
 
+func newMemStorage() *MemStorage {
+	return newShardedMemStorage(1)
+}
+
 func NewMemStorage() Storage {
 	return newMemStorage()
 }
+
+func NewShardedMemStorage(n int) Storage {
+	return newShardedMemStorage(n)
+}
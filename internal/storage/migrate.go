@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const migrationLockID int64 = 0x73797364626d6967
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	ms, err := LoadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: ms,
+	}, nil
+}
+
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		parts := migrationFile.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name '%s'", e.Name())
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in '%s'", e.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names '%s' and '%s'", version, m.Name, parts[2])
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if parts[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	ms := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d '%s' must have both up and down files", m.Version, m.Name)
+		}
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+
+	return ms, nil
+}
+
+func (mg *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = mg.locked(ctx, func(conn *sql.Conn, versions map[int64]bool) error {
+		for _, m := range mg.migrations {
+			if versions[m.Version] {
+				continue
+			}
+			if err := mg.apply(ctx, conn, m.Up, InsertSchemaVersion(), m.Version, m.Name); err != nil {
+				return fmt.Errorf("migration %d '%s' failed: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+func (mg *Migrator) Down(ctx context.Context, steps int) (reverted []Migration, err error) {
+	err = mg.locked(ctx, func(conn *sql.Conn, versions map[int64]bool) error {
+		for i := len(mg.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := mg.migrations[i]
+			if !versions[m.Version] {
+				continue
+			}
+			if err := mg.apply(ctx, conn, m.Down, DeleteSchemaVersion(), m.Version); err != nil {
+				return fmt.Errorf("migration %d '%s' revert failed: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+func (mg *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, versions map[int64]bool) error) (err error) {
+	conn, err := mg.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, LockMigrations(), migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, unlockErr := conn.ExecContext(context.Background(), UnlockMigrations(), migrationLockID); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, SchemaMigrationsTable()); err != nil {
+		return err
+	}
+
+	versions, err := schemaVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, versions)
+}
+
+func (mg *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, query string, args ...any) (err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func(tx *sql.Tx) {
+		if err != nil {
+			err = rollback(tx, err)
+		}
+	}(tx)
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+func schemaVersions(ctx context.Context, conn *sql.Conn) (versions map[int64]bool, err error) {
+	r, err := conn.QueryContext(ctx, SelectSchemaVersions())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(r *sql.Rows) {
+		if rErr := r.Close(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}(r)
+
+	versions = make(map[int64]bool)
+	for r.Next() {
+		var v int64
+		if err := r.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = true
+	}
+
+	return versions, r.Err()
+}
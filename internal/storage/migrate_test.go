@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "Migrations are ordered by version",
+			files: fstest.MapFS{
+				"m/0010_add_labels.up.sql":       {Data: []byte("ALTER TABLE metrics ADD labels;")},
+				"m/0010_add_labels.down.sql":     {Data: []byte("ALTER TABLE metrics DROP labels;")},
+				"m/0002_create_metrics.up.sql":   {Data: []byte("CREATE TABLE metrics;")},
+				"m/0002_create_metrics.down.sql": {Data: []byte("DROP TABLE metrics;")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "create_metrics", Up: "CREATE TABLE metrics;", Down: "DROP TABLE metrics;"},
+				{Version: 10, Name: "add_labels", Up: "ALTER TABLE metrics ADD labels;", Down: "ALTER TABLE metrics DROP labels;"},
+			},
+		},
+		{
+			name: "Missing down migration",
+			files: fstest.MapFS{
+				"m/0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics;")},
+			},
+			wantErr: "migration 1 'create_metrics' must have both up and down files",
+		},
+		{
+			name: "Conflicting names",
+			files: fstest.MapFS{
+				"m/0001_create_metrics.up.sql": {Data: []byte("CREATE TABLE metrics;")},
+				"m/0001_drop_metrics.down.sql": {Data: []byte("DROP TABLE metrics;")},
+			},
+			wantErr: "migration 1 has conflicting names 'create_metrics' and 'drop_metrics'",
+		},
+		{
+			name: "Invalid file name",
+			files: fstest.MapFS{
+				"m/create_metrics.sql": {Data: []byte("CREATE TABLE metrics;")},
+			},
+			wantErr: "invalid migration file name 'create_metrics.sql'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, err := LoadMigrations(tt.files, "m")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, ms)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	ms, err := LoadMigrations(migrationsFS, "migrations")
+	require.Nil(t, err)
+	require.NotEmpty(t, ms)
+
+	for i, m := range ms {
+		assert.Equal(t, int64(i+1), m.Version, "migration versions must be contiguous")
+	}
+}
+
+func TestMigratorApply(t *testing.T) {
+	db, err := sql.Open("hanging", "")
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	mg := &Migrator{db: db}
+	err = mg.apply(ctx, conn, "CREATE TABLE t (id int)", "INSERT INTO schema_migrations VALUES ($1)", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the rollback error does not hide the failed script")
+}
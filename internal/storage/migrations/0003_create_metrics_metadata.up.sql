@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS metrics_metadata (
+  id    VARCHAR(255) PRIMARY KEY,
+  unit  VARCHAR(64) NOT NULL DEFAULT '',
+  help  TEXT NOT NULL DEFAULT '',
+  owner VARCHAR(255) NOT NULL DEFAULT ''
+);
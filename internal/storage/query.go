@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+type Cursor struct {
+	Name string `json:"n"`
+	Type string `json:"t"`
+}
+
+type Query struct {
+	After  Cursor
+	Limit  int
+	Type   string
+	Prefix string
+	Match  string
+}
+
+var (
+	ErrInvalidCursor = fmt.Errorf("invalid cursor")
+	ErrInvalidGlob   = fmt.Errorf("invalid glob")
+)
+
+func CursorOf(m metric.IMetric) Cursor {
+	return Cursor{Name: m.Name(), Type: m.Type()}
+}
+
+func (c Cursor) IsZero() bool {
+	return c.Name == "" && c.Type == ""
+}
+
+func (c Cursor) Encode() string {
+	if c.IsZero() {
+		return ""
+	}
+
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c Cursor) Less(m metric.IMetric) bool {
+	if c.Name != m.Name() {
+		return c.Name < m.Name()
+	}
+
+	return c.Type < m.Type()
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	if s == "" {
+		return c, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func (q Query) matcher() (func(m metric.IMetric) bool, error) {
+	var re *regexp.Regexp
+	if q.Match != "" {
+		var err error
+		if re, err = CompileGlob(q.Match); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(m metric.IMetric) bool {
+		if !q.After.IsZero() && !q.After.Less(m) {
+			return false
+		}
+		if q.Type != "" && m.Type() != q.Type {
+			return false
+		}
+		if q.Prefix != "" && !strings.HasPrefix(m.Name(), q.Prefix) {
+			return false
+		}
+
+		return re == nil || re.MatchString(m.Name())
+	}, nil
+}
+
+// GlobToRegexp translates glob to an anchored regexp. A glob the regexp
+// syntax rejects, e.g. one with an empty class, gives ErrInvalidGlob.
+func GlobToRegexp(glob string) (string, error) {
+	re, err := CompileGlob(glob)
+	if err != nil {
+		return "", err
+	}
+
+	return re.String(), nil
+}
+
+func CompileGlob(glob string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(globPattern(glob))
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s'", ErrInvalidGlob, glob)
+	}
+
+	return re, nil
+}
+
+func globPattern(glob string) string {
+	rs := []rune(glob)
+
+	var b strings.Builder
+	b.WriteByte('^')
+
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '[':
+			end := indexRune(rs[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := string(rs[i+1 : i+1+end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(rs[i])))
+		}
+	}
+
+	b.WriteByte('$')
+
+	return b.String()
+}
+
+func indexRune(rs []rune, r rune) int {
+	for i, c := range rs {
+		if c == r {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+// hangingDriver answers every statement only once its context is done, like a
+// database that stopped responding in the middle of a transaction.
+type hangingDriver struct{}
+
+type hangingConn struct{}
+
+type hangingStmt struct{}
+
+type hangingTx struct{}
+
+func init() {
+	sql.Register("hanging", hangingDriver{})
+}
+
+func (hangingDriver) Open(string) (driver.Conn, error) {
+	return hangingConn{}, nil
+}
+
+func (hangingConn) Prepare(string) (driver.Stmt, error) {
+	return hangingStmt{}, nil
+}
+
+func (hangingConn) Close() error {
+	return nil
+}
+
+func (hangingConn) Begin() (driver.Tx, error) {
+	return hangingTx{}, nil
+}
+
+func (hangingStmt) Close() error {
+	return nil
+}
+
+func (hangingStmt) NumInput() int {
+	return -1
+}
+
+func (hangingStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (hangingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (hangingStmt) ExecContext(ctx context.Context, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingStmt) QueryContext(ctx context.Context, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingTx) Commit() error {
+	return nil
+}
+
+func (hangingTx) Rollback() error {
+	return errors.New("connection is gone")
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.allow())
+	b.failure()
+	assert.True(t, b.allow())
+	b.failure()
+	assert.False(t, b.allow(), "open after threshold failures")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow(), "probe after cooldown")
+	assert.False(t, b.allow(), "single probe while half-open")
+
+	b.failure()
+	assert.False(t, b.allow(), "failed probe opens again")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	b.abort()
+	assert.True(t, b.allow(), "aborted probe is released")
+
+	b.success()
+	assert.True(t, b.allow())
+	b.failure()
+	assert.True(t, b.allow(), "success resets failures")
+
+	var disabled *breaker
+	disabled.failure()
+	assert.True(t, disabled.allow())
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		unavailable bool
+		retryable   bool
+	}{
+		{name: "nil", err: nil},
+		{name: "not found", err: ErrMetricNotFound},
+		{name: "type conflict", err: fmt.Errorf("%w: Alloc", ErrTypeConflict)},
+		{name: "canceled", err: context.Canceled},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, retryable: true},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, retryable: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, unavailable: true, retryable: true},
+		{name: "too many connections", err: &pgconn.PgError{Code: "53300"}, unavailable: true, retryable: true},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, unavailable: true, retryable: true},
+		{name: "bad conn", err: driver.ErrBadConn, unavailable: true, retryable: true},
+		{name: "eof", err: io.ErrUnexpectedEOF, unavailable: true, retryable: true},
+		{name: "refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), unavailable: true, retryable: true},
+		{name: "deadline", err: context.DeadlineExceeded, unavailable: true},
+		{name: "commit", err: commitError{driver.ErrBadConn}, unavailable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.unavailable, unavailable(tt.err))
+			assert.Equal(t, tt.retryable, retryable(tt.err))
+		})
+	}
+}
+
+func TestDBStorageDo(t *testing.T) {
+	newDB := func(retries int) DBStorage {
+		return DBStorage{
+			opts:    DBOptions{QueryTimeout: time.Second, Retries: retries},
+			breaker: newBreaker(2, time.Minute),
+		}
+	}
+
+	t.Run("Transient errors are retried", func(t *testing.T) {
+		s := newDB(2)
+		calls := 0
+		err := s.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return driver.ErrBadConn
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("Other errors are returned as is", func(t *testing.T) {
+		s := newDB(2)
+		calls := 0
+		err := s.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return ErrTypeConflict
+		})
+		assert.ErrorIs(t, err, ErrTypeConflict)
+		assert.NotErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Failed commit is not retried", func(t *testing.T) {
+		s := newDB(2)
+		calls := 0
+		err := s.do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return commitError{driver.ErrBadConn}
+		})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Breaker opens on unreachable database", func(t *testing.T) {
+		s := newDB(0)
+		calls := 0
+		fail := func(ctx context.Context) error {
+			calls++
+			return syscall.ECONNREFUSED
+		}
+
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, s.do(context.Background(), fail), ErrUnavailable)
+		}
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Caller cancellation stops retries", func(t *testing.T) {
+		s := newDB(5)
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := s.do(ctx, func(ctx context.Context) error {
+			calls++
+			cancel()
+			return fmt.Errorf("query: %w", ctx.Err())
+		})
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, 1, calls)
+		assert.True(t, s.breaker.allow())
+	})
+	t.Run("Timed out transaction is reported as unavailable", func(t *testing.T) {
+		db, err := sql.Open("hanging", "")
+		require.NoError(t, err)
+		defer db.Close()
+
+		for _, policy := range []ConflictPolicy{ConflictReject, ConflictNamespace} {
+			s := DBStorage{
+				sql:     db,
+				policy:  policy,
+				opts:    DBOptions{QueryTimeout: 20 * time.Millisecond},
+				breaker: newBreaker(5, time.Minute),
+			}
+
+			_, err = s.BatchUpdate([]metric.IMetric{metric.NewGaugeMetric("Alloc", 1)})
+			assert.ErrorIs(t, err, ErrUnavailable, policy)
+
+			err = s.SetMetadata(map[string]metric.Metadata{"Alloc": {Unit: "bytes"}})
+			assert.ErrorIs(t, err, ErrUnavailable, policy)
+		}
+	})
+}
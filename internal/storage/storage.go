@@ -1,28 +1,236 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 )
 
 type Type int
 
+type ConflictPolicy string
+
+const (
+	ConflictReject         ConflictPolicy = "reject"
+	ConflictNamespace      ConflictPolicy = "namespace"
+	ConflictLastWriterWins ConflictPolicy = "last-writer-wins"
+)
+
+type metricKey struct {
+	name  string
+	mType string
+}
+
 type Storage interface {
 	Get(name string) (metric.IMetric, error)
-	Find(limit int, offset int) (map[string]metric.IMetric, error)
+	GetByType(name string, mType string) (metric.IMetric, error)
+	Find(q Query) ([]metric.IMetric, error)
 	Update(m metric.IMetric) (metric.IMetric, error)
-	BatchUpdate(sm []metric.IMetric) error
+	BatchUpdate(sm []metric.IMetric) ([]metric.IMetric, error)
+	Delete(name string) error
+	DeleteMatching(pattern string) ([]string, error)
+	DeleteStale(before time.Time) ([]string, error)
+	FindMetadata(names []string) (map[string]metric.Metadata, error)
+	SetMetadata(mds map[string]metric.Metadata) error
+	DeleteMetadata(name string) error
 }
 
-var ErrMetricNotFound error
+// ContextStorage is implemented by storages that can tie their work to the
+// caller's context, so a request that went away stops waiting on them.
+type ContextStorage interface {
+	GetContext(ctx context.Context, name string) (metric.IMetric, error)
+	GetByTypeContext(ctx context.Context, name string, mType string) (metric.IMetric, error)
+	FindContext(ctx context.Context, q Query) ([]metric.IMetric, error)
+	UpdateContext(ctx context.Context, m metric.IMetric) (metric.IMetric, error)
+	BatchUpdateContext(ctx context.Context, sm []metric.IMetric) ([]metric.IMetric, error)
+	DeleteContext(ctx context.Context, name string) error
+	DeleteMatchingContext(ctx context.Context, pattern string) ([]string, error)
+	DeleteStaleContext(ctx context.Context, before time.Time) ([]string, error)
+	FindMetadataContext(ctx context.Context, names []string) (map[string]metric.Metadata, error)
+	SetMetadataContext(ctx context.Context, mds map[string]metric.Metadata) error
+	DeleteMetadataContext(ctx context.Context, name string) error
+}
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type wrapper interface {
+	Unwrap() Storage
+}
+
+var ErrMetricNotFound = errors.New("metric not found")
+
+var ErrTypeConflict = fmt.Errorf("metric type conflict")
+
+var metricTypes = []string{metric.CounterType, metric.GaugeType}
+
+// NewStorage opens the backend for dsn. Without a policy each backend keeps
+// its original handling of type conflicts: postgres stores a row per type and
+// the others keep the last write.
+func NewStorage(dsn string, policy ConflictPolicy, shards int, dbOpts DBOptions) (Storage, error) {
+	if policy == "" {
+		policy = ConflictLastWriterWins
+		if dsn != "" && !IsBoltDSN(dsn) {
+			policy = ConflictNamespace
+		}
+	}
+
+	if IsBoltDSN(dsn) {
+		return NewBoltStorage(strings.TrimPrefix(dsn, boltScheme), policy)
+	}
 
-func NewStorage(dsn string) (Storage, error) {
 	if dsn != "" {
-		s, dbErr := NewDBStorage("pgx", dsn)
+		s, dbErr := NewDBStorage("pgx", dsn, policy, dbOpts)
 		if dbErr != nil {
 			return nil, dbErr
 		}
 		return s, nil
 	} else {
-		return NewMemStorage(), nil
+		ms := newShardedMemStorage(shards)
+		ms.policy = policy
+		return ms, nil
+	}
+}
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "", ConflictReject, ConflictNamespace, ConflictLastWriterWins:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown type conflict policy '%s'", s)
+	}
+}
+
+func keyOf(m metric.IMetric) metricKey {
+	return metricKey{m.Name(), m.Type()}
+}
+
+func otherType(mType string) string {
+	switch mType {
+	case metric.CounterType:
+		return metric.GaugeType
+	case metric.GaugeType:
+		return metric.CounterType
+	default:
+		return ""
+	}
+}
+
+func applyUpdate(policy ConflictPolicy, current metric.IMetric, conflict string, m metric.IMetric) (metric.IMetric, error) {
+	if m.Type() != metric.CounterType && m.Type() != metric.GaugeType {
+		return nil, fmt.Errorf("undefined metric type '%s'", m.Type())
+	}
+
+	if conflict != "" && policy != ConflictNamespace && policy != ConflictLastWriterWins {
+		return nil, fmt.Errorf("%w: metric '%s' is a %s, got %s", ErrTypeConflict, m.Name(), conflict, m.Type())
+	}
+
+	if current == nil || m.Type() == metric.GaugeType {
+		return m, nil
+	}
+
+	return m.Update(current)
+}
+
+func Unwrap(s Storage) Storage {
+	for {
+		w, ok := s.(wrapper)
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+func GetContext(ctx context.Context, s Storage, name string) (metric.IMetric, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.GetContext(ctx, name)
 	}
+
+	return s.Get(name)
+}
+
+func GetByTypeContext(ctx context.Context, s Storage, name string, mType string) (metric.IMetric, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.GetByTypeContext(ctx, name, mType)
+	}
+
+	return s.GetByType(name, mType)
+}
+
+func FindContext(ctx context.Context, s Storage, q Query) ([]metric.IMetric, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.FindContext(ctx, q)
+	}
+
+	return s.Find(q)
+}
+
+func UpdateContext(ctx context.Context, s Storage, m metric.IMetric) (metric.IMetric, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.UpdateContext(ctx, m)
+	}
+
+	return s.Update(m)
+}
+
+func BatchUpdateContext(ctx context.Context, s Storage, sm []metric.IMetric) ([]metric.IMetric, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.BatchUpdateContext(ctx, sm)
+	}
+
+	return s.BatchUpdate(sm)
+}
+
+func DeleteContext(ctx context.Context, s Storage, name string) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.DeleteContext(ctx, name)
+	}
+
+	return s.Delete(name)
+}
+
+func DeleteMatchingContext(ctx context.Context, s Storage, pattern string) ([]string, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.DeleteMatchingContext(ctx, pattern)
+	}
+
+	return s.DeleteMatching(pattern)
+}
+
+func DeleteStaleContext(ctx context.Context, s Storage, before time.Time) ([]string, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.DeleteStaleContext(ctx, before)
+	}
+
+	return s.DeleteStale(before)
+}
+
+func FindMetadataContext(ctx context.Context, s Storage, names []string) (map[string]metric.Metadata, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.FindMetadataContext(ctx, names)
+	}
+
+	return s.FindMetadata(names)
+}
+
+func SetMetadataContext(ctx context.Context, s Storage, mds map[string]metric.Metadata) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.SetMetadataContext(ctx, mds)
+	}
+
+	return s.SetMetadata(mds)
+}
+
+func DeleteMetadataContext(ctx context.Context, s Storage, name string) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.DeleteMetadataContext(ctx, name)
+	}
+
+	return s.DeleteMetadata(name)
 }
@@ -1,10 +1,20 @@
 package storage
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/1g0rbm/sysmonitor/internal/fs"
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 )
 
@@ -15,13 +25,14 @@ func TestRun(t *testing.T) {
 	tests := []struct {
 		name  string
 		data  map[string]metric.IMetric
-		data1 map[string]metric.IMetric
-		data2 map[string]metric.IMetric
+		data1 []metric.IMetric
+		data2 []metric.IMetric
 	}{
 		{
 			name:  "Success setting metrics",
 			data:  map[string]metric.IMetric{m1.Name(): m1, m2.Name(): m2},
-			data1: map[string]metric.IMetric{m2.Name(): m2},
+			data1: []metric.IMetric{m2},
+			data2: []metric.IMetric{},
 		},
 	}
 	for _, tt := range tests {
@@ -40,18 +51,18 @@ func TestRun(t *testing.T) {
 			assert.Equal(t, tt.data["PollCounter"], m2)
 
 			m3, err3 := s.Get("Undefined")
-			assert.Errorf(t, err3, "metric not found by name 'Undefined'")
+			assert.Errorf(t, err3, "metric not found: name 'Undefined'")
 			assert.Empty(t, m3)
 
-			ms, _ := s.Find(2, 0)
+			ms, _ := s.Find(Query{Limit: 2})
 			assert.Len(t, ms, 2)
-			assert.Equal(t, tt.data, ms)
+			assert.Equal(t, []metric.IMetric{m1, m2}, ms)
 
-			ms1, _ := s.Find(1, 1)
+			ms1, _ := s.Find(Query{After: CursorOf(ms[0]), Limit: 1})
 			assert.Len(t, ms1, 1)
 			assert.Equal(t, tt.data1, ms1)
 
-			ms2, _ := s.Find(10, 50)
+			ms2, _ := s.Find(Query{After: CursorOf(ms1[0]), Limit: 10})
 			assert.Len(t, ms2, 0)
 			assert.Equal(t, tt.data2, ms2)
 
@@ -71,3 +82,712 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestFind(t *testing.T) {
+	heapAlloc := metric.NewGaugeMetric("HeapAlloc", 1)
+	heapIdle := metric.NewGaugeMetric("HeapIdle", 2)
+	alloc := metric.NewGaugeMetric("Alloc", 3)
+	pollCount := metric.NewCounterMetric("PollCount", 4)
+
+	tests := []struct {
+		name  string
+		data  []metric.IMetric
+		query Query
+		want  []metric.IMetric
+	}{
+		{
+			name:  "Empty storage returns empty page",
+			data:  nil,
+			query: Query{Limit: 10},
+			want:  []metric.IMetric{},
+		},
+		{
+			name:  "Metrics are ordered by name",
+			data:  []metric.IMetric{pollCount, heapIdle, alloc, heapAlloc},
+			query: Query{},
+			want:  []metric.IMetric{alloc, heapAlloc, heapIdle, pollCount},
+		},
+		{
+			name:  "Cursor continues after the last seen metric",
+			data:  []metric.IMetric{pollCount, heapIdle, alloc, heapAlloc},
+			query: Query{After: CursorOf(heapAlloc), Limit: 1},
+			want:  []metric.IMetric{heapIdle},
+		},
+		{
+			name:  "Filter by type",
+			data:  []metric.IMetric{pollCount, heapIdle, alloc, heapAlloc},
+			query: Query{Type: metric.CounterType},
+			want:  []metric.IMetric{pollCount},
+		},
+		{
+			name:  "Filter by prefix",
+			data:  []metric.IMetric{pollCount, heapIdle, alloc, heapAlloc},
+			query: Query{Prefix: "Heap"},
+			want:  []metric.IMetric{heapAlloc, heapIdle},
+		},
+		{
+			name:  "Filter by glob",
+			data:  []metric.IMetric{pollCount, heapIdle, alloc, heapAlloc},
+			query: Query{Match: "*Alloc"},
+			want:  []metric.IMetric{alloc, heapAlloc},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemStorage()
+			for _, m := range tt.data {
+				_, err := s.Update(m)
+				require.Nil(t, err)
+			}
+
+			ms, err := s.Find(tt.query)
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, ms)
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := CursorOf(metric.NewGaugeMetric("Alloc", 1))
+
+	decoded, err := DecodeCursor(c.Encode())
+	require.Nil(t, err)
+	assert.Equal(t, c, decoded)
+
+	_, err = DecodeCursor("not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		want    string
+		wantErr error
+	}{
+		{glob: "Heap*", want: "^Heap.*$"},
+		{glob: "Poll?ount", want: "^Poll.ount$"},
+		{glob: "[!H]eap.x", want: `^[^H]eap\.x$`},
+		{glob: "[abc", want: `^\[abc$`},
+		{glob: "[]", wantErr: ErrInvalidGlob},
+		{glob: "[z-a]*", wantErr: ErrInvalidGlob},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			got, err := GlobToRegexp(tt.glob)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		delete  func(s *MemStorage) ([]string, error)
+		deleted []string
+		left    []string
+	}{
+		{
+			name: "Delete metric by name",
+			delete: func(s *MemStorage) ([]string, error) {
+				return []string{"Alloc"}, s.Delete("Alloc")
+			},
+			deleted: []string{"Alloc"},
+			left:    []string{"HeapAlloc", "HeapIdle", "PollCount"},
+		},
+		{
+			name: "Delete metrics matching glob",
+			delete: func(s *MemStorage) ([]string, error) {
+				return s.DeleteMatching("Heap*")
+			},
+			deleted: []string{"HeapAlloc", "HeapIdle"},
+			left:    []string{"Alloc", "PollCount"},
+		},
+		{
+			name: "Delete metrics not updated since",
+			delete: func(s *MemStorage) ([]string, error) {
+				return s.DeleteStale(start.Add(2 * time.Minute))
+			},
+			deleted: []string{"Alloc", "HeapAlloc"},
+			left:    []string{"HeapIdle", "PollCount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemStorage()
+			tick := 0
+			s.now = func() time.Time {
+				return start.Add(time.Duration(tick) * time.Minute)
+			}
+
+			for _, m := range []metric.IMetric{
+				metric.NewGaugeMetric("Alloc", 1),
+				metric.NewGaugeMetric("HeapAlloc", 2),
+				metric.NewGaugeMetric("HeapIdle", 3),
+				metric.NewCounterMetric("PollCount", 4),
+			} {
+				_, err := s.Update(m)
+				require.Nil(t, err)
+				tick++
+			}
+
+			deleted, err := tt.delete(s)
+			require.Nil(t, err)
+			assert.Equal(t, tt.deleted, deleted)
+
+			ms, err := s.Find(Query{})
+			require.Nil(t, err)
+
+			var left []string
+			for _, m := range ms {
+				left = append(left, m.Name())
+			}
+			assert.Equal(t, tt.left, left)
+
+			assert.Error(t, s.Delete("Undefined"))
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []map[string]metric.Metadata
+		want    map[string]metric.Metadata
+	}{
+		{
+			name: "Set metadata",
+			updates: []map[string]metric.Metadata{
+				{"Alloc": {Unit: "bytes", Help: "Allocated heap"}},
+			},
+			want: map[string]metric.Metadata{"Alloc": {Unit: "bytes", Help: "Allocated heap"}},
+		},
+		{
+			name: "Empty fields keep previous values",
+			updates: []map[string]metric.Metadata{
+				{"Alloc": {Unit: "bytes", Help: "Allocated heap"}},
+				{"Alloc": {Owner: "runtime"}, "PollCount": {Unit: "count"}},
+			},
+			want: map[string]metric.Metadata{
+				"Alloc":     {Unit: "bytes", Help: "Allocated heap", Owner: "runtime"},
+				"PollCount": {Unit: "count"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemStorage()
+			for _, mds := range tt.updates {
+				require.Nil(t, s.SetMetadata(mds))
+			}
+
+			mds, err := s.FindMetadata([]string{"Alloc", "PollCount", "Undefined"})
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, mds)
+
+			require.Nil(t, s.DeleteMetadata("Alloc"))
+			mds, err = s.FindMetadata([]string{"Alloc"})
+			require.Nil(t, err)
+			assert.Empty(t, mds)
+		})
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "metrics.json")
+	walPath := filepath.Join(dir, "metrics.wal")
+
+	tests := []struct {
+		name   string
+		backup bool
+	}{
+		{
+			name:   "Replay wal without snapshot",
+			backup: false,
+		},
+		{
+			name:   "Replay wal on top of snapshot",
+			backup: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Nil(t, os.RemoveAll(snapshot))
+			require.Nil(t, os.RemoveAll(walPath))
+
+			wal, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+			require.Nil(t, err)
+
+			s := newMemStorage()
+			s.SetWAL(wal)
+
+			_, err = s.Update(metric.NewCounterMetric("PollCount", 3))
+			require.Nil(t, err)
+			_, err = s.Update(metric.NewGaugeMetric("Alloc", 1))
+			require.Nil(t, err)
+			if tt.backup {
+				require.Nil(t, s.BackupData(snapshot))
+			}
+			_, err = s.Update(metric.NewCounterMetric("PollCount", 4))
+			require.Nil(t, err)
+			_, err = s.Update(metric.NewGaugeMetric("HeapIdle", 2))
+			require.Nil(t, err)
+			require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"HeapIdle": {Unit: "bytes"}}))
+			require.Nil(t, s.Delete("Alloc"))
+
+			// Simulate a crash: the wal is not closed and no snapshot is written.
+			recovered, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+			require.Nil(t, err)
+
+			r := newMemStorage()
+			r.SetWAL(recovered)
+			_, err = r.Restore(snapshot)
+			require.Nil(t, err)
+
+			want, err := s.Find(Query{})
+			require.Nil(t, err)
+			got, err := r.Find(Query{})
+			require.Nil(t, err)
+			assert.Equal(t, want, got)
+
+			mds, err := r.FindMetadata([]string{"HeapIdle"})
+			require.Nil(t, err)
+			assert.Equal(t, map[string]metric.Metadata{"HeapIdle": {Unit: "bytes"}}, mds)
+
+			require.Nil(t, r.BackupData(snapshot))
+			info, err := os.Stat(walPath)
+			require.Nil(t, err)
+			assert.Equal(t, int64(0), info.Size())
+
+			require.Nil(t, r.CloseWAL())
+			require.Nil(t, s.CloseWAL())
+		})
+	}
+}
+
+func TestRestoreFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		opts fs.SnapshotOptions
+	}{
+		{
+			name: "Restore JSON snapshot",
+			file: "metrics.json",
+		},
+		{
+			name: "Restore binary snapshot",
+			file: "metrics.bin",
+		},
+		{
+			name: "Restore gzipped binary snapshot chosen by config",
+			file: "metrics.snapshot",
+			opts: fs.SnapshotOptions{Format: fs.FormatBinaryGzip},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+
+			s := newMemStorage()
+			s.SetSnapshotOptions(tt.opts)
+			_, err := s.Update(metric.NewCounterMetric("PollCount", 3))
+			require.Nil(t, err)
+			_, err = s.Update(metric.NewGaugeMetric("Alloc", 1.25))
+			require.Nil(t, err)
+			require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}))
+			require.Nil(t, s.BackupData(path))
+
+			r := newMemStorage()
+			restored, err := r.Restore(path)
+			require.Nil(t, err)
+			assert.Equal(t, path, restored)
+
+			want, err := s.Find(Query{})
+			require.Nil(t, err)
+			got, err := r.Find(Query{})
+			require.Nil(t, err)
+			assert.Equal(t, want, got)
+
+			mds, err := r.FindMetadata([]string{"Alloc"})
+			require.Nil(t, err)
+			assert.Equal(t, map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}, mds)
+		})
+	}
+}
+
+func TestRestoreCorruptSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s := newMemStorage()
+	s.SetSnapshotOptions(fs.SnapshotOptions{Keep: 2})
+	_, err := s.Update(metric.NewGaugeMetric("Alloc", 1))
+	require.Nil(t, err)
+	require.Nil(t, s.BackupData(path))
+	_, err = s.Update(metric.NewGaugeMetric("Alloc", 2))
+	require.Nil(t, err)
+	require.Nil(t, s.BackupData(path))
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	data[len(data)-5] ^= 0xff
+	require.Nil(t, os.WriteFile(path, data, 0664))
+
+	r := newMemStorage()
+	r.SetSnapshotOptions(fs.SnapshotOptions{Keep: 2})
+	restored, err := r.Restore(path)
+	require.Nil(t, err)
+	assert.Equal(t, path+".1", restored)
+
+	m, err := r.Get("Alloc")
+	require.Nil(t, err)
+	assert.Equal(t, "1", m.ValueAsString())
+
+	r = newMemStorage()
+	_, err = r.Restore(path)
+	assert.ErrorIs(t, err, fs.ErrCorruptSnapshot, "rotated snapshots are only used when kept")
+}
+
+func TestNewStorageDefaultPolicy(t *testing.T) {
+	for _, dsn := range []string{"", "bolt://" + filepath.Join(t.TempDir(), "sysmon.db")} {
+		s, err := NewStorage(dsn, "", 1, DBOptions{})
+		require.Nil(t, err)
+
+		_, err = s.Update(metric.NewGaugeMetric("Alloc", 1))
+		require.Nil(t, err)
+		_, err = s.Update(metric.NewCounterMetric("Alloc", 2))
+		require.Nil(t, err, "the last write wins by default")
+
+		m, err := s.Get("Alloc")
+		require.Nil(t, err)
+		assert.Equal(t, metric.NewCounterMetric("Alloc", 2), m)
+
+		if c, ok := s.(io.Closer); ok {
+			require.Nil(t, c.Close())
+		}
+	}
+}
+
+func TestBoltStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sysmon.db")
+
+	s, err := NewStorage("bolt://"+path, ConflictReject, 1, DBOptions{})
+	require.Nil(t, err)
+	bs, ok := s.(*BoltStorage)
+	require.True(t, ok)
+
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	tick := 0
+	bs.now = func() time.Time {
+		return start.Add(time.Duration(tick) * time.Minute)
+	}
+
+	result, err := s.BatchUpdate([]metric.IMetric{
+		metric.NewCounterMetric("PollCount", 2),
+		metric.NewGaugeMetric("Alloc", 1.5),
+		metric.NewCounterMetric("PollCount", 3),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []metric.IMetric{
+		metric.NewCounterMetric("PollCount", 2),
+		metric.NewGaugeMetric("Alloc", 1.5),
+		metric.NewCounterMetric("PollCount", 5),
+	}, result)
+
+	_, err = s.BatchUpdate([]metric.IMetric{
+		metric.NewCounterMetric("PollCount", 1),
+		metric.NewCounterMetric("Alloc", 1),
+	})
+	assert.Error(t, err, "counter increment over a gauge fails the whole batch")
+
+	m, err := s.Get("PollCount")
+	require.Nil(t, err)
+	assert.Equal(t, "5", m.ValueAsString())
+
+	tick++
+	_, err = s.Update(metric.NewGaugeMetric("HeapAlloc", 2))
+	require.Nil(t, err)
+	tick++
+	_, err = s.Update(metric.NewGaugeMetric("HeapIdle", 3))
+	require.Nil(t, err)
+
+	ms, err := s.Find(Query{After: Cursor{Name: "Alloc", Type: metric.GaugeType}, Limit: 2})
+	require.Nil(t, err)
+	assert.Equal(t, []metric.IMetric{metric.NewGaugeMetric("HeapAlloc", 2), metric.NewGaugeMetric("HeapIdle", 3)}, ms)
+
+	ms, err = s.Find(Query{Type: metric.CounterType})
+	require.Nil(t, err)
+	assert.Equal(t, []metric.IMetric{metric.NewCounterMetric("PollCount", 5)}, ms)
+
+	require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}))
+	require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"Alloc": {Help: "Allocated heap"}}))
+
+	deleted, err := s.DeleteStale(start.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"Alloc", "PollCount"}, deleted)
+
+	deleted, err = s.DeleteMatching("Heap*")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"HeapAlloc", "HeapIdle"}, deleted)
+
+	_, err = s.Get("Alloc")
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+	assert.ErrorIs(t, s.Delete("Alloc"), ErrMetricNotFound)
+
+	_, err = s.Update(metric.NewCounterMetric("PollCount", 7))
+	require.Nil(t, err)
+	require.Nil(t, bs.Close())
+
+	s, err = NewStorage("bolt://"+path, ConflictReject, 1, DBOptions{})
+	require.Nil(t, err)
+	defer s.(*BoltStorage).Close()
+
+	m, err = s.Get("PollCount")
+	require.Nil(t, err)
+	assert.Equal(t, "7", m.ValueAsString())
+
+	mds, err := s.FindMetadata([]string{"Alloc", "Undefined"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]metric.Metadata{"Alloc": {Unit: "bytes", Help: "Allocated heap"}}, mds)
+
+	require.Nil(t, s.Delete("PollCount"))
+	require.Nil(t, s.DeleteMetadata("Alloc"))
+	mds, err = s.FindMetadata([]string{"Alloc"})
+	require.Nil(t, err)
+	assert.Empty(t, mds)
+}
+
+func TestWALRecoveryTypeConflicts(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictNamespace, ConflictLastWriterWins} {
+		t.Run(string(policy), func(t *testing.T) {
+			walPath := filepath.Join(t.TempDir(), "metrics.wal")
+
+			wal, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+			require.Nil(t, err)
+			defer wal.Close()
+
+			s := newMemStorage()
+			s.policy = policy
+			s.SetWAL(wal)
+
+			_, err = s.Update(metric.NewGaugeMetric("Alloc", 1))
+			require.Nil(t, err)
+			_, err = s.Update(metric.NewCounterMetric("Alloc", 2))
+			require.Nil(t, err)
+			_, err = s.DeleteStale(time.Now().Add(time.Hour))
+			require.Nil(t, err)
+			_, err = s.BatchUpdate([]metric.IMetric{metric.NewGaugeMetric("PollCount", 1), metric.NewCounterMetric("PollCount", 3)})
+			require.Nil(t, err)
+
+			recovered, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+			require.Nil(t, err)
+			defer recovered.Close()
+
+			r := newMemStorage()
+			r.policy = policy
+			r.SetWAL(recovered)
+			_, err = r.Restore(filepath.Join(t.TempDir(), "missing.json"))
+			require.Nil(t, err)
+
+			want, err := s.Find(Query{})
+			require.Nil(t, err)
+			got, err := r.Find(Query{})
+			require.Nil(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestShardedMemStorage(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "metrics.json")
+	walPath := filepath.Join(dir, "metrics.wal")
+
+	wal, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+	require.Nil(t, err)
+
+	s := newShardedMemStorage(8)
+	s.SetWAL(wal)
+
+	var batch []metric.IMetric
+	for i := 0; i < 100; i++ {
+		batch = append(batch,
+			metric.NewCounterMetric(fmt.Sprintf("Counter%02d", i), metric.Counter(i)),
+			metric.NewGaugeMetric(fmt.Sprintf("Gauge%02d", i), metric.Gauge(i)),
+		)
+	}
+	_, err = s.BatchUpdate(batch)
+	require.Nil(t, err)
+	require.Nil(t, s.SetMetadata(map[string]metric.Metadata{"Gauge00": {Unit: "bytes"}, "Counter99": {Unit: "count"}}))
+
+	used := 0
+	for _, sh := range s.shards {
+		if len(sh.data) > 0 {
+			used++
+		}
+	}
+	assert.Equal(t, 8, used)
+
+	var names []string
+	q := Query{Limit: 7}
+	for {
+		ms, err := s.Find(q)
+		require.Nil(t, err)
+		if len(ms) == 0 {
+			break
+		}
+		for _, m := range ms {
+			names = append(names, m.Name())
+		}
+		q.After = CursorOf(ms[len(ms)-1])
+	}
+	require.Len(t, names, 200)
+	assert.True(t, sort.StringsAreSorted(names))
+
+	require.Nil(t, s.BackupData(snapshot))
+	require.Nil(t, s.Delete("Counter00"))
+	require.Nil(t, s.CloseWAL())
+
+	recovered, err := fs.OpenWAL(walPath, fs.SyncAlways, 0)
+	require.Nil(t, err)
+	defer recovered.Close()
+
+	// A different shard count must not matter for restored data.
+	r := newShardedMemStorage(3)
+	r.SetWAL(recovered)
+	_, err = r.Restore(snapshot)
+	require.Nil(t, err)
+
+	want, err := s.Find(Query{})
+	require.Nil(t, err)
+	got, err := r.Find(Query{})
+	require.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	mds, err := r.FindMetadata([]string{"Gauge00", "Counter99"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]metric.Metadata{"Gauge00": {Unit: "bytes"}, "Counter99": {Unit: "count"}}, mds)
+}
+
+// Run with -race: misses on different shards must not share any state.
+func TestShardedMemStorageConcurrentMisses(t *testing.T) {
+	s := newShardedMemStorage(16)
+
+	names := make([]string, 0, 16)
+	used := make(map[int]bool)
+	for i := 0; len(names) < 8; i++ {
+		name := fmt.Sprintf("Missing%d", i)
+		if !used[s.shardIndex(name)] {
+			used[s.shardIndex(name)] = true
+			names = append(names, name)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([][]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				_, err := s.Get(name)
+				errs[i] = append(errs[i], err)
+				_, err = s.GetByType(name, metric.GaugeType)
+				errs[i] = append(errs[i], err)
+				errs[i] = append(errs[i], s.Delete(name))
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		for _, err := range errs[i] {
+			assert.ErrorIs(t, err, ErrMetricNotFound)
+			assert.Contains(t, err.Error(), "'"+name+"'")
+		}
+	}
+}
+
+func BenchmarkMemStorageUpdate(b *testing.B) {
+	names := make([]string, 256)
+	for i := range names {
+		names[i] = fmt.Sprintf("Metric%d", i)
+	}
+
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s := newShardedMemStorage(shards)
+			var seq atomic.Uint64
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(seq.Add(1)) * 7919
+				for pb.Next() {
+					i++
+					if _, err := s.Update(metric.NewCounterMetric(names[i%len(names)], 1)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+type recordingFeed struct {
+	records []fs.WALRecord
+}
+
+func (f *recordingFeed) Append(records ...fs.WALRecord) error {
+	f.records = append(f.records, records...)
+	return nil
+}
+
+func TestMemStorageReplication(t *testing.T) {
+	primary := newShardedMemStorage(4)
+	feed := &recordingFeed{}
+	primary.SetFeed(feed)
+
+	_, err := primary.BatchUpdate([]metric.IMetric{
+		metric.NewCounterMetric("PollCount", 5),
+		metric.NewGaugeMetric("Alloc", 1),
+		metric.NewGaugeMetric("HeapIdle", 2),
+	})
+	require.Nil(t, err)
+	require.Nil(t, primary.SetMetadata(map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}))
+
+	snapshot, err := primary.Snapshot()
+	require.Nil(t, err)
+	feed.records = nil
+
+	replica := newMemStorage()
+	_, err = replica.Update(metric.NewGaugeMetric("Stale", 1))
+	require.Nil(t, err)
+	require.Nil(t, replica.SetMetadata(map[string]metric.Metadata{"Stale": {Unit: "bytes"}}))
+	require.Nil(t, replica.Reset(snapshot...))
+
+	_, err = primary.Update(metric.NewCounterMetric("PollCount", 1))
+	require.Nil(t, err)
+	require.Nil(t, primary.Delete("HeapIdle"))
+	require.Nil(t, replica.Apply(feed.records...))
+
+	want, err := primary.Find(Query{})
+	require.Nil(t, err)
+	got, err := replica.Find(Query{})
+	require.Nil(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, []metric.IMetric{
+		metric.NewGaugeMetric("Alloc", 1),
+		metric.NewCounterMetric("PollCount", 6),
+	}, got)
+
+	mds, err := replica.FindMetadata([]string{"Alloc", "Stale"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]metric.Metadata{"Alloc": {Unit: "bytes"}}, mds)
+}
@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+const defaultBufferSize = 64
+
+type Subscriber struct {
+	names  map[string]struct{}
+	events chan metric.Metrics
+	done   chan struct{}
+	once   sync.Once
+}
+
+type Hub struct {
+	subscribers map[*Subscriber]struct{}
+	bufferSize  int
+	mu          sync.RWMutex
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	return &Hub{
+		subscribers: make(map[*Subscriber]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+func (h *Hub) Subscribe(names []string) *Subscriber {
+	s := &Subscriber{
+		names:  make(map[string]struct{}, len(names)),
+		events: make(chan metric.Metrics, h.bufferSize),
+		done:   make(chan struct{}),
+	}
+	for _, name := range names {
+		if name != "" {
+			s.names[name] = struct{}{}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+
+	s.close()
+}
+
+func (h *Hub) Close() {
+	h.mu.Lock()
+	subscribers := h.subscribers
+	h.subscribers = make(map[*Subscriber]struct{})
+	h.mu.Unlock()
+
+	for s := range subscribers {
+		s.close()
+	}
+}
+
+func (h *Hub) HasSubscribers() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.subscribers) > 0
+}
+
+func (h *Hub) Publish(ms ...metric.IMetric) {
+	if !h.HasSubscribers() {
+		return
+	}
+
+	events := make([]metric.Metrics, 0, len(ms))
+	for _, m := range ms {
+		e, err := metric.NewMetricsFromIMetric(m)
+		if err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	var slow []*Subscriber
+
+	h.mu.RLock()
+	for s := range h.subscribers {
+		if !s.send(events) {
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		h.Unsubscribe(s)
+	}
+}
+
+func (s *Subscriber) Events() <-chan metric.Metrics {
+	return s.events
+}
+
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) send(events []metric.Metrics) bool {
+	for _, e := range events {
+		if !s.wants(e.ID) {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Subscriber) wants(name string) bool {
+	if len(s.names) == 0 {
+		return true
+	}
+
+	_, ok := s.names[name]
+
+	return ok
+}
+
+func (s *Subscriber) close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestHubPublish(t *testing.T) {
+	alloc := metric.NewGaugeMetric("Alloc", 1.5)
+	poll := metric.NewCounterMetric("PollCount", 3)
+
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{
+			name:  "Subscriber without filter receives all metrics",
+			names: nil,
+			want:  []string{"Alloc", "PollCount"},
+		},
+		{
+			name:  "Subscriber with filter receives only selected metrics",
+			names: []string{"PollCount"},
+			want:  []string{"PollCount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(10)
+			s := h.Subscribe(tt.names)
+			defer h.Unsubscribe(s)
+
+			h.Publish(alloc, poll)
+
+			var got []string
+			for len(s.Events()) > 0 {
+				e := <-s.Events()
+				got = append(got, e.ID)
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHubEvictsSlowConsumer(t *testing.T) {
+	h := NewHub(1)
+	slow := h.Subscribe(nil)
+	fast := h.Subscribe(nil)
+
+	h.Publish(metric.NewCounterMetric("PollCount", 1))
+	<-fast.Events()
+	h.Publish(metric.NewCounterMetric("PollCount", 2))
+
+	select {
+	case <-slow.Done():
+	default:
+		require.Fail(t, "slow subscriber should be evicted")
+	}
+
+	select {
+	case <-fast.Done():
+		require.Fail(t, "fast subscriber should stay subscribed")
+	default:
+	}
+
+	e := <-fast.Events()
+	assert.Equal(t, int64(2), *e.Delta)
+	assert.True(t, h.HasSubscribers())
+
+	h.Close()
+
+	assert.False(t, h.HasSubscribers())
+	<-fast.Done()
+}
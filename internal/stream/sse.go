@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+func ServeSSE(ctx context.Context, w http.ResponseWriter, s *Subscriber) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e := <-s.Events():
+			b, err := e.Encode()
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: metric\ndata: %s\n\n", b); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+		case <-s.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/1g0rbm/sysmonitor/internal/metric"
+)
+
+func TestServeWebSocket(t *testing.T) {
+	h := NewHub(10)
+	defer h.Close()
+
+	subscribed := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := h.Subscribe([]string{"PollCount"})
+		defer h.Unsubscribe(s)
+		close(subscribed)
+		_ = ServeWebSocket(r.Context(), w, r, s)
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	<-subscribed
+	h.Publish(metric.NewGaugeMetric("Alloc", 1), metric.NewCounterMetric("PollCount", 7))
+
+	head := make([]byte, 2)
+	_, err = io.ReadFull(r, head)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x81), head[0])
+
+	payload := make([]byte, head[1])
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"PollCount","type":"counter","delta":7}`, string(payload))
+}
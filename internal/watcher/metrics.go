@@ -9,6 +9,48 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+const (
+	unitBytes       = "bytes"
+	unitCount       = "count"
+	unitNanoseconds = "nanoseconds"
+	unitPercent     = "percent"
+	unitRatio       = "ratio"
+)
+
+var metricUnits = map[string]string{
+	"Alloc":           unitBytes,
+	"BuckHashSys":     unitBytes,
+	"Frees":           unitCount,
+	"GCCPUFraction":   unitRatio,
+	"GCSys":           unitBytes,
+	"HeapAlloc":       unitBytes,
+	"HeapIdle":        unitBytes,
+	"HeapInuse":       unitBytes,
+	"HeapObjects":     unitCount,
+	"HeapReleased":    unitBytes,
+	"HeapSys":         unitBytes,
+	"LastGC":          unitNanoseconds,
+	"Lookups":         unitCount,
+	"MCacheInuse":     unitBytes,
+	"MCacheSys":       unitBytes,
+	"MSpanInuse":      unitBytes,
+	"MSpanSys":        unitBytes,
+	"Mallocs":         unitCount,
+	"NextGC":          unitBytes,
+	"NumForcedGC":     unitCount,
+	"NumGC":           unitCount,
+	"OtherSys":        unitBytes,
+	"PauseTotalNs":    unitNanoseconds,
+	"StackInuse":      unitBytes,
+	"StackSys":        unitBytes,
+	"Sys":             unitBytes,
+	"TotalAlloc":      unitBytes,
+	"TotalMemory":     unitBytes,
+	"FreeMemory":      unitBytes,
+	"CPUutilization1": unitPercent,
+	"PollCount":       unitCount,
+}
+
 type gMetrics struct {
 	m  map[string]metric.Gauge
 	mu sync.RWMutex
@@ -90,6 +90,7 @@ func (p *poller) getBatch() (metric.MetricsBatch, error) {
 	for name, value := range p.gm.m {
 		v := float64(value)
 		m, _ := metric.NewMetrics(name, metric.GaugeType, nil, &v)
+		m.Unit = metricUnits[name]
 		if p.config.NeedSign() {
 			sgnErr := m.Sign(p.config.Key)
 			if sgnErr != nil {
@@ -102,6 +103,7 @@ func (p *poller) getBatch() (metric.MetricsBatch, error) {
 	for name, value := range p.cm {
 		v := int64(value)
 		m, _ := metric.NewMetrics(name, metric.CounterType, &v, nil)
+		m.Unit = metricUnits[name]
 		if p.config.NeedSign() {
 			sgnErr := m.Sign(p.config.Key)
 			if sgnErr != nil {
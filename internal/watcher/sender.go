@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/1g0rbm/sysmonitor/internal/agents"
 	"github.com/1g0rbm/sysmonitor/internal/config"
 	"github.com/1g0rbm/sysmonitor/internal/metric"
 )
@@ -71,6 +72,10 @@ func (s *sender) sendMetrics(url string, b metric.MetricsBatch) error {
 		return err
 	}
 	request.Header.Add("Content-Type", "application/json")
+	request.Header.Add(agents.ReportIntervalHeader, s.config.ReportInterval.String())
+	if s.config.ID != "" {
+		request.Header.Add(agents.IDHeader, s.config.ID)
+	}
 
 	response, rErr := client.Do(request.WithContext(ctx))
 	if rErr != nil {